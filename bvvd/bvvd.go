@@ -5,6 +5,7 @@ package bvvd
 import (
 	"fmt"
 	"net/netip"
+	"slices"
 
 	"github.com/lysShub/netkit/packet"
 	"github.com/pkg/errors"
@@ -12,6 +13,17 @@ import (
 	"gvisor.dev/gvisor/pkg/tcpip/header"
 )
 
+// Bvvd bvvd header, has ipv4 and ipv6 layout:
+//
+//	ipv4: kind-proto(1) data-id(1) client(4+2) forward(4+2) server(4) [extension]
+//	ipv6: kind-proto(1) data-id(1) client(16+2) forward(16+2) server(16) [extension]
+//	extension: version(1) options-length(1) options(tlv)
+//
+// ipv6 layout is marked by the highest bit of kind-proto, ipv4 address
+// in ipv6 layout is stored as ipv4-mapped ipv6 address.
+//
+// extension area is marked by the second highest bit of kind-proto, header
+// without extension is version 0, that is compatible with legacy peers.
 type Bvvd []byte
 
 func (b Bvvd) Kind() Kind {
@@ -32,6 +44,47 @@ func (b Bvvd) SetProto(proto tcpip.TransportProtocolNumber) {
 	b[0] = byte(e)
 }
 
+// Is6 header is ipv6 layout
+func (b Bvvd) Is6() bool {
+	return kindproto(b[0]).is6()
+}
+
+// Len header length, include extension area
+func (b Bvvd) Len() int {
+	n := b.layout().size
+	if kindproto(b[0]).ext() {
+		if len(b) < n+extSize {
+			return n + extSize
+		}
+		n += extSize + int(b[n+1])
+	}
+	return n
+}
+
+// Version header version, 0 means legacy header without extension area
+func (b Bvvd) Version() uint8 {
+	if !kindproto(b[0]).ext() {
+		return 0
+	}
+	return b[b.layout().size]
+}
+
+// Option get option value from extension area, the value refer header buffer
+func (b Bvvd) Option(typ OptionType) (value []byte, has bool) {
+	if !kindproto(b[0]).ext() {
+		return nil, false
+	}
+
+	n := b.layout().size
+	rangeOptions(b[n+extSize:b.Len()], func(t OptionType, v []byte) bool {
+		if t == typ {
+			value, has = v, true
+		}
+		return !has
+	})
+	return value, has
+}
+
 func (b Bvvd) DataID() uint8 {
 	return b[1]
 }
@@ -40,43 +93,116 @@ func (b Bvvd) SetDataID(id uint8) {
 }
 
 func (b Bvvd) Client() netip.AddrPort {
-	return netip.AddrPortFrom(
-		netip.AddrFrom4([4]byte(b[2:])),
-		uint16(b[6])+uint16(b[7])<<8,
-	)
+	return b.addrPort(b.layout().client)
 }
 func (b Bvvd) SetClient(caddr netip.AddrPort) {
-	if !caddr.Addr().Is4() {
-		panic("only support ipv4")
-	}
-	copy(b[2:], caddr.Addr().AsSlice())
-	b[6] = byte(caddr.Port())
-	b[7] = byte(caddr.Port() >> 8)
+	b.setAddrPort(b.layout().client, caddr)
 }
 
 func (b Bvvd) Forward() netip.AddrPort {
-	return netip.AddrPortFrom(
-		netip.AddrFrom4([4]byte(b[8:])),
-		uint16(b[12])+uint16(b[13])<<8,
-	)
+	return b.addrPort(b.layout().forward)
 }
 func (b Bvvd) SetForward(faddr netip.AddrPort) {
-	if !faddr.Addr().Is4() {
-		panic("only support ipv4")
-	}
-	copy(b[8:], faddr.Addr().AsSlice())
-	b[12] = byte(faddr.Port())
-	b[13] = byte(faddr.Port() >> 8)
+	b.setAddrPort(b.layout().forward, faddr)
 }
 
 func (b Bvvd) Server() netip.Addr {
-	return netip.AddrFrom4([4]byte(b[14:]))
+	return b.addr(b.layout().server)
 }
 func (b Bvvd) SetServer(server netip.Addr) {
-	if !server.Is4() {
-		panic("only support ipv4")
+	b.setAddr(b.layout().server, server)
+}
+
+func (b Bvvd) layout() layout {
+	if b.Is6() {
+		return layouts[1]
 	}
-	copy(b[14:], server.AsSlice())
+	return layouts[0]
+}
+
+func (b Bvvd) addr(off int) netip.Addr {
+	if b.Is6() {
+		return netip.AddrFrom16([16]byte(b[off:])).Unmap()
+	}
+	return netip.AddrFrom4([4]byte(b[off:]))
+}
+
+func (b Bvvd) setAddr(off int, addr netip.Addr) {
+	if b.Is6() {
+		a := addr.As16()
+		copy(b[off:off+16], a[:])
+	} else {
+		if !addr.Unmap().Is4() {
+			panic("ipv4 header not support address " + addr.String())
+		}
+		a := addr.Unmap().As4()
+		copy(b[off:off+4], a[:])
+	}
+}
+
+func (b Bvvd) addrPort(off int) netip.AddrPort {
+	n := b.layout().addrSize
+	return netip.AddrPortFrom(
+		b.addr(off),
+		uint16(b[off+n])+uint16(b[off+n+1])<<8,
+	)
+}
+
+func (b Bvvd) setAddrPort(off int, addr netip.AddrPort) {
+	n := b.layout().addrSize
+	b.setAddr(off, addr.Addr())
+	b[off+n] = byte(addr.Port())
+	b[off+n+1] = byte(addr.Port() >> 8)
+}
+
+type layout struct {
+	addrSize                int
+	client, forward, server int // offset
+	size                    int
+}
+
+var layouts = [2]layout{
+	{addrSize: 4, client: 2, forward: 8, server: 14, size: Size},
+	{addrSize: 16, client: 2, forward: 20, server: 38, size: Size6},
+}
+
+// Fit convert the header in pkt to ipv6 layout, if any of addrs can't be
+// stored in ipv4 layout, returns the (maybe new) header.
+func Fit(pkt *packet.Packet, addrs ...netip.Addr) Bvvd {
+	hdr := Bvvd(pkt.Bytes())
+	if hdr.Is6() || !slices.ContainsFunc(addrs, is6) {
+		return hdr
+	}
+
+	old := Bvvd(slices.Clone(hdr[:Size]))
+	hdr = Bvvd(pkt.AttachN(Size6 - Size).Bytes())
+	hdr[0] = old[0] | byte(flag6)
+	hdr[1] = old[1]
+	hdr.SetClient(old.Client())
+	hdr.SetForward(old.Forward())
+	hdr.SetServer(old.Server())
+	return hdr
+}
+
+// Strip remove extension area of the header in pkt, for peer not support
+// extension, returns the (maybe new) header.
+func Strip(pkt *packet.Packet) Bvvd {
+	hdr := Bvvd(pkt.Bytes())
+	if !kindproto(hdr[0]).ext() {
+		return hdr
+	}
+
+	n := hdr.layout().size
+	ext := hdr.Len() - n
+	copy(hdr[ext:], hdr[:n])
+	hdr = Bvvd(pkt.DetachN(ext).Bytes())
+	hdr[0] &^= byte(flagExt)
+	return hdr
+}
+
+// is6 addr can't be stored in ipv4 layout
+func is6(addr netip.Addr) bool {
+	return addr.Is6() && !addr.Is4In6()
 }
 
 type Fields struct {
@@ -86,14 +212,27 @@ type Fields struct {
 	Client  netip.AddrPort                // opt, client addr, set by gateway
 	Forward netip.AddrPort                // opt, forward
 	Server  netip.Addr                    // opt, destination ip
+	Version uint8                         // opt, 0 will encode legacy header without extension area
+	Options Options                       // opt, extension options, require Version not 0
 }
 
 const MaxID = 0xff
+
+// Size ipv4 layout header size, also is the minimum header size
 const Size = 18
 
+// Size6 ipv6 layout header size
+const Size6 = 54
+
+// Version current header version
+const Version = 1
+
+// extension area fixed size: version(1) options-length(1)
+const extSize = 2
+
 func (h Fields) Valid() error {
 	switch h.Kind {
-	case Data:
+	case Data, Parity:
 		if h.Proto != header.TCPProtocolNumber && h.Proto != header.UDPProtocolNumber {
 			return errors.Errorf("proto %d", h.Proto)
 		}
@@ -115,13 +254,29 @@ func (h Fields) Valid() error {
 	case PackLossClientUplink:
 	case PackLossGatewayUplink:
 	case PackLossGatewayDownlink:
+	case Session:
+	case Unreachable:
+		if !h.Forward.IsValid() {
+			return errors.New("forward invalid")
+		}
 	default:
 		return h.Kind.Valid()
 	}
 
+	if len(h.Options) > 0 {
+		if h.Version == 0 {
+			return errors.New("options require version")
+		}
+		return h.Options.Valid()
+	}
 	return nil
 }
 
+// Is6 require ipv6 layout header
+func (h Fields) Is6() bool {
+	return is6(h.Client.Addr()) || is6(h.Forward.Addr()) || is6(h.Server)
+}
+
 func (h Fields) String() string {
 	return fmt.Sprintf(
 		"{Server:%s, Client:%s, Proto:%d,  Kind:%s}",
@@ -134,55 +289,66 @@ func (h *Fields) Encode(to *packet.Packet) error {
 		return err
 	}
 
-	if h.Server.IsValid() {
-		to.Attach(h.Server.AsSlice()...)
-	} else {
-		to.Attach(0, 0, 0, 0)
+	var e kindproto
+	e.SetKind(h.Kind)
+	e.SetProto(h.Proto)
+	size := Size
+	if h.Is6() {
+		e |= flag6
+		size = Size6
 	}
-
-	to.Attach(byte(h.Forward.Port()), byte(h.Forward.Port()>>8))
-	if h.Forward.IsValid() {
-		to.Attach(h.Forward.Addr().AsSlice()...)
-	} else {
-		to.Attach(0, 0, 0, 0)
+	fixed := size
+	if h.Version > 0 {
+		e |= flagExt
+		size += extSize + h.Options.size()
 	}
 
-	to.Attach(byte(h.Client.Port()), byte(h.Client.Port()>>8))
-	if h.Client.Addr().IsValid() {
-		to.Attach(h.Client.Addr().AsSlice()...)
-	} else {
-		to.Attach(0, 0, 0, 0)
+	hdr := Bvvd(to.AttachN(size).Bytes())
+	hdr[0] = byte(e)
+	hdr.SetDataID(h.DataID)
+	hdr.SetClient(netip.AddrPortFrom(zero(h.Client.Addr()), h.Client.Port()))
+	hdr.SetForward(netip.AddrPortFrom(zero(h.Forward.Addr()), h.Forward.Port()))
+	hdr.SetServer(zero(h.Server))
+	if h.Version > 0 {
+		hdr[fixed] = h.Version
+		hdr[fixed+1] = byte(h.Options.size())
+		h.Options.encode(hdr[fixed+extSize:])
 	}
-
-	to.Attach(h.DataID)
-
-	var e kindproto
-	e.SetKind(h.Kind)
-	e.SetProto(h.Proto)
-	to.Attach(byte(e))
 	return nil
 }
 
+// zero replace invalid address with ipv4 unspecified address
+func zero(addr netip.Addr) netip.Addr {
+	if addr.IsValid() {
+		return addr
+	}
+	return netip.IPv4Unspecified()
+}
+
 func (h *Fields) Decode(from *packet.Packet) error {
-	b := from.Bytes()
-	if len(b) < Size {
+	b := Bvvd(from.Bytes())
+	if len(b) < Size || len(b) < b.Len() {
 		return errors.Errorf("too short %d", len(b))
 	}
 
-	h.Kind = kindproto(b[0]).kind()
-	h.Proto = kindproto(b[0]).Proto()
-	h.DataID = b[1]
-	h.Client = netip.AddrPortFrom(
-		netip.AddrFrom4([4]byte(b[2:])),
-		uint16(b[6])+uint16(b[7])<<8,
-	)
-	h.Forward = netip.AddrPortFrom(
-		netip.AddrFrom4([4]byte(b[8:])),
-		uint16(b[12])+uint16(b[13])<<8,
-	)
-	h.Server = netip.AddrFrom4([4]byte(b[14:]))
+	h.Kind = b.Kind()
+	h.Proto = b.Proto()
+	h.DataID = b.DataID()
+	h.Client = b.Client()
+	h.Forward = b.Forward()
+	h.Server = b.Server()
+	h.Version = b.Version()
+	h.Options = nil
+	if kindproto(b[0]).ext() {
+		n := b.layout().size + extSize
+		opts, err := decodeOptions(b[n:b.Len()])
+		if err != nil {
+			return err
+		}
+		h.Options = opts
+	}
 
-	from.DetachN(Size)
+	from.DetachN(b.Len())
 	return h.Valid()
 }
 
@@ -219,11 +385,33 @@ const (
 	// pl  client  ---> gateway
 	PackLossClientUplink
 
+	// session handshake client <--> gateway
+	Session
+
+	// fec parity of Data, client <--> forward
+	Parity
+
+	// forward unreachable, gateway ---> client, reply Data to down forward
+	Unreachable
+
 	_kind_end
 )
 
 type kindproto byte
 
+const (
+	flag6   kindproto = 0b10000000
+	flagExt kindproto = 0b01000000
+)
+
+func (b kindproto) is6() bool {
+	return b&flag6 != 0
+}
+
+func (b kindproto) ext() bool {
+	return b&flagExt != 0
+}
+
 func (b kindproto) kind() Kind {
 	return Kind(b & 0b00001111)
 }
@@ -231,7 +419,7 @@ func (b *kindproto) SetKind(k Kind) {
 	(*b) = (*b)&0b11110000 + (kindproto(k) & 0b00001111)
 }
 func (b kindproto) Proto() tcpip.TransportProtocolNumber {
-	switch b >> 4 {
+	switch (b >> 4) & 0b0011 {
 	case 1:
 		return header.TCPProtocolNumber
 	case 2:
@@ -249,5 +437,5 @@ func (b *kindproto) SetProto(p tcpip.TransportProtocolNumber) {
 		e = 2 << 4
 	default:
 	}
-	(*b) = e + (*b)&0b00001111
+	(*b) = e + (*b)&0b11001111
 }
@@ -15,12 +15,15 @@ func _() {
 	_ = x[PackLossGatewayUplink-5]
 	_ = x[PackLossGatewayDownlink-6]
 	_ = x[PackLossClientUplink-7]
-	_ = x[_kind_end-8]
+	_ = x[Session-8]
+	_ = x[Parity-9]
+	_ = x[Unreachable-10]
+	_ = x[_kind_end-11]
 }
 
-const _Kind_name = "DataPingGatewayPingForwardPingServerPackLossGatewayUplinkPackLossGatewayDownlinkPackLossClientUplink_kind_end"
+const _Kind_name = "DataPingGatewayPingForwardPingServerPackLossGatewayUplinkPackLossGatewayDownlinkPackLossClientUplinkSessionParityUnreachable_kind_end"
 
-var _Kind_index = [...]uint8{0, 4, 15, 26, 36, 57, 80, 100, 109}
+var _Kind_index = [...]uint8{0, 4, 15, 26, 36, 57, 80, 100, 107, 113, 124, 133}
 
 func (i Kind) String() string {
 	i -= 1
@@ -31,6 +31,149 @@ func Test_Fields(t *testing.T) {
 	require.Equal(t, msg, string(pkt.Bytes()))
 }
 
+func Test_Fields6(t *testing.T) {
+	msg := "hello world"
+
+	var pkt = packet.Make().Append([]byte(msg)...)
+	var h1 = Fields{
+		Kind:    Data,
+		Proto:   header.UDPProtocolNumber,
+		DataID:  byte(rand.Uint32()),
+		Forward: netip.AddrPortFrom(test.RandIP(), test.RandPort()),
+		Client:  netip.AddrPortFrom(test.RandIP(), test.RandPort()),
+		Server:  randIP6(),
+	}
+	require.True(t, h1.Is6())
+	require.NoError(t, h1.Encode(pkt))
+	require.Equal(t, Size6+len(msg), pkt.Data())
+	require.True(t, Bvvd(pkt.Bytes()).Is6())
+
+	var h2 Fields
+	require.NoError(t, h2.Decode(pkt))
+	require.Equal(t, h1, h2)
+	require.Equal(t, msg, string(pkt.Bytes()))
+}
+
+func Test_Extension(t *testing.T) {
+	var newFields = func() Fields {
+		return Fields{
+			Kind:    Data,
+			Proto:   header.TCPProtocolNumber,
+			DataID:  byte(rand.Uint32()),
+			Forward: netip.AddrPortFrom(test.RandIP(), test.RandPort()),
+			Client:  netip.AddrPortFrom(test.RandIP(), test.RandPort()),
+			Server:  test.RandIP(),
+		}
+	}
+
+	t.Run("legacy", func(t *testing.T) {
+		var pkt = packet.Make()
+		var f = newFields()
+		require.NoError(t, f.Encode(pkt))
+		require.Equal(t, Size, pkt.Data())
+		require.Zero(t, Bvvd(pkt.Bytes()).Version())
+	})
+
+	t.Run("options", func(t *testing.T) {
+		msg := "hello world"
+		var pkt = packet.Make().Append([]byte(msg)...)
+		var f = newFields()
+		f.Version = Version
+		f.Options = Options{
+			{Type: OptionSessionID, Value: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+			{Type: OptionTimestamp, Value: []byte{9, 8, 7}},
+		}
+		require.NoError(t, f.Encode(pkt))
+
+		hdr := Bvvd(pkt.Bytes())
+		require.Equal(t, Size+extSize+10+5, hdr.Len())
+		require.Equal(t, uint8(Version), hdr.Version())
+		require.Equal(t, f.Client, hdr.Client())
+		v, has := hdr.Option(OptionTimestamp)
+		require.True(t, has)
+		require.Equal(t, []byte{9, 8, 7}, v)
+
+		var f2 Fields
+		require.NoError(t, f2.Decode(pkt))
+		require.Equal(t, f, f2)
+		require.Equal(t, msg, string(pkt.Bytes()))
+	})
+
+	t.Run("skip unknown", func(t *testing.T) {
+		var pkt = packet.Make()
+		var f = newFields()
+		f.Version = Version + 1
+		f.Options = Options{
+			{Type: 0xf0, Value: []byte{1, 2}},
+			{Type: OptionSessionID, Value: []byte{1, 2, 3, 4, 5, 6, 7, 8}},
+		}
+		require.NoError(t, f.Encode(pkt))
+
+		var f2 Fields
+		require.NoError(t, f2.Decode(pkt))
+		require.Zero(t, pkt.Data())
+		require.Equal(t, f.Options[1:], f2.Options)
+		require.Equal(t, f.Version, f2.Version)
+	})
+
+	t.Run("fit", func(t *testing.T) {
+		var pkt = packet.Make()
+		var f = newFields()
+		f.Version = Version
+		f.Options = Options{{Type: OptionTimestamp, Value: []byte{1}}}
+		require.NoError(t, f.Encode(pkt))
+
+		f.Server = randIP6()
+		Fit(pkt, f.Server).SetServer(f.Server)
+
+		var f2 Fields
+		require.NoError(t, f2.Decode(pkt))
+		require.Equal(t, f, f2)
+	})
+
+	t.Run("strip", func(t *testing.T) {
+		msg := "hello world"
+		var pkt = packet.Make().Append([]byte(msg)...)
+		var f = newFields()
+		f.Version = Version
+		f.Options = Options{{Type: OptionTimestamp, Value: []byte{1, 2, 3}}}
+		require.NoError(t, f.Encode(pkt))
+
+		hdr := Strip(pkt)
+		require.Equal(t, Size, hdr.Len())
+		f.Version, f.Options = 0, nil
+		var f2 Fields
+		require.NoError(t, f2.Decode(pkt))
+		require.Equal(t, f, f2)
+		require.Equal(t, msg, string(pkt.Bytes()))
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		var pkt = packet.Make()
+		var f = newFields()
+		f.Version = Version
+		f.Options = Options{{Type: OptionTimestamp, Value: []byte{1, 2, 3}}}
+		require.NoError(t, f.Encode(pkt))
+
+		pkt.SetData(pkt.Data() - 1)
+		require.Error(t, (&Fields{}).Decode(pkt))
+	})
+
+	t.Run("options without version", func(t *testing.T) {
+		var f = newFields()
+		f.Options = Options{{Type: OptionTimestamp, Value: []byte{1}}}
+		require.Error(t, f.Encode(packet.Make()))
+	})
+}
+
+func randIP6() netip.Addr {
+	var b = [16]byte{0x20, 0x01, 0x0d, 0xb8}
+	for i := 4; i < len(b); i++ {
+		b[i] = byte(rand.Uint32())
+	}
+	return netip.AddrFrom16(b)
+}
+
 func Test_Bvvd(t *testing.T) {
 	t.Run("get", func(t *testing.T) {
 		msg := "hello world"
@@ -80,4 +223,38 @@ func Test_Bvvd(t *testing.T) {
 		require.Equal(t, f, f2)
 	})
 
+	t.Run("fit", func(t *testing.T) {
+		msg := "hello world"
+		var pkt = packet.Make().Append([]byte(msg)...)
+		var f = Fields{
+			Kind:    Data,
+			Proto:   header.TCPProtocolNumber,
+			DataID:  byte(rand.Uint32()),
+			Forward: netip.AddrPortFrom(test.RandIP(), test.RandPort()),
+			Client:  netip.AddrPortFrom(test.RandIP(), test.RandPort()),
+			Server:  test.RandIP(),
+		}
+		require.NoError(t, f.Encode(pkt))
+
+		slave := Fit(pkt, test.RandIP())
+		require.False(t, slave.Is6())
+		require.Equal(t, Size+len(msg), pkt.Data())
+
+		f.Client = netip.AddrPortFrom(randIP6(), test.RandPort())
+		slave = Fit(pkt, f.Client.Addr())
+		require.True(t, slave.Is6())
+		slave.SetClient(f.Client)
+
+		var f2 Fields
+		require.NoError(t, f2.Decode(pkt))
+		require.Equal(t, f, f2)
+		require.Equal(t, msg, string(pkt.Bytes()))
+	})
+
+	t.Run("ipv4 header set ipv6", func(t *testing.T) {
+		var pkt = packet.Make(0, Size)
+		require.Panics(t, func() {
+			Bvvd(pkt.Bytes()).SetServer(randIP6())
+		})
+	})
 }
@@ -2,6 +2,7 @@ package bvvd
 
 import (
 	"slices"
+	"strings"
 
 	"github.com/jftuga/geodist"
 	"github.com/lysShub/netkit/packet"
@@ -23,6 +24,16 @@ func (l Location) valid() bool {
 	return 0 < l && l < _end
 }
 
+// ParseLocation parse location from name or hans, name is case insensitive
+func ParseLocation(s string) (Location, error) {
+	for _, l := range Locations {
+		if strings.EqualFold(l.String(), s) || l.Hans() == s {
+			return l, nil
+		}
+	}
+	return 0, errors.Errorf("unknown location %q", s)
+}
+
 func (l Location) Coord() geodist.Coord {
 	if l.valid() {
 		return infos[int(l)].coord
@@ -12,6 +12,27 @@ func Test_Valid(t *testing.T) {
 	require.Equal(t, int(_end)-1, len(Locations))
 }
 
+func Test_ParseLocation(t *testing.T) {
+	for _, loc := range Locations {
+		l, err := ParseLocation(loc.String())
+		require.NoError(t, err)
+		require.Equal(t, loc, l)
+
+		l, err = ParseLocation(loc.Hans())
+		require.NoError(t, err)
+		require.Equal(t, loc, l)
+	}
+
+	l, err := ParseLocation("losangeles")
+	require.NoError(t, err)
+	require.Equal(t, LosAngeles, l)
+
+	_, err = ParseLocation("")
+	require.Error(t, err)
+	_, err = ParseLocation("Beijing")
+	require.Error(t, err)
+}
+
 func Test_Location(t *testing.T) {
 
 	for _, loc := range Locations {
@@ -0,0 +1,106 @@
+package bvvd
+
+import (
+	"slices"
+
+	"github.com/pkg/errors"
+)
+
+//go:generate stringer -output option_gen.go -type=OptionType
+
+// OptionType tlv option type in header extension area, option with unknown
+// type will be skipped by decoder, so new option can roll out incrementally.
+type OptionType uint8
+
+func (t OptionType) Known() bool {
+	return 0 < t && t < _option_end
+}
+
+const (
+	_ OptionType = iota
+
+	// session id, identify the client session
+	OptionSessionID
+
+	// sender timestamp, unix nano
+	OptionTimestamp
+
+	// fec group info of Data and Parity: group(4) index(1) size(1)
+	OptionFEC
+
+	// client assigned sequence of redundant Data, used to deduplicate: seq(4)
+	OptionSeq
+
+	_option_end
+)
+
+type Option struct {
+	Type  OptionType
+	Value []byte
+}
+
+type Options []Option
+
+// Get get option value by type
+func (os Options) Get(typ OptionType) ([]byte, bool) {
+	for _, e := range os {
+		if e.Type == typ {
+			return e.Value, true
+		}
+	}
+	return nil, false
+}
+
+func (os Options) Valid() error {
+	for _, e := range os {
+		if e.Type == 0 {
+			return errors.New("invalid option type 0")
+		} else if len(e.Value) > 0xff {
+			return errors.Errorf("option %s value too long %d", e.Type, len(e.Value))
+		}
+	}
+	if n := os.size(); n > 0xff {
+		return errors.Errorf("options too long %d", n)
+	}
+	return nil
+}
+
+// size tlv encoded size
+func (os Options) size() (n int) {
+	for _, e := range os {
+		n += 2 + len(e.Value)
+	}
+	return n
+}
+
+func (os Options) encode(to []byte) {
+	for _, e := range os {
+		to[0] = byte(e.Type)
+		to[1] = byte(len(e.Value))
+		to = to[2+copy(to[2:], e.Value):]
+	}
+}
+
+// decodeOptions decode tlv options, skip unknown option
+func decodeOptions(b []byte) (os Options, err error) {
+	err = rangeOptions(b, func(typ OptionType, value []byte) bool {
+		if typ.Known() {
+			os = append(os, Option{Type: typ, Value: slices.Clone(value)})
+		}
+		return true
+	})
+	return os, err
+}
+
+func rangeOptions(b []byte, fn func(typ OptionType, value []byte) bool) error {
+	for len(b) > 0 {
+		if len(b) < 2 || len(b) < 2+int(b[1]) {
+			return errors.Errorf("invalid option %#v", b)
+		}
+		if !fn(OptionType(b[0]), b[2:2+int(b[1])]) {
+			return nil
+		}
+		b = b[2+int(b[1]):]
+	}
+	return nil
+}
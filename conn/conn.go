@@ -5,6 +5,7 @@ import (
 	"net/netip"
 	"reflect"
 
+	"github.com/lysShub/anton-planet-accelerator/conn/internal/batch"
 	"github.com/lysShub/anton-planet-accelerator/conn/tcp"
 	"github.com/lysShub/anton-planet-accelerator/conn/udp"
 	"github.com/lysShub/netkit/packet"
@@ -20,17 +21,79 @@ type Conn interface {
 	// write transport layer payload
 	WriteToAddrPort(*packet.Packet, netip.AddrPort) error
 
+	// read at least one message, return count of read messages, Packet of
+	// msgs should be prepared as ReadFromAddrPort
+	ReadBatch(msgs []Message) (int, error)
+
+	// write messages, return count of written messages
+	WriteBatch(msgs []Message) (int, error)
+
 	LocalAddr() netip.AddrPort
 
 	Close() error
 }
 
+// Message message of batch read/write
+type Message = batch.Message
+
+// ReadBatchFallback read one message, used by Conn not support batch read
+func ReadBatchFallback(c Conn, msgs []Message) (int, error) { return batch.Read(c, msgs) }
+
+// WriteBatchFallback write messages one by one, used by Conn not support
+// batch write
+func WriteBatchFallback(c Conn, msgs []Message) (int, error) { return batch.Write(c, msgs) }
+
+// EnableOffload enable udp segmentation offload of conn, refer udp conn
+// EnableOffload, return error if not support
+func EnableOffload(c Conn) error {
+	if o, ok := c.(interface{ EnableOffload() error }); ok {
+		return o.EnableOffload()
+	}
+	return errors.Errorf("%T not support offload", c)
+}
+
+// Batch collect messages and write them by WriteBatch
+type Batch struct {
+	conn Conn
+	msgs []Message
+}
+
+func NewBatch(conn Conn, size int) *Batch {
+	return &Batch{conn: conn, msgs: make([]Message, 0, size)}
+}
+
+// Add add message, flush if full. the pkt should not be modified before flush
+func (b *Batch) Add(pkt *packet.Packet, to netip.AddrPort) error {
+	b.msgs = append(b.msgs, Message{Packet: pkt, Addr: to})
+	if len(b.msgs) < cap(b.msgs) {
+		return nil
+	}
+	return b.Flush()
+}
+
+// Flush write collected messages
+func (b *Batch) Flush() error {
+	if len(b.msgs) == 0 {
+		return nil
+	}
+	_, err := b.conn.WriteBatch(b.msgs)
+	clear(b.msgs)
+	b.msgs = b.msgs[:0]
+	return err
+}
+
+// Bind bind a datagram connect, support network:
+//
+//	udp4, tcp(tcp4): ipv4, unspecified laddr will bind default route address
+//	rtcp: reliable mode tcp, refer tcp.Config
+//	udp6: ipv6
+//	udp: dual-stack, unspecified laddr will bind wildcard address
 func Bind(network string, laddr string) (conn Conn, err error) {
-	addr, err := resolveAddr(laddr)
+	addr, err := resolveAddr(network, laddr)
 	if err != nil {
 		return nil, err
 	}
-	if addr.Addr().IsUnspecified() {
+	if addr.Addr().IsUnspecified() && addr.Addr().Is4() {
 		table, err := route.GetTable()
 		if err != nil {
 			return nil, errors.WithStack(err)
@@ -48,29 +111,50 @@ func Bind(network string, laddr string) (conn Conn, err error) {
 		}
 	}()
 	switch network {
-	case "udp", "udp4":
+	case "udp", "udp4", "udp6":
 		return udp.Bind(addr)
 	case "tcp", "tcp4":
 		return tcp.Bind(addr)
+	case "rtcp":
+		return tcp.BindConfig(addr, &tcp.Config{Reliable: true})
 	default:
 		return nil, errors.Errorf("not support network %s", network)
 	}
 }
 
-func resolveAddr(addr string) (netip.AddrPort, error) {
-	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+func resolveAddr(network, addr string) (netip.AddrPort, error) {
+	var resolve = "udp4"
+	switch network {
+	case "udp6":
+		resolve = "udp6"
+	case "udp":
+		resolve = "udp"
+	}
+
+	udpAddr, err := net.ResolveUDPAddr(resolve, addr)
 	if err != nil {
 		return netip.AddrPort{}, errors.WithStack(err)
 	}
 	if len(udpAddr.IP) == 0 {
-		udpAddr.IP = netip.IPv4Unspecified().AsSlice()
+		if resolve == "udp4" {
+			udpAddr.IP = netip.IPv4Unspecified().AsSlice()
+		} else {
+			udpAddr.IP = netip.IPv6Unspecified().AsSlice()
+		}
 	} else if udpAddr.IP.To4() != nil {
 		udpAddr.IP = udpAddr.IP.To4()
 	}
 
 	a := udpAddr.AddrPort()
-	if !a.Addr().Is4() {
-		return netip.AddrPort{}, errors.Errorf("only support ipv4 %s", udpAddr.String())
+	switch resolve {
+	case "udp4":
+		if !a.Addr().Is4() {
+			return netip.AddrPort{}, errors.Errorf("only support ipv4 %s", udpAddr.String())
+		}
+	case "udp6":
+		if !a.Addr().Is6() {
+			return netip.AddrPort{}, errors.Errorf("only support ipv6 %s", udpAddr.String())
+		}
 	}
 	return a, nil
 }
@@ -9,38 +9,61 @@ import (
 
 func Test_resolveAddr(t *testing.T) {
 	{
-		addr, err := resolveAddr("")
+		addr, err := resolveAddr("udp4", "")
 		require.NoError(t, err)
 		require.Equal(t, netip.AddrPortFrom(netip.IPv4Unspecified(), 0), addr)
 	}
 
 	{
-		addr, err := resolveAddr(":")
+		addr, err := resolveAddr("udp4", ":")
 		require.NoError(t, err)
 		require.Equal(t, netip.AddrPortFrom(netip.IPv4Unspecified(), 0), addr)
 	}
 
 	{
-		addr, err := resolveAddr(":1234")
+		addr, err := resolveAddr("udp4", ":1234")
 		require.NoError(t, err)
 		require.Equal(t, netip.AddrPortFrom(netip.IPv4Unspecified(), 1234), addr)
 	}
 
 	{
-		addr, err := resolveAddr("1.1.1.1:")
+		addr, err := resolveAddr("udp4", "1.1.1.1:")
 		require.NoError(t, err)
 		require.Equal(t, netip.AddrPortFrom(netip.AddrFrom4([4]byte{1, 1, 1, 1}), 0), addr)
 	}
 
 	{
-		addr, err := resolveAddr("1.1.1.1:1234")
+		addr, err := resolveAddr("udp4", "1.1.1.1:1234")
 		require.NoError(t, err)
 		require.Equal(t, netip.AddrPortFrom(netip.AddrFrom4([4]byte{1, 1, 1, 1}), 1234), addr)
 	}
 
 	{
-		addr, err := resolveAddr("baidu.com:1234")
+		addr, err := resolveAddr("udp4", "baidu.com:1234")
 		require.NoError(t, err)
 		require.Equal(t, uint16(1234), addr.Port())
 	}
+
+	{
+		addr, err := resolveAddr("udp", ":1234")
+		require.NoError(t, err)
+		require.Equal(t, netip.AddrPortFrom(netip.IPv6Unspecified(), 1234), addr)
+	}
+
+	{
+		addr, err := resolveAddr("udp", "1.1.1.1:1234")
+		require.NoError(t, err)
+		require.Equal(t, netip.AddrPortFrom(netip.AddrFrom4([4]byte{1, 1, 1, 1}), 1234), addr)
+	}
+
+	{
+		addr, err := resolveAddr("udp6", "[2001:db8::1]:1234")
+		require.NoError(t, err)
+		require.Equal(t, netip.MustParseAddrPort("[2001:db8::1]:1234"), addr)
+	}
+
+	{
+		_, err := resolveAddr("udp4", "[2001:db8::1]:1234")
+		require.Error(t, err)
+	}
 }
@@ -0,0 +1,42 @@
+package batch
+
+import (
+	"net/netip"
+
+	"github.com/lysShub/netkit/packet"
+)
+
+// Message message of batch read/write
+type Message struct {
+	Packet *packet.Packet
+	Addr   netip.AddrPort
+}
+
+// Read read one message, fallback of ReadBatch for transport not support batch
+func Read(c interface {
+	ReadFromAddrPort(*packet.Packet) (netip.AddrPort, error)
+}, msgs []Message) (int, error) {
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+
+	addr, err := c.ReadFromAddrPort(msgs[0].Packet)
+	if err != nil {
+		return 0, err
+	}
+	msgs[0].Addr = addr
+	return 1, nil
+}
+
+// Write write messages one by one, fallback of WriteBatch for transport not
+// support batch
+func Write(c interface {
+	WriteToAddrPort(*packet.Packet, netip.AddrPort) error
+}, msgs []Message) (int, error) {
+	for i, e := range msgs {
+		if err := c.WriteToAddrPort(e.Packet, e.Addr); err != nil {
+			return i, err
+		}
+	}
+	return len(msgs), nil
+}
@@ -0,0 +1,250 @@
+package conn
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+
+	"github.com/lysShub/netkit/errorx"
+	"github.com/lysShub/netkit/packet"
+	"github.com/pkg/errors"
+)
+
+// Mux multiplex conns as one Conn, such as listen udp and tcp at the same
+// time. read from any conn, write to the conn that latest read from the peer,
+// or the default conn if never read from it. routes are keyed by conn and
+// peer address, but Conn only report peer address, so peers with the same
+// address on different conns can't be distinguished, write to the latest.
+type Mux struct {
+	conns []Conn
+	def   atomic.Int32 // index of default conn
+
+	mu     sync.RWMutex
+	routes map[muxKey]uint64 // order of switched to the conn
+	order  uint64
+
+	recv     chan *muxRecv
+	rmu      sync.Mutex
+	cur      *muxRecv // partially consumed batch, protected by rmu
+	done     chan struct{}
+	closeErr errorx.CloseErr
+}
+
+var _ Conn = (*Mux)(nil)
+
+type muxKey struct {
+	idx  int
+	addr netip.AddrPort
+}
+
+// muxRecv batch read from a conn, the conn wait it consumed before next read
+type muxRecv struct {
+	idx  int
+	msgs []Message
+	n    int // read messages
+	off  int // consumed messages
+	err  error
+	read chan struct{} // msgs is consumed
+}
+
+// muxBatch messages of batch read from each conn
+const muxBatch = 16
+
+// NewMux multiplex conns, the first conn is default
+func NewMux(conns ...Conn) *Mux {
+	if len(conns) == 0 {
+		panic("require conn")
+	}
+	var m = &Mux{
+		conns:  conns,
+		routes: map[muxKey]uint64{},
+		recv:   make(chan *muxRecv),
+		done:   make(chan struct{}),
+	}
+	for i := range conns {
+		go m.readService(i)
+	}
+	return m
+}
+
+func (m *Mux) Close() error {
+	return m.closeErr.Close(func() (errs []error) {
+		close(m.done)
+		for _, e := range m.conns {
+			errs = append(errs, e.Close())
+		}
+		return errs
+	})
+}
+
+// readService batch read from conn, forward the whole batch to reader.
+// temporary error is ignored, other error is forwarded and stop reading
+func (m *Mux) readService(idx int) {
+	var r = &muxRecv{idx: idx, msgs: make([]Message, muxBatch), read: make(chan struct{})}
+	for i := range r.msgs {
+		r.msgs[i].Packet = packet.Make(64, 0xffff)
+	}
+	for {
+		for _, e := range r.msgs {
+			e.Packet.Sets(64, 0xffff)
+		}
+		r.n, r.err = m.conns[idx].ReadBatch(r.msgs)
+		r.off = 0
+		if r.err != nil {
+			if errorx.Temporary(r.err) {
+				continue
+			}
+			r.n = 0
+		} else if r.n == 0 {
+			continue
+		}
+
+		select {
+		case m.recv <- r:
+		case <-m.done:
+			return
+		}
+		if r.err != nil {
+			return
+		}
+		select {
+		case <-r.read:
+		case <-m.done:
+			return
+		}
+	}
+}
+
+func (m *Mux) ReadFromAddrPort(b *packet.Packet) (netip.AddrPort, error) {
+	var msgs = [1]Message{{Packet: b}}
+	if _, err := m.ReadBatch(msgs[:]); err != nil {
+		return netip.AddrPort{}, err
+	}
+	return msgs[0].Addr, nil
+}
+
+// ReadBatch read messages of batches from conns, block until at least one
+// message read, then take the ready batches without blocking
+func (m *Mux) ReadBatch(msgs []Message) (int, error) {
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+	m.rmu.Lock()
+	defer m.rmu.Unlock()
+
+	var n int
+	for n < len(msgs) {
+		if m.cur == nil {
+			if n == 0 {
+				select {
+				case m.cur = <-m.recv:
+				case <-m.done:
+					return 0, errors.WithStack(net.ErrClosed)
+				}
+			} else {
+				select {
+				case m.cur = <-m.recv:
+				default:
+					return n, nil
+				}
+			}
+		}
+		if err := m.cur.err; err != nil {
+			if n > 0 {
+				return n, nil // next read return the error
+			}
+			m.cur = nil
+			return 0, err
+		}
+
+		r := m.cur
+		for ; n < len(msgs) && r.off < r.n; r.off++ {
+			src := r.msgs[r.off]
+			c := copy(msgs[n].Packet.Bytes(), src.Packet.Bytes())
+			if c < src.Packet.Data() {
+				if n > 0 {
+					return n, nil // next read return the error
+				}
+				r.off++
+				m.release()
+				return 0, errorx.WrapTemp(errorx.ShortBuff(src.Packet.Data(), c))
+			}
+			msgs[n].Packet.SetData(c)
+			msgs[n].Addr = src.Addr
+			m.route(r.idx, src.Addr)
+			n++
+		}
+		m.release()
+	}
+	return n, nil
+}
+
+// release notify conn read next batch if current batch consumed, require
+// hold rmu
+func (m *Mux) release() {
+	if r := m.cur; r != nil && r.off >= r.n {
+		m.cur = nil
+		select {
+		case r.read <- struct{}{}:
+		case <-m.done:
+		}
+	}
+}
+
+// route record peer read from conn
+func (m *Mux) route(idx int, addr netip.AddrPort) {
+	m.mu.RLock()
+	latest := m.latest(addr)
+	m.mu.RUnlock()
+	if latest != idx {
+		m.mu.Lock()
+		m.order++
+		m.routes[muxKey{idx: idx, addr: addr}] = m.order
+		m.mu.Unlock()
+	}
+}
+
+// latest index of conn that latest read from peer, -1 if never read,
+// require hold mu
+func (m *Mux) latest(addr netip.AddrPort) int {
+	var idx, last = -1, uint64(0)
+	for i := range m.conns {
+		if o, has := m.routes[muxKey{idx: i, addr: addr}]; has && o > last {
+			idx, last = i, o
+		}
+	}
+	return idx
+}
+
+func (m *Mux) WriteToAddrPort(b *packet.Packet, to netip.AddrPort) error {
+	m.mu.RLock()
+	idx := m.latest(to)
+	m.mu.RUnlock()
+	if idx < 0 {
+		idx = int(m.def.Load())
+	}
+	return m.conns[idx].WriteToAddrPort(b, to)
+}
+
+func (m *Mux) WriteBatch(msgs []Message) (int, error) { return WriteBatchFallback(m, msgs) }
+
+// SetDefault set default conn by index, that write to unknown peer
+func (m *Mux) SetDefault(idx int) {
+	if idx < 0 || idx >= len(m.conns) {
+		panic("invalid index")
+	}
+	m.def.Store(int32(idx))
+}
+
+// Del delete routes of peer, next write will use default conn
+func (m *Mux) Del(addr netip.AddrPort) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i := range m.conns {
+		delete(m.routes, muxKey{idx: i, addr: addr})
+	}
+}
+
+// LocalAddr local address of the first conn
+func (m *Mux) LocalAddr() netip.AddrPort { return m.conns[0].LocalAddr() }
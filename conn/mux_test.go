@@ -0,0 +1,140 @@
+package conn
+
+import (
+	"net/netip"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lysShub/anton-planet-accelerator/conn/udp"
+	"github.com/lysShub/netkit/errorx"
+	"github.com/lysShub/netkit/packet"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Mux(t *testing.T) {
+	var laddr = netip.MustParseAddrPort("127.0.0.1:0")
+	bind := func() Conn {
+		c, err := udp.Bind(laddr)
+		require.NoError(t, err)
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+	var (
+		a, b   = bind(), bind()
+		p1, p2 = bind(), bind()
+		p3     = bind()
+		m      = NewMux(a, b)
+	)
+	defer m.Close()
+	require.Equal(t, a.LocalAddr(), m.LocalAddr())
+
+	// reply by the conn that read from peer
+	for _, e := range []struct{ peer, to Conn }{{p1, a}, {p2, b}} {
+		err := e.peer.WriteToAddrPort(packet.Make(0).Append([]byte("hello")...), e.to.LocalAddr())
+		require.NoError(t, err)
+
+		pkt := packet.Make(64, 1500)
+		raddr, err := m.ReadFromAddrPort(pkt)
+		require.NoError(t, err)
+		require.Equal(t, e.peer.LocalAddr(), raddr)
+		require.Equal(t, "hello", string(pkt.Bytes()))
+
+		err = m.WriteToAddrPort(packet.Make(0).Append([]byte("world")...), raddr)
+		require.NoError(t, err)
+		src, err := e.peer.ReadFromAddrPort(pkt.Sets(0, 1500))
+		require.NoError(t, err)
+		require.Equal(t, e.to.LocalAddr(), src)
+		require.Equal(t, "world", string(pkt.Bytes()))
+	}
+
+	// unknown peer use default conn
+	for _, def := range []int{0, 1} {
+		m.SetDefault(def)
+		err := m.WriteToAddrPort(packet.Make(0).Append([]byte("hi")...), p3.LocalAddr())
+		require.NoError(t, err)
+
+		pkt := packet.Make(0, 1500)
+		src, err := p3.ReadFromAddrPort(pkt)
+		require.NoError(t, err)
+		require.Equal(t, []Conn{a, b}[def].LocalAddr(), src)
+	}
+
+	// deleted route fall back to default
+	m.SetDefault(0)
+	m.Del(p2.LocalAddr())
+	err := m.WriteToAddrPort(packet.Make(0).Append([]byte("hi")...), p2.LocalAddr())
+	require.NoError(t, err)
+	pkt := packet.Make(0, 1500)
+	src, err := p2.ReadFromAddrPort(pkt)
+	require.NoError(t, err)
+	require.Equal(t, a.LocalAddr(), src)
+
+	require.NoError(t, m.Close())
+	_, err = m.ReadFromAddrPort(pkt)
+	require.Error(t, err)
+}
+
+func Test_Mux_Batch(t *testing.T) {
+	var laddr = netip.MustParseAddrPort("127.0.0.1:0")
+	bind := func() Conn {
+		c, err := udp.Bind(laddr)
+		require.NoError(t, err)
+		t.Cleanup(func() { c.Close() })
+		return c
+	}
+	var (
+		a, b = bind(), bind()
+		peer = bind()
+		m    = NewMux(&tempConn{Conn: a}, b)
+	)
+	defer m.Close()
+
+	const count = 8
+	for i := range count {
+		err := peer.WriteToAddrPort(packet.Make(0).Append(byte(i)), a.LocalAddr())
+		require.NoError(t, err)
+	}
+	time.Sleep(time.Millisecond * 100)
+
+	// temporary error not stop reading
+	var msgs = make([]Message, count)
+	for i := range msgs {
+		msgs[i].Packet = packet.Make(0, 1500)
+	}
+	var recved []byte
+	for len(recved) < count {
+		n, err := m.ReadBatch(msgs)
+		require.NoError(t, err)
+		for _, e := range msgs[:n] {
+			require.Equal(t, peer.LocalAddr(), e.Addr)
+			recved = append(recved, e.Packet.Bytes()...)
+		}
+	}
+	require.Equal(t, []byte{0, 1, 2, 3, 4, 5, 6, 7}, recved)
+
+	// route switch to the conn latest read from peer
+	err := peer.WriteToAddrPort(packet.Make(0).Append([]byte("hi")...), b.LocalAddr())
+	require.NoError(t, err)
+	_, err = m.ReadFromAddrPort(msgs[0].Packet.Sets(0, 1500))
+	require.NoError(t, err)
+	require.NoError(t, m.WriteToAddrPort(packet.Make(0).Append([]byte("hi")...), peer.LocalAddr()))
+	src, err := peer.ReadFromAddrPort(msgs[0].Packet.Sets(0, 1500))
+	require.NoError(t, err)
+	require.Equal(t, b.LocalAddr(), src)
+}
+
+// tempConn return a temporary error before the first read
+type tempConn struct {
+	Conn
+	once sync.Once
+}
+
+func (c *tempConn) ReadBatch(msgs []Message) (n int, err error) {
+	c.once.Do(func() { err = errorx.WrapTemp(errors.New("temporary")) })
+	if err != nil {
+		return 0, err
+	}
+	return c.Conn.ReadBatch(msgs)
+}
@@ -18,30 +18,46 @@ import (
 // 1. 握手不用等待，大概发就行了
 // 2. 没有重传、流控
 // 3. 忽略 Close
+//
+// reliable mode: retransmit handshake with backoff, negotiate window scale,
+// validate sequence/ack and reply RST when keepalive expiry, make stateful
+// middlebox treat it as normal tcp stream. only handshake is retransmitted,
+// lost data segment is not retransmitted, upper layer should tolerate loss
+// as udp. SYN of established endpoint is replied challenge ACK (RFC 5961),
+// restarted peer reset the stale endpoint by RST.
 type PseudoTCP struct {
 	conn         *TCPConn
 	raddr        netip.Addr
 	dial         bool
+	reliable     bool
 	lport, rport uint16
 	pseudo1      uint16
 
 	mu          sync.RWMutex
 	cached      *packet.Packet
 	established bool
+	closed      bool
 	isn, ian    uint32
+	wscale      bool // both side support window scale
+	retries     int
+	rtx         *time.Timer // handshake retransmit
+	alive       *time.Timer
+	challenged  time.Time // last challenge ack sent
 
 	magic  uint32 // use for keepalive
 	sndNxt atomic.Uint32
 	rcvNxt atomic.Uint32
+	rcvAck atomic.Uint32 // latest sent ack number
 }
 
 func NewPseudoTCP(remote netip.AddrPort, conn *TCPConn, dial bool) *PseudoTCP {
 	var p = &PseudoTCP{
-		conn:  conn,
-		raddr: remote.Addr(),
-		dial:  dial,
-		lport: conn.LocalAddr().Port(),
-		rport: remote.Port(),
+		conn:     conn,
+		raddr:    remote.Addr(),
+		dial:     dial,
+		reliable: conn.reliable,
+		lport:    conn.LocalAddr().Port(),
+		rport:    remote.Port(),
 		pseudo1: header.PseudoHeaderChecksum(
 			header.TCPProtocolNumber,
 			tcpip.AddrFrom4(conn.LocalAddr().Addr().As4()),
@@ -52,9 +68,9 @@ func NewPseudoTCP(remote netip.AddrPort, conn *TCPConn, dial bool) *PseudoTCP {
 	for p.isn == 0 {
 		p.isn = rand.Uint32()
 	}
-	p.sndNxt.Store(p.isn)
+	p.sndNxt.Store(p.isn + 1) // syn consume one sequence
 
-	time.AfterFunc(keepalive, p.keepalive)
+	p.alive = time.AfterFunc(keepalive, p.keepalive)
 	return p
 }
 
@@ -64,16 +80,25 @@ func (p *PseudoTCP) Send(pkt *packet.Packet) error {
 	}
 
 	if p.handshaked() {
-		return p.send(pkt, header.TCPFlagPsh|header.TCPFlagAck, 0, 0)
+		return p.sendData(pkt)
 	} else {
 		p.mu.Lock()
 		defer p.mu.Unlock()
+		if p.closed {
+			return nil
+		}
 		if p.cached == nil {
 			p.cached = pkt.Clone()
 		}
 
 		if p.dial {
 			if p.ian == 0 {
+				if p.reliable {
+					if p.rtx != nil {
+						return nil // retransmit by timer
+					}
+					p.rtx = time.AfterFunc(handshakeRTO, p.retransmit)
+				}
 				return p.send(packet.Make(64), header.TCPFlagSyn, p.isn, 0)
 			} else {
 				return p.send(packet.Make(64), header.TCPFlagAck, p.isn+1, p.ian+1)
@@ -87,30 +112,35 @@ func (p *PseudoTCP) Send(pkt *packet.Packet) error {
 	return nil
 }
 
+// sendData send payload, reserve sequence space before write
+func (p *PseudoTCP) sendData(pkt *packet.Packet) error {
+	n := uint32(pkt.Data())
+	seq := p.sndNxt.Add(n) - n
+	return p.send(pkt, header.TCPFlagPsh|header.TCPFlagAck, seq, p.rcvNxt.Load())
+}
+
 func (p *PseudoTCP) send(pkt *packet.Packet, flags header.TCPFlags, seq, ack uint32) error {
-	if seq == 0 {
-		seq = p.sndNxt.Load()
-	}
-	if ack == 0 {
-		ack = p.rcvNxt.Load()
-	}
 	if !flags.Contains(header.TCPFlagAck) {
 		ack = 0
 	}
+	var opts []byte
+	if p.reliable && flags.Contains(header.TCPFlagSyn) && (p.dial || p.wscale) {
+		opts = wsOption[:]
+	}
 
-	payload := uint32(pkt.Data())
-	hdr := header.TCP(pkt.AttachN(header.TCPMinimumSize).Bytes())
+	hdr := header.TCP(pkt.AttachN(header.TCPMinimumSize + len(opts)).Bytes())
 	hdr.Encode(&header.TCPFields{
 		SrcPort:       p.lport,
 		DstPort:       p.rport,
 		SeqNum:        seq,
 		AckNum:        ack,
-		DataOffset:    header.TCPMinimumSize,
+		DataOffset:    uint8(header.TCPMinimumSize + len(opts)),
 		Flags:         flags,
-		WindowSize:    0xffff, // todo: calc it
+		WindowSize:    0xffff, // max window, scaled by wsOption in reliable mode
 		Checksum:      0,
 		UrgentPointer: 0,
 	})
+	copy(hdr[header.TCPMinimumSize:], opts)
 	sum := checksum.Combine(p.pseudo1, uint16(len(hdr)))
 	sum = checksum.Checksum(hdr, sum)
 	hdr.SetChecksum(^sum)
@@ -119,11 +149,8 @@ func (p *PseudoTCP) send(pkt *packet.Packet, flags header.TCPFlags, seq, ack uin
 		return err
 	}
 
-	if payload+seq > p.sndNxt.Load() {
-		p.sndNxt.Store(seq + payload)
-	}
-	if ack > p.rcvNxt.Load() {
-		p.rcvNxt.Store(ack)
+	if flags.Contains(header.TCPFlagAck) {
+		p.rcvAck.Store(ack)
 	}
 	return nil
 }
@@ -136,72 +163,248 @@ func (p *PseudoTCP) Recv(tcp *packet.Packet) error {
 	if p.handshaked() {
 		return p.recv(tcp)
 	} else {
-		hdr := header.TCP(tcp.Bytes())
-		defer tcp.SetData(0)
-		p.mu.Lock()
-		defer p.mu.Unlock()
+		data, err := p.handshake(header.TCP(tcp.Bytes()))
+		if err != nil || !data {
+			tcp.SetData(0)
+			return err
+		}
+		return p.recv(tcp)
+	}
+}
 
-		if p.dial {
-			if hdr.Flags() == header.TCPFlagSyn|header.TCPFlagAck {
-				if p.ian == 0 {
-					p.ian = hdr.SequenceNumber()
-				}
-				if err := p.send(packet.Make(64), header.TCPFlagAck, p.isn+1, p.ian+1); err != nil {
-					return err
-				}
+// handshake process segment before established, return true if the segment
+// carry data should be delivered
+func (p *PseudoTCP) handshake(hdr header.TCP) (data bool, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return false, nil
+	}
 
-				p.established = true
-				defer func() { p.cached = nil }()
-				return p.send(p.cached, header.TCPFlagPsh|header.TCPFlagAck, 0, 0)
+	if p.reliable && hdr.Flags().Contains(header.TCPFlagRst) {
+		if hdr.Flags().Contains(header.TCPFlagAck) && hdr.AckNumber() == p.isn+1 {
+			p.close()
+		}
+		return false, nil
+	}
+
+	if p.dial {
+		if p.reliable && hdr.Flags().Contains(header.TCPFlagAck) && hdr.AckNumber() != p.isn+1 {
+			// refer RFC 793 SYN-SENT, segment of stale peer endpoint, such as
+			// challenge ack, reset it so peer accept the new handshake
+			return false, p.send(packet.Make(64), header.TCPFlagRst, hdr.AckNumber(), 0)
+		}
+		if hdr.Flags() == header.TCPFlagSyn|header.TCPFlagAck {
+			if p.ian == 0 {
+				p.ian = hdr.SequenceNumber()
+				p.rcvNxt.Store(p.ian + 1)
+				p.wscale = header.ParseSynOptions(hdr.Options(), true).WS >= 0
 			}
-		} else {
-			switch hdr.Flags() {
-			case header.TCPFlagSyn:
-				if p.ian == 0 {
-					p.ian = hdr.SequenceNumber()
-				}
-				return p.send(packet.Make(64), header.TCPFlagSyn|header.TCPFlagAck, p.isn, p.ian+1)
-			case header.TCPFlagAck:
-				if hdr.AckNumber() == p.isn+1 {
-					p.established = true
-				} else {
-					if debug.Debug() {
-						println("server recv invalid ack", p.isn, hdr.AckNumber())
-					}
+			if err := p.send(packet.Make(64), header.TCPFlagAck, p.isn+1, p.ian+1); err != nil {
+				return false, err
+			}
+
+			p.establish()
+			defer func() { p.cached = nil }()
+			if p.cached == nil {
+				return false, nil
+			}
+			return false, p.sendData(p.cached)
+		}
+	} else {
+		switch hdr.Flags() {
+		case header.TCPFlagSyn:
+			if p.ian == 0 || p.reliable {
+				p.ian = hdr.SequenceNumber()
+				p.rcvNxt.Store(p.ian + 1)
+				p.wscale = header.ParseSynOptions(hdr.Options(), false).WS >= 0
+			}
+			if p.reliable && p.rtx == nil {
+				p.rtx = time.AfterFunc(handshakeRTO, p.retransmit)
+			}
+			return false, p.send(packet.Make(64), header.TCPFlagSyn|header.TCPFlagAck, p.isn, p.ian+1)
+		case header.TCPFlagAck:
+			if hdr.AckNumber() == p.isn+1 {
+				p.establish()
+			} else {
+				if debug.Debug() {
+					println("server recv invalid ack", p.isn, hdr.AckNumber())
 				}
-			default:
+			}
+		default:
+			// the final ack of handshake maybe lost, data segment also complete it
+			if p.reliable && p.ian != 0 &&
+				hdr.Flags() == header.TCPFlagPsh|header.TCPFlagAck &&
+				hdr.AckNumber() == p.isn+1 {
+				p.establish()
+				return true, nil
 			}
 		}
 	}
-	return nil
+	return false, nil
 }
 
 func (p *PseudoTCP) recv(tcp *packet.Packet) error {
 	hdr := header.TCP(tcp.Bytes())
+	if p.reliable && !p.validate(hdr) {
+		tcp.SetData(0)
+		return nil
+	}
 	tcp.DetachN(int(hdr.DataOffset()))
 
 	nxt := hdr.SequenceNumber() + uint32(len(hdr.Payload()))
-	if nxt > p.rcvNxt.Load() {
-		p.rcvNxt.Store(nxt)
+	for {
+		old := p.rcvNxt.Load()
+		if !after(nxt, old) || p.rcvNxt.CompareAndSwap(old, nxt) {
+			break
+		}
+	}
+
+	// unidirectional stream require ack, otherwise middlebox will consider
+	// peer's sequence exceed the window
+	if p.reliable && p.rcvNxt.Load()-p.rcvAck.Load() >= ackThreshold {
+		return p.send(packet.Make(64), header.TCPFlagAck, p.sndNxt.Load(), p.rcvNxt.Load())
 	}
 	return nil
 }
 
+// validate established segment, return false if it should be dropped
+func (p *PseudoTCP) validate(hdr header.TCP) bool {
+	p.mu.RLock()
+	ian, wscale := p.ian, p.wscale
+	p.mu.RUnlock()
+
+	flags := hdr.Flags()
+	switch {
+	case flags.Contains(header.TCPFlagRst):
+		// refer RFC 5961, only accept exactly matched rst
+		if hdr.SequenceNumber() == p.rcvNxt.Load() {
+			p.mu.Lock()
+			p.close()
+			p.mu.Unlock()
+		}
+		return false
+	case flags.Contains(header.TCPFlagSyn):
+		if hdr.SequenceNumber() != ian {
+			// refer RFC 5961, maybe peer restarted or blind injected syn, reply
+			// challenge ack, restarted peer will reset this endpoint by rst
+			p.challenge()
+		} else if p.dial {
+			// ack of handshake lost, peer retransmit syn-ack
+			p.send(packet.Make(64), header.TCPFlagAck, p.sndNxt.Load(), p.rcvNxt.Load())
+		}
+		return false
+	}
+
+	if flags.Contains(header.TCPFlagAck) && after(hdr.AckNumber(), p.sndNxt.Load()) {
+		return false // ack unsent data
+	}
+	var wnd int32 = 0xffff
+	if wscale {
+		wnd <<= wsShift
+	}
+	if d := int32(hdr.SequenceNumber() - p.rcvNxt.Load()); d > wnd || d < -wnd {
+		return false
+	}
+	return true
+}
+
+// challenge send challenge ack, limit once per handshakeRTO
+func (p *PseudoTCP) challenge() {
+	p.mu.Lock()
+	if p.closed || time.Since(p.challenged) < handshakeRTO {
+		p.mu.Unlock()
+		return
+	}
+	p.challenged = time.Now()
+	p.mu.Unlock()
+
+	p.send(packet.Make(64), header.TCPFlagAck, p.sndNxt.Load(), p.rcvNxt.Load())
+}
+
 func (p *PseudoTCP) handshaked() bool {
 	p.mu.RLock()
 	defer p.mu.RUnlock()
 	return p.established
 }
 
+// establish complete handshake, require hold mu
+func (p *PseudoTCP) establish() {
+	p.established = true
+	if p.rtx != nil {
+		p.rtx.Stop()
+	}
+}
+
+const (
+	handshakeRTO     time.Duration = time.Millisecond * 500
+	handshakeRetries int           = 5
+
+	wsShift      = 14
+	ackThreshold = 0xffff / 2
+)
+
+var wsOption = [4]byte{header.TCPOptionNOP, header.TCPOptionWS, header.TCPOptionWSLength, wsShift}
+
+func (p *PseudoTCP) retransmit() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.established || p.closed {
+		return
+	} else if p.retries >= handshakeRetries {
+		p.close() // give up, next packet will handshake again
+		return
+	}
+
+	p.retries++
+	if p.dial {
+		p.send(packet.Make(64), header.TCPFlagSyn, p.isn, 0)
+	} else {
+		p.send(packet.Make(64), header.TCPFlagSyn|header.TCPFlagAck, p.isn, p.ian+1)
+	}
+	p.rtx.Reset(handshakeRTO << p.retries)
+}
+
 const keepalive time.Duration = time.Second * 15
 
 func (p *PseudoTCP) keepalive() {
 	newMagic := (p.sndNxt.Load() &^ p.rcvNxt.Load())
 	if p.magic == newMagic {
-		// todo: rst
-		p.conn.eps.del(netip.AddrPortFrom(p.raddr, p.rport))
+		p.reset()
 	} else {
 		p.magic = newMagic
-		time.AfterFunc(keepalive, p.keepalive)
+
+		p.mu.RLock()
+		if !p.closed {
+			p.alive.Reset(keepalive)
+		}
+		p.mu.RUnlock()
+	}
+}
+
+// reset notify peer by rst in reliable mode, and close endpoint
+func (p *PseudoTCP) reset() {
+	if p.reliable && p.handshaked() {
+		p.send(packet.Make(64), header.TCPFlagRst|header.TCPFlagAck, p.sndNxt.Load(), p.rcvNxt.Load())
 	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.close()
 }
+
+// close stop timers and remove endpoint, require hold mu
+func (p *PseudoTCP) close() {
+	if p.closed {
+		return
+	}
+	p.closed = true
+	p.cached = nil
+	if p.rtx != nil {
+		p.rtx.Stop()
+	}
+	p.alive.Stop()
+	p.conn.eps.del(netip.AddrPortFrom(p.raddr, p.rport), p)
+}
+
+// after a is after b in sequence space
+func after(a, b uint32) bool { return int32(a-b) > 0 }
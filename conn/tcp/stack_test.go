@@ -0,0 +1,284 @@
+package tcp
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/lysShub/netkit/packet"
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+type pipeConn struct {
+	laddr netip.AddrPort
+	peer  *pipeConn
+	ch    chan []byte
+	drop  func(hdr header.TCP) bool
+	done  chan struct{}
+
+	mu   sync.Mutex
+	sent []header.TCP
+}
+
+func newPipe(a, b netip.AddrPort) (*pipeConn, *pipeConn) {
+	var x = &pipeConn{laddr: a, ch: make(chan []byte, 64), done: make(chan struct{})}
+	var y = &pipeConn{laddr: b, ch: make(chan []byte, 64), done: make(chan struct{})}
+	x.peer, y.peer = y, x
+	return x, y
+}
+
+func (c *pipeConn) ReadFromAddr(b *packet.Packet) (netip.Addr, error) {
+	select {
+	case data := <-c.ch:
+		b.SetData(copy(b.Bytes(), data))
+		return c.peer.laddr.Addr(), nil
+	case <-c.done:
+		return netip.Addr{}, net.ErrClosed
+	}
+}
+
+func (c *pipeConn) WriteToAddr(b *packet.Packet, to netip.Addr) error {
+	hdr := header.TCP(append([]byte{}, b.Bytes()...))
+	c.mu.Lock()
+	c.sent = append(c.sent, hdr)
+	c.mu.Unlock()
+
+	if c.drop != nil && c.drop(hdr) {
+		return nil
+	}
+	select {
+	case c.peer.ch <- hdr:
+	default:
+	}
+	return nil
+}
+
+func (c *pipeConn) AddrPort() netip.AddrPort { return c.laddr }
+func (c *pipeConn) Close() error {
+	close(c.done)
+	return nil
+}
+
+func (c *pipeConn) segments() []header.TCP {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]header.TCP{}, c.sent...)
+}
+
+func pipeTCPConn(t *testing.T) (client, server *TCPConn, craw, sraw *pipeConn) {
+	var (
+		caddr = netip.MustParseAddrPort("127.0.0.1:19986")
+		saddr = netip.MustParseAddrPort("127.0.0.1:8080")
+	)
+	craw, sraw = newPipe(caddr, saddr)
+	client = &TCPConn{laddr: caddr, raw: craw, reliable: true, eps: neweps()}
+	server = &TCPConn{laddr: saddr, raw: sraw, reliable: true, eps: neweps()}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client, server, craw, sraw
+}
+
+func Test_Reliable_Handshake_Loss(t *testing.T) {
+	client, server, craw, sraw := pipeTCPConn(t)
+
+	var syn, synack atomic.Int32
+	craw.drop = func(hdr header.TCP) bool {
+		return hdr.Flags() == header.TCPFlagSyn && syn.Add(1) == 1
+	}
+	sraw.drop = func(hdr header.TCP) bool {
+		return hdr.Flags() == header.TCPFlagSyn|header.TCPFlagAck && synack.Add(1) == 1
+	}
+
+	go func() {
+		for {
+			b := packet.Make(0, 1536)
+			if _, err := client.ReadFromAddrPort(b); err != nil {
+				return
+			}
+		}
+	}()
+
+	err := client.WriteToAddrPort(packet.Make(64).Append([]byte("hello")...), server.LocalAddr())
+	require.NoError(t, err)
+
+	start := time.Now()
+	for {
+		b := packet.Make(0, 1536)
+		raddr, err := server.ReadFromAddrPort(b)
+		require.NoError(t, err)
+		if b.Data() > 0 {
+			require.Equal(t, client.LocalAddr(), raddr)
+			require.Equal(t, "hello", string(b.Bytes()))
+			break
+		}
+	}
+	require.Less(t, time.Since(start), handshakeRTO*4)
+	require.Equal(t, int32(2), syn.Load())
+	require.Equal(t, int32(2), synack.Load())
+
+	// syn and syn-ack negotiate window scale
+	for _, seg := range append(craw.segments(), sraw.segments()...) {
+		if seg.Flags().Contains(header.TCPFlagSyn) {
+			require.Equal(t, wsShift, header.ParseSynOptions(seg.Options(), true).WS)
+		}
+	}
+}
+
+func Test_Reliable_Sequence(t *testing.T) {
+	client, server, craw, sraw := pipeTCPConn(t)
+
+	go func() {
+		for {
+			b := packet.Make(0, 1536)
+			raddr, err := server.ReadFromAddrPort(b)
+			if err != nil {
+				return
+			} else if b.Data() > 0 {
+				server.WriteToAddrPort(b, raddr)
+			}
+		}
+	}()
+
+	for _, msg := range []string{"a", "bb", "ccc"} {
+		err := client.WriteToAddrPort(packet.Make(64).Append([]byte(msg)...), server.LocalAddr())
+		require.NoError(t, err)
+		for {
+			b := packet.Make(0, 1536)
+			_, err := client.ReadFromAddrPort(b)
+			require.NoError(t, err)
+			if b.Data() > 0 {
+				require.Equal(t, msg, string(b.Bytes()))
+				break
+			}
+		}
+	}
+
+	// sequence of each side is continuous from isn+1, ack never exceed
+	// sent data of peer
+	check := func(sent, recv []header.TCP) {
+		var nxt uint32
+		for _, seg := range sent {
+			if seg.Flags().Contains(header.TCPFlagSyn) {
+				nxt = seg.SequenceNumber() + 1
+			} else if len(seg.Payload()) > 0 {
+				require.Equal(t, nxt, seg.SequenceNumber())
+				nxt += uint32(len(seg.Payload()))
+			}
+		}
+		var peerNxt uint32
+		for _, seg := range recv {
+			if seg.Flags().Contains(header.TCPFlagSyn) {
+				peerNxt = seg.SequenceNumber() + 1
+			}
+			peerNxt += uint32(len(seg.Payload()))
+		}
+		for _, seg := range sent {
+			if seg.Flags().Contains(header.TCPFlagAck) {
+				require.False(t, after(seg.AckNumber(), peerNxt))
+			}
+		}
+	}
+	check(craw.segments(), sraw.segments())
+	check(sraw.segments(), craw.segments())
+}
+
+func Test_Reliable_Rst(t *testing.T) {
+	client, server, _, sraw := pipeTCPConn(t)
+
+	go func() {
+		for {
+			b := packet.Make(0, 1536)
+			if _, err := client.ReadFromAddrPort(b); err != nil {
+				return
+			}
+		}
+	}()
+	err := client.WriteToAddrPort(packet.Make(64).Append([]byte("hello")...), server.LocalAddr())
+	require.NoError(t, err)
+	for {
+		b := packet.Make(0, 1536)
+		_, err := server.ReadFromAddrPort(b)
+		require.NoError(t, err)
+		if b.Data() > 0 {
+			break
+		}
+	}
+
+	ep := server.eps.get(client.LocalAddr())
+	require.NotNil(t, ep)
+	ep.reset() // keepalive expiry
+	require.Nil(t, server.eps.get(client.LocalAddr()))
+
+	rst := sraw.segments()[len(sraw.segments())-1]
+	require.True(t, rst.Flags().Contains(header.TCPFlagRst))
+	start := time.Now()
+	for client.eps.get(server.LocalAddr()) != nil {
+		require.Less(t, time.Since(start), time.Second)
+		time.Sleep(time.Millisecond * 10)
+	}
+}
+
+func Test_Reliable_Challenge(t *testing.T) {
+	client, server, _, sraw := pipeTCPConn(t)
+
+	go func() {
+		for {
+			b := packet.Make(0, 1536)
+			if _, err := client.ReadFromAddrPort(b); err != nil {
+				return
+			}
+		}
+	}()
+	var recved = make(chan string, 8)
+	go func() {
+		for {
+			b := packet.Make(0, 1536)
+			if _, err := server.ReadFromAddrPort(b); err != nil {
+				return
+			} else if b.Data() > 0 {
+				recved <- string(b.Bytes())
+			}
+		}
+	}()
+	require.NoError(t, client.WriteToAddrPort(packet.Make(64).Append([]byte("hello")...), server.LocalAddr()))
+	require.Equal(t, "hello", <-recved)
+	old := server.eps.get(client.LocalAddr())
+	require.NotNil(t, old)
+
+	t.Run("blind syn", func(t *testing.T) {
+		syn := packet.Make(0, header.TCPMinimumSize)
+		header.TCP(syn.Bytes()).Encode(&header.TCPFields{
+			SrcPort: client.LocalAddr().Port(), DstPort: server.LocalAddr().Port(),
+			SeqNum: old.ian + 0xffff, DataOffset: header.TCPMinimumSize, Flags: header.TCPFlagSyn,
+		})
+		require.NoError(t, old.Recv(syn))
+		require.Equal(t, old, server.eps.get(client.LocalAddr()))
+
+		ack := sraw.segments()[len(sraw.segments())-1]
+		require.Equal(t, header.TCPFlagAck, ack.Flags())
+		require.Equal(t, old.rcvNxt.Load(), ack.AckNumber())
+	})
+
+	t.Run("peer restart", func(t *testing.T) {
+		time.Sleep(handshakeRTO) // challenge ack rate limit, and delivered
+
+		ep := client.eps.get(server.LocalAddr())
+		ep.mu.Lock()
+		ep.close() // without rst
+		ep.mu.Unlock()
+		require.NoError(t, client.WriteToAddrPort(packet.Make(64).Append([]byte("again")...), server.LocalAddr()))
+		select {
+		case msg := <-recved:
+			require.Equal(t, "again", msg)
+		case <-time.After(time.Second * 3):
+			t.Fatal("handshake not recovered")
+		}
+		require.NotEqual(t, old, server.eps.get(client.LocalAddr()))
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"net/netip"
 	"sync"
 
+	"github.com/lysShub/anton-planet-accelerator/conn/internal/batch"
 	"github.com/lysShub/netkit/errorx"
 	"github.com/lysShub/netkit/packet"
 	"github.com/pkg/errors"
@@ -15,15 +16,31 @@ import (
 //	先调用Write的触发握手（不会有server主动发送数据的情况，因为Conn只提供WriteTo）。
 //	先收到SYN的接受握手
 type TCPConn struct {
-	laddr netip.AddrPort
-	raw   IPConn
+	laddr    netip.AddrPort
+	raw      IPConn
+	reliable bool
 
 	eps *eps
 }
 
 // Bind bind a datagram pseudo-tcp connect, require call ReadFromAddrPort always.
 func Bind(laddr netip.AddrPort) (*TCPConn, error) {
-	var c = &TCPConn{eps: neweps()}
+	return BindConfig(laddr, nil)
+}
+
+type Config struct {
+	// Reliable enable reliable mode of PseudoTCP, both side should enable it.
+	// it only make the stream acceptable by stateful middlebox, data is not
+	// retransmitted.
+	Reliable bool
+}
+
+// BindConfig refer Bind, nil config is default
+func BindConfig(laddr netip.AddrPort, config *Config) (*TCPConn, error) {
+	if config == nil {
+		config = &Config{}
+	}
+	var c = &TCPConn{reliable: config.Reliable, eps: neweps()}
 	var err error
 
 	c.raw, err = BindIPConn(laddr, header.TCPProtocolNumber)
@@ -65,8 +82,14 @@ func (c *TCPConn) ReadFromAddrPort(b *packet.Packet) (netip.AddrPort, error) {
 	return raddr, nil
 }
 
+func (c *TCPConn) ReadBatch(msgs []batch.Message) (int, error)  { return batch.Read(c, msgs) }
+func (c *TCPConn) WriteBatch(msgs []batch.Message) (int, error) { return batch.Write(c, msgs) }
+
 func (c *TCPConn) LocalAddr() netip.AddrPort { return c.laddr }
-func (c *TCPConn) Close() error              { return c.raw.Close() }
+func (c *TCPConn) Close() error {
+	c.eps.close()
+	return c.raw.Close()
+}
 
 type eps struct {
 	mu  sync.RWMutex
@@ -90,8 +113,22 @@ func (e *eps) set(raddr netip.AddrPort, ep *PseudoTCP) *PseudoTCP {
 	e.eps[raddr] = ep
 	return ep
 }
-func (e *eps) del(raddr netip.AddrPort) {
+func (e *eps) del(raddr netip.AddrPort, ep *PseudoTCP) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
-	delete(e.eps, raddr)
+	if e.eps[raddr] == ep {
+		delete(e.eps, raddr)
+	}
+}
+func (e *eps) close() {
+	e.mu.Lock()
+	var eps = e.eps
+	e.eps = map[netip.AddrPort]*PseudoTCP{}
+	e.mu.Unlock()
+
+	for _, ep := range eps {
+		ep.mu.Lock()
+		ep.close()
+		ep.mu.Unlock()
+	}
 }
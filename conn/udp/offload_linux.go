@@ -0,0 +1,217 @@
+//go:build linux
+// +build linux
+
+package udp
+
+import (
+	"encoding/binary"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+
+	"github.com/lysShub/anton-planet-accelerator/conn/internal/batch"
+	"github.com/pkg/errors"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	maxSegments = 64              // UDP_MAX_SEGMENTS
+	maxCoalesce = 0xffff - 20 - 8 // max udp payload of ipv4
+)
+
+// offload udp segmentation offload state, refer UDP_SEGMENT and UDP_GRO
+type offload struct {
+	gso atomic.Bool // false if UDP_SEGMENT not work, such as checksum offload disabled
+
+	// coalesced datagrams read by recvmmsg, delivered segment by segment,
+	// only one goroutine read
+	rms   []ipv4.Message
+	recvd []coalesced
+	ri    int // index of recvd
+	roff  int // offset of recvd[ri]
+
+	wmu   sync.Mutex
+	bufs  [][]byte
+	wms   []ipv4.Message
+	wcnt  []int // messages count of wms
+	woobs [][]byte
+}
+
+type coalesced struct {
+	addr netip.AddrPort
+	b    []byte
+	seg  int // segment size
+}
+
+// EnableOffload enable UDP_SEGMENT and UDP_GRO, datagrams coalesced by GRO
+// are split by read, WriteBatch coalesce consecutive messages with the same
+// destination and size. should be called before read and write.
+func (c *udpConn) EnableOffload() error {
+	raw, err := c.conn.SyscallConn()
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	var serr error
+	if err = raw.Control(func(fd uintptr) {
+		// probe UDP_SEGMENT(4.18), set by cmsg of each write
+		serr = unix.SetsockoptInt(int(fd), unix.SOL_UDP, unix.UDP_SEGMENT, 0)
+		if serr == nil {
+			serr = unix.SetsockoptInt(int(fd), unix.SOL_UDP, unix.UDP_GRO, 1)
+		}
+	}); err != nil {
+		return errors.WithStack(err)
+	} else if serr != nil {
+		return errors.WithStack(serr)
+	}
+
+	c.off = &offload{}
+	c.off.gso.Store(true)
+	return nil
+}
+
+// readGRO read by recvmmsg, split datagrams coalesced by GRO, the remained
+// datagrams are delivered by later reads in order
+func (c *udpConn) readGRO(msgs []batch.Message) (int, error) {
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+	o := c.off
+	if o.ri >= len(o.recvd) {
+		if err := o.recv(c.pc, len(msgs)); err != nil {
+			return 0, err
+		}
+	}
+
+	var n int
+	for ; n < len(msgs) && o.ri < len(o.recvd); n++ {
+		r := o.recvd[o.ri]
+		seg := r.b[o.roff:min(o.roff+r.seg, len(r.b))]
+		if o.roff += len(seg); o.roff >= len(r.b) {
+			o.ri, o.roff = o.ri+1, 0
+		}
+
+		// truncate as recvmsg if buffer too small
+		msgs[n].Packet.SetData(copy(msgs[n].Packet.Bytes(), seg))
+		msgs[n].Addr = r.addr
+	}
+	return n, nil
+}
+
+func (o *offload) recv(pc *ipv4.PacketConn, size int) error {
+	for len(o.rms) < size {
+		o.rms = append(o.rms, ipv4.Message{
+			Buffers: [][]byte{make([]byte, 0xffff)},
+			OOB:     make([]byte, unix.CmsgSpace(4)),
+		})
+	}
+
+	n, err := pc.ReadBatch(o.rms[:size], 0)
+	if err != nil {
+		return err
+	}
+	o.recvd, o.ri, o.roff = o.recvd[:0], 0, 0
+	for _, m := range o.rms[:n] {
+		seg := groSize(m.OOB[:m.NN])
+		if seg <= 0 || seg > m.N {
+			seg = m.N
+		}
+
+		// dual-stack socket return ipv4-mapped address
+		addr := m.Addr.(*net.UDPAddr).AddrPort()
+		o.recvd = append(o.recvd, coalesced{
+			addr: netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port()),
+			b:    m.Buffers[0][:m.N],
+			seg:  seg,
+		})
+	}
+	return nil
+}
+
+// groSize segment size of coalesced datagrams, 0 if not coalesced
+func groSize(oob []byte) int {
+	cms, err := unix.ParseSocketControlMessage(oob)
+	if err != nil {
+		return 0
+	}
+	for _, e := range cms {
+		if e.Header.Level == unix.SOL_UDP && e.Header.Type == unix.UDP_GRO && len(e.Data) >= 4 {
+			return int(int32(binary.NativeEndian.Uint32(e.Data)))
+		}
+	}
+	return 0
+}
+
+// writeGSO write by sendmmsg, consecutive messages with the same destination
+// and size are coalesced by UDP_SEGMENT, the last one can be shorter
+func (c *udpConn) writeGSO(msgs []batch.Message) (int, error) {
+	o := c.off
+	o.wmu.Lock()
+	defer o.wmu.Unlock()
+	defer func() {
+		clear(o.bufs)
+		clear(o.wms)
+	}()
+
+	o.bufs = o.bufs[:0]
+	for _, e := range msgs {
+		o.bufs = append(o.bufs, e.Packet.Bytes())
+	}
+	o.wms, o.wcnt = o.wms[:0], o.wcnt[:0]
+	for i := 0; i < len(msgs); {
+		seg, total := len(o.bufs[i]), len(o.bufs[i])
+		j := i + 1
+		for seg > 0 && j < len(msgs) && j-i < maxSegments && msgs[j].Addr == msgs[i].Addr {
+			n := len(o.bufs[j])
+			if n > seg || total+n > maxCoalesce {
+				break
+			}
+			total, j = total+n, j+1
+			if n < seg {
+				break // shorter one must be the last
+			}
+		}
+
+		var m = ipv4.Message{Buffers: o.bufs[i:j], Addr: net.UDPAddrFromAddrPort(msgs[i].Addr)}
+		if j-i > 1 {
+			if len(o.woobs) <= len(o.wms) {
+				o.woobs = append(o.woobs, make([]byte, unix.CmsgSpace(2)))
+			}
+			m.OOB = segmentCmsg(o.woobs[len(o.wms)], seg)
+		}
+		o.wms, o.wcnt = append(o.wms, m), append(o.wcnt, j-i)
+		i = j
+	}
+
+	var n, k int
+	for k < len(o.wms) {
+		w, err := c.pc.WriteBatch(o.wms[k:], 0)
+		for _, e := range o.wcnt[k : k+w] {
+			n += e
+		}
+		k += w
+		if err != nil {
+			if errors.Is(err, unix.EIO) {
+				o.gso.Store(false) // segment require checksum offload
+			} else if !errors.Is(err, unix.EINVAL) {
+				return n, err
+			}
+
+			// fall back without UDP_SEGMENT, such as segment exceed mtu
+			m, err := c.writeBatch(msgs[n:])
+			return n + m, err
+		}
+	}
+	return n, nil
+}
+
+func segmentCmsg(b []byte, size int) []byte {
+	b = b[:unix.CmsgSpace(2)]
+	h := (*unix.Cmsghdr)(unsafe.Pointer(&b[0]))
+	h.Level, h.Type = unix.SOL_UDP, unix.UDP_SEGMENT
+	h.SetLen(unix.CmsgLen(2))
+	binary.NativeEndian.PutUint16(b[unix.CmsgLen(0):], uint16(size))
+	return b
+}
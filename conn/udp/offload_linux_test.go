@@ -0,0 +1,90 @@
+//go:build linux
+// +build linux
+
+package udp
+
+import (
+	"bytes"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/lysShub/anton-planet-accelerator/conn/internal/batch"
+	"github.com/lysShub/netkit/packet"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Offload(t *testing.T) {
+	bind := func(offload bool) *udpConn {
+		c, err := Bind(netip.MustParseAddrPort("127.0.0.1:0"))
+		require.NoError(t, err)
+		t.Cleanup(func() { c.Close() })
+		if offload {
+			require.NoError(t, c.EnableOffload())
+		}
+		return c
+	}
+	sender := bind(true)
+
+	// the shorter one end a segment group
+	var sizes = []int{1000, 1000, 1000, 1000, 1000, 500, 1000, 1000, 1000}
+	for _, gro := range []bool{true, false} {
+		recver := bind(gro)
+
+		var msgs = make([]batch.Message, len(sizes))
+		for i, e := range sizes {
+			msgs[i] = batch.Message{
+				Packet: packet.Make(64).Append(bytes.Repeat([]byte{byte(i)}, e)...),
+				Addr:   recver.LocalAddr(),
+			}
+		}
+		n, err := sender.WriteBatch(msgs)
+		require.NoError(t, err)
+		require.Equal(t, len(msgs), n)
+
+		var got int
+		for got < len(sizes) {
+			require.NoError(t, recver.SetReadDeadline(time.Now().Add(time.Second)))
+			var pkt = packet.Make(0, 1500)
+			addr, err := recver.ReadFromAddrPort(pkt)
+			require.NoError(t, err)
+			require.Equal(t, sender.LocalAddr(), addr)
+			require.Equal(t, bytes.Repeat([]byte{byte(got)}, sizes[got]), pkt.Bytes())
+			got++
+		}
+		if gro {
+			r := recver.off.recvd[0]
+			require.Less(t, r.seg, len(r.b), "not coalesced")
+		}
+	}
+}
+
+func Benchmark_Offload(b *testing.B) {
+	const size = 32
+	sender, recver := benchPair(b)
+	require.NoError(b, sender.EnableOffload())
+	require.NoError(b, recver.EnableOffload())
+	var rmsgs = make([]batch.Message, size)
+	for i := range rmsgs {
+		rmsgs[i].Packet = packet.Make(0, 0xffff)
+	}
+	ch := recv(recver, func() (int, error) {
+		for _, e := range rmsgs {
+			e.Packet.Sets(0, 0xffff)
+		}
+		return recver.ReadBatch(rmsgs)
+	})
+
+	var wmsgs = make([]batch.Message, size)
+	for i := range wmsgs {
+		wmsgs[i] = batch.Message{Packet: packet.Make(64, benchSize), Addr: recver.LocalAddr()}
+	}
+	b.SetBytes(benchSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i += size {
+		if _, err := sender.WriteBatch(wmsgs[:min(size, b.N-i)]); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(<-ch)/float64(b.N), "recv/op")
+}
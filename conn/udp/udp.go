@@ -4,15 +4,20 @@ import (
 	"log/slog"
 	"net"
 	"net/netip"
+	"time"
 
+	"github.com/lysShub/anton-planet-accelerator/conn/internal/batch"
 	"github.com/lysShub/netkit/debug"
 	"github.com/lysShub/netkit/errorx"
 	"github.com/lysShub/netkit/packet"
 	"github.com/pkg/errors"
+	"golang.org/x/net/ipv4"
 )
 
 type udpConn struct {
 	conn *net.UDPConn
+	pc   *ipv4.PacketConn // batch io, only linux support recvmmsg/sendmmsg
+	off  *offload         // nil if offload not enabled
 }
 
 func Bind(laddr netip.AddrPort) (*udpConn, error) {
@@ -20,10 +25,19 @@ func Bind(laddr netip.AddrPort) (*udpConn, error) {
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
-	return &udpConn{conn}, nil
+	return &udpConn{conn: conn, pc: ipv4.NewPacketConn(conn)}, nil
 }
 
 func (c *udpConn) ReadFromAddrPort(b *packet.Packet) (netip.AddrPort, error) {
+	if c.off != nil {
+		// datagrams coalesced by GRO are split by ReadBatch
+		var msgs = [1]batch.Message{{Packet: b}}
+		if _, err := c.ReadBatch(msgs[:]); err != nil {
+			return netip.AddrPort{}, err
+		}
+		return msgs[0].Addr, nil
+	}
+
 	n, addr, err := c.conn.ReadFromUDPAddrPort(b.Bytes())
 	if err != nil {
 		return netip.AddrPort{}, err
@@ -32,7 +46,9 @@ func (c *udpConn) ReadFromAddrPort(b *packet.Packet) (netip.AddrPort, error) {
 		slog.Warn("too short warning", errorx.Trace(nil))
 	}
 	b.SetData(n)
-	return addr, nil
+
+	// dual-stack socket return ipv4-mapped address
+	return netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port()), nil
 }
 
 func (c *udpConn) WriteToAddrPort(b *packet.Packet, dst netip.AddrPort) error {
@@ -42,6 +58,9 @@ func (c *udpConn) WriteToAddrPort(b *packet.Packet, dst netip.AddrPort) error {
 
 func (c *udpConn) Close() error { return c.conn.Close() }
 
+// SetReadDeadline refer net.UDPConn, used by exclusive read such as stun
+func (c *udpConn) SetReadDeadline(t time.Time) error { return c.conn.SetReadDeadline(t) }
+
 func (c *udpConn) LocalAddr() netip.AddrPort {
 	return netip.MustParseAddrPort(c.conn.LocalAddr().String())
 }
@@ -0,0 +1,96 @@
+//go:build linux
+// +build linux
+
+package udp
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+
+	"github.com/lysShub/anton-planet-accelerator/conn/internal/batch"
+	"golang.org/x/net/ipv4"
+)
+
+var msgsPool = sync.Pool{New: func() any { return &[]ipv4.Message{} }}
+
+func getMsgs(n int) *[]ipv4.Message {
+	ms := msgsPool.Get().(*[]ipv4.Message)
+	if cap(*ms) < n {
+		*ms = make([]ipv4.Message, n)
+		for i := range *ms {
+			(*ms)[i].Buffers = make([][]byte, 1)
+		}
+	}
+	*ms = (*ms)[:n]
+	return ms
+}
+
+func putMsgs(ms *[]ipv4.Message) {
+	for i := range *ms {
+		(*ms)[i].Buffers[0], (*ms)[i].Addr = nil, nil
+	}
+	msgsPool.Put(ms)
+}
+
+// ReadBatch read by recvmmsg
+func (c *udpConn) ReadBatch(msgs []batch.Message) (int, error) {
+	if c.off != nil {
+		return c.readGRO(msgs)
+	}
+	return c.readBatch(msgs)
+}
+
+func (c *udpConn) readBatch(msgs []batch.Message) (int, error) {
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+	ms := getMsgs(len(msgs))
+	defer putMsgs(ms)
+	for i, e := range msgs {
+		(*ms)[i].Buffers[0] = e.Packet.Bytes()
+	}
+
+	n, err := c.pc.ReadBatch(*ms, 0)
+	if err != nil {
+		return 0, err
+	}
+	for i, m := range (*ms)[:n] {
+		msgs[i].Packet.SetData(m.N)
+
+		// dual-stack socket return ipv4-mapped address
+		addr := m.Addr.(*net.UDPAddr).AddrPort()
+		msgs[i].Addr = netip.AddrPortFrom(addr.Addr().Unmap(), addr.Port())
+	}
+	return n, nil
+}
+
+// WriteBatch write by sendmmsg
+func (c *udpConn) WriteBatch(msgs []batch.Message) (int, error) {
+	if c.off != nil && c.off.gso.Load() {
+		return c.writeGSO(msgs)
+	}
+	return c.writeBatch(msgs)
+}
+
+func (c *udpConn) writeBatch(msgs []batch.Message) (int, error) {
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+	ms := getMsgs(len(msgs))
+	defer putMsgs(ms)
+	for i, e := range msgs {
+		(*ms)[i].Buffers[0] = e.Packet.Bytes()
+		(*ms)[i].Addr = net.UDPAddrFromAddrPort(e.Addr)
+	}
+
+	var n int
+	for n < len(msgs) {
+		k, err := c.pc.WriteBatch((*ms)[n:], 0)
+		n += k
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
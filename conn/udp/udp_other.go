@@ -0,0 +1,21 @@
+//go:build !linux
+// +build !linux
+
+package udp
+
+import (
+	"runtime"
+
+	"github.com/lysShub/anton-planet-accelerator/conn/internal/batch"
+	"github.com/pkg/errors"
+)
+
+type offload struct{}
+
+// EnableOffload only support linux
+func (c *udpConn) EnableOffload() error {
+	return errors.Errorf("not support udp offload on %s", runtime.GOOS)
+}
+
+func (c *udpConn) ReadBatch(msgs []batch.Message) (int, error)  { return batch.Read(c, msgs) }
+func (c *udpConn) WriteBatch(msgs []batch.Message) (int, error) { return batch.Write(c, msgs) }
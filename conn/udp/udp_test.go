@@ -0,0 +1,131 @@
+package udp
+
+import (
+	"fmt"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/lysShub/anton-planet-accelerator/conn/internal/batch"
+	"github.com/lysShub/netkit/packet"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Batch(t *testing.T) {
+	for _, laddr := range []string{"127.0.0.1:0", "0.0.0.0:0", "[::]:0"} {
+		t.Run(laddr, func(t *testing.T) {
+			sender, err := Bind(netip.MustParseAddrPort(laddr))
+			require.NoError(t, err)
+			defer sender.Close()
+			recver, err := Bind(netip.MustParseAddrPort("127.0.0.1:0"))
+			require.NoError(t, err)
+			defer recver.Close()
+
+			var msgs = make([]batch.Message, 8)
+			for i := range msgs {
+				msgs[i].Packet = packet.Make(64).Append([]byte(fmt.Sprint(i))...)
+				msgs[i].Addr = recver.LocalAddr()
+			}
+			n, err := sender.WriteBatch(msgs)
+			require.NoError(t, err)
+			require.Equal(t, len(msgs), n)
+
+			for i := range msgs {
+				msgs[i].Packet = packet.Make(0, 1500)
+			}
+			var got int
+			for got < len(msgs) {
+				require.NoError(t, recver.SetReadDeadline(time.Now().Add(time.Second)))
+				n, err := recver.ReadBatch(msgs[got:])
+				require.NoError(t, err)
+				for _, e := range msgs[got : got+n] {
+					require.Equal(t, fmt.Sprint(got), string(e.Packet.Bytes()))
+					require.Equal(t, uint16(sender.LocalAddr().Port()), e.Addr.Port())
+					require.True(t, e.Addr.Addr().Is4())
+					got++
+				}
+			}
+		})
+	}
+}
+
+const benchSize = 1400
+
+func benchPair(b *testing.B) (sender, recver *udpConn) {
+	var err error
+	sender, err = Bind(netip.MustParseAddrPort("127.0.0.1:0"))
+	require.NoError(b, err)
+	recver, err = Bind(netip.MustParseAddrPort("127.0.0.1:0"))
+	require.NoError(b, err)
+	require.NoError(b, recver.conn.SetReadBuffer(1<<22))
+	b.Cleanup(func() {
+		sender.Close()
+		recver.Close()
+	})
+	return sender, recver
+}
+
+// recv read until no packet arrived, return count of received packets
+func recv(c *udpConn, read func() (int, error)) <-chan int {
+	var ch = make(chan int, 1)
+	go func() {
+		var n int
+		for {
+			c.SetReadDeadline(time.Now().Add(time.Millisecond * 100))
+			k, err := read()
+			if err != nil {
+				ch <- n
+				return
+			}
+			n += k
+		}
+	}()
+	return ch
+}
+
+func Benchmark_Single(b *testing.B) {
+	sender, recver := benchPair(b)
+	var pkt = packet.Make(0, 0xffff)
+	ch := recv(recver, func() (int, error) {
+		_, err := recver.ReadFromAddrPort(pkt.Sets(0, 0xffff))
+		return 1, err
+	})
+
+	var data = packet.Make(64, benchSize)
+	b.SetBytes(benchSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := sender.WriteToAddrPort(data, recver.LocalAddr()); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(<-ch)/float64(b.N), "recv/op")
+}
+
+func Benchmark_Batch(b *testing.B) {
+	const size = 32
+	sender, recver := benchPair(b)
+	var rmsgs = make([]batch.Message, size)
+	for i := range rmsgs {
+		rmsgs[i].Packet = packet.Make(0, 0xffff)
+	}
+	ch := recv(recver, func() (int, error) {
+		for _, e := range rmsgs {
+			e.Packet.Sets(0, 0xffff)
+		}
+		return recver.ReadBatch(rmsgs)
+	})
+
+	var wmsgs = make([]batch.Message, size)
+	for i := range wmsgs {
+		wmsgs[i] = batch.Message{Packet: packet.Make(64, benchSize), Addr: recver.LocalAddr()}
+	}
+	b.SetBytes(benchSize)
+	b.ResetTimer()
+	for i := 0; i < b.N; i += size {
+		if _, err := sender.WriteBatch(wmsgs[:min(size, b.N-i)]); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(<-ch)/float64(b.N), "recv/op")
+}
@@ -4,15 +4,19 @@ go 1.22.0
 
 require (
 	gioui.org v0.6.0
+	github.com/BurntSushi/toml v1.4.0
 	github.com/jftuga/geodist v1.0.0
 	github.com/lysShub/divert-go v0.0.0-20240525230502-6f79596abd61
 	github.com/lysShub/fatun v0.0.0-20240601183817-825fbf1e595e
 	github.com/lysShub/netkit v0.0.0-20240630051200-8be9ae015bcd
 	github.com/lysShub/rawsock v0.0.0-20240601184254-6561883771fe
+	github.com/oschwald/maxminddb-golang v1.12.0
 	github.com/pkg/errors v0.9.1
 	github.com/stretchr/testify v1.9.0
+	golang.org/x/crypto v0.23.0
 	golang.org/x/net v0.25.0
 	golang.org/x/sys v0.20.0
+	gopkg.in/yaml.v3 v3.0.1
 	gvisor.dev/gvisor v0.0.0-20240521174809-5eedbf551134
 )
 
@@ -47,5 +51,4 @@ require (
 	golang.org/x/text v0.15.0 // indirect
 	golang.org/x/time v0.5.0 // indirect
 	golang.zx2c4.com/wireguard/windows v0.5.3 // indirect
-	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
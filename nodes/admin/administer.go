@@ -0,0 +1,358 @@
+package admin
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/gob"
+	"log/slog"
+	"net"
+	"net/netip"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/lysShub/anton-planet-accelerator/bvvd"
+	"github.com/lysShub/netkit/errorx"
+	"github.com/pkg/errors"
+)
+
+// Control controller of nodes, gateways and forwards register to it, forwards
+// are pushed to gateways, clients fetch gateways from it.
+type Control struct {
+	config *Config
+
+	l net.Listener
+
+	mu       sync.RWMutex
+	gateways map[string]*node // name
+	forwards map[string]*node // name
+	closed   bool             // protected by mu, reject register after close
+
+	// serialize forwards change and push to gateways, so gateways receive
+	// pushes in the order of changes, and snapshot of new gateway not
+	// interleaved with boardcast
+	pushMu sync.Mutex
+
+	handles  sync.WaitGroup // connection handlers, wait after close
+	closeErr errorx.CloseErr
+}
+
+// node registered gateway or forward
+type node struct {
+	name string
+	addr netip.AddrPort
+	loc  bvvd.Location
+
+	conn net.Conn
+
+	mu  sync.Mutex
+	enc *gob.Encoder
+}
+
+func (n *node) send(msg Message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return send(n.conn, n.enc, msg)
+}
+
+func send(conn net.Conn, enc *gob.Encoder, msg Message) error {
+	if err := conn.SetWriteDeadline(time.Now().Add(timeout)); err != nil {
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(enc.Encode(&msg))
+}
+
+const timeout = time.Second * 5
+
+func New(addr string, config *Config) (*Control, error) {
+	if err := config.init(); err != nil {
+		return nil, err
+	}
+	var c = &Control{
+		config:   config,
+		gateways: map[string]*node{},
+		forwards: map[string]*node{},
+	}
+
+	laddr, err := net.ResolveTCPAddr("tcp", addr)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	l, err := net.ListenTCP("tcp", laddr)
+	if err != nil {
+		return nil, c.close(err)
+	}
+	c.l = tls.NewListener(l, config.TLS)
+
+	return c, nil
+}
+
+func (c *Control) close(cause error) error {
+	cause = errors.WithStack(cause)
+	if !c.closeErr.Closed() {
+		if cause != nil {
+			c.config.logger.Error(cause.Error(), errorx.Trace(cause))
+		} else {
+			c.config.logger.Info("close")
+		}
+	}
+	return c.closeErr.Close(func() (errs []error) {
+		errs = append(errs, cause)
+		if c.l != nil {
+			errs = append(errs, errors.WithStack(c.l.Close()))
+		}
+
+		c.mu.Lock()
+		c.closed = true
+		for _, e := range c.gateways {
+			e.conn.Close()
+		}
+		for _, e := range c.forwards {
+			e.conn.Close()
+		}
+		c.mu.Unlock()
+		errs = append(errs, c.config.closeLog())
+		return errs
+	})
+}
+
+func (c *Control) Addr() netip.AddrPort {
+	return c.l.Addr().(*net.TCPAddr).AddrPort()
+}
+
+// Serve serve until ctx done or error occurred, stopped by ctx will
+// return nil. return after all connection handlers exited.
+func (c *Control) Serve(ctx context.Context) (err error) {
+	c.config.logger.Info("start", slog.String("listen", c.l.Addr().String()), slog.Bool("auth", c.config.Authenticator != nil))
+	stop := context.AfterFunc(ctx, func() { c.close(nil) })
+	defer stop()
+
+	for {
+		conn, err := c.l.Accept()
+		if err != nil {
+			err = c.close(err)
+			c.handles.Wait()
+			return err
+		}
+
+		c.handles.Add(1)
+		go func() {
+			defer c.handles.Done()
+			c.muxHandle(conn.(*tls.Conn))
+		}()
+	}
+}
+
+func (c *Control) Close() error { return c.close(nil) }
+
+// Gateways get registered gateways
+func (c *Control) Gateways() []netip.AddrPort {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var gaddrs = make([]netip.AddrPort, 0, len(c.gateways))
+	for _, e := range c.gateways {
+		gaddrs = append(gaddrs, e.addr)
+	}
+	slices.SortFunc(gaddrs, func(a, b netip.AddrPort) int { return a.Compare(b) })
+	return gaddrs
+}
+
+func (c *Control) muxHandle(conn *tls.Conn) (_ error) {
+	defer conn.Close()
+	var initMsg Message
+
+	enc, dec := gob.NewEncoder(conn), gob.NewDecoder(conn)
+
+	conn.SetDeadline(time.Now().Add(timeout))
+	err := conn.Handshake()
+	if err == nil {
+		err = dec.Decode(&initMsg)
+	}
+	if err != nil {
+		c.config.logger.Error(err.Error(), slog.String("remote", conn.RemoteAddr().String()), errorx.Trace(nil))
+		return nil
+	}
+	conn.SetDeadline(time.Time{})
+
+	switch msg := initMsg.(type) {
+	case ClientNew:
+		err = c.clientNew(conn, enc, msg)
+	case GatewayNew:
+		err = c.gatewayNew(conn, enc, dec, msg)
+	case ForwardNew:
+		err = c.forwardNew(conn, enc, dec, msg)
+	default:
+		c.config.logger.Warn("invalid init message kind", slog.String("kind", initMsg.Kind().String()), slog.String("remote", conn.RemoteAddr().String()))
+	}
+	if err != nil {
+		c.config.logger.Warn(err.Error(), slog.String("remote", conn.RemoteAddr().String()))
+	}
+	return nil
+}
+
+func (c *Control) clientNew(conn *tls.Conn, enc *gob.Encoder, msg ClientNew) error {
+	if c.config.Authenticator != nil {
+		if _, err := c.config.Authenticator.Authenticate(msg.Token); err != nil {
+			msg.Msg = err.Error()
+			send(conn, enc, msg)
+			return errors.WithMessage(err, "client authenticate")
+		}
+	}
+
+	msg.Token = ""
+	msg.Ok, msg.Gateways = true, c.Gateways()
+	return send(conn, enc, msg)
+}
+
+func (c *Control) gatewayNew(conn *tls.Conn, enc *gob.Encoder, dec *gob.Decoder, msg GatewayNew) error {
+	var n, err = c.register(conn, enc, RoleGateway, msg.Addr, msg.Location)
+	if err != nil {
+		msg.Msg = err.Error()
+		send(conn, enc, msg)
+		return err
+	}
+	msg.Name, msg.Addr, msg.Ok = n.name, n.addr, true
+	if err := n.send(msg); err != nil {
+		return err
+	}
+
+	c.pushMu.Lock()
+	c.mu.Lock()
+	if old, has := c.gateways[n.name]; has {
+		old.conn.Close()
+	}
+	c.gateways[n.name] = n
+	if c.closed {
+		n.conn.Close()
+	}
+	var fs = make([]*node, 0, len(c.forwards))
+	for _, e := range c.forwards {
+		fs = append(fs, e)
+	}
+	c.mu.Unlock()
+	c.config.logger.Info("add gateway", slog.String("name", n.name), slog.String("addr", n.addr.String()), slog.String("location", n.loc.String()))
+
+	for _, f := range fs {
+		if err := n.send(ProxyAddForward{Name: f.name, Addr: f.addr, Location: f.loc}); err != nil {
+			n.conn.Close()
+			break
+		}
+	}
+	c.pushMu.Unlock()
+
+	// gateway not send message, only detect disconnect
+	for {
+		var m Message
+		if err = dec.Decode(&m); err != nil {
+			break
+		}
+	}
+
+	c.mu.Lock()
+	if c.gateways[n.name] == n {
+		delete(c.gateways, n.name)
+	}
+	c.mu.Unlock()
+	c.config.logger.Info("del gateway", slog.String("name", n.name), slog.String("addr", n.addr.String()))
+	return nil
+}
+
+func (c *Control) forwardNew(conn *tls.Conn, enc *gob.Encoder, dec *gob.Decoder, msg ForwardNew) error {
+	var n, err = c.register(conn, enc, RoleForward, msg.Addr, msg.Location)
+	if err != nil {
+		msg.Msg = err.Error()
+		send(conn, enc, msg)
+		return err
+	}
+	msg.Name, msg.Ok = n.name, true
+	if err := n.send(msg); err != nil {
+		return err
+	}
+
+	c.pushMu.Lock()
+	c.mu.Lock()
+	old, has := c.forwards[n.name]
+	if has {
+		old.conn.Close()
+	}
+	c.forwards[n.name] = n
+	if c.closed {
+		n.conn.Close()
+	}
+	c.mu.Unlock()
+	c.config.logger.Info("add forward", slog.String("name", n.name), slog.String("addr", n.addr.String()), slog.String("location", n.loc.String()))
+
+	if has && old.addr != n.addr {
+		c.boardcast(ForwardStop{Name: old.name, Addr: old.addr})
+	}
+	c.boardcast(ProxyAddForward{Name: n.name, Addr: n.addr, Location: n.loc})
+	c.pushMu.Unlock()
+
+	for {
+		var m Message
+		if err = dec.Decode(&m); err != nil {
+			break
+		} else if _, ok := m.(ForwardStop); ok {
+			break
+		}
+	}
+
+	c.pushMu.Lock()
+	defer c.pushMu.Unlock()
+	c.mu.Lock()
+	deleted := c.forwards[n.name] == n
+	if deleted {
+		delete(c.forwards, n.name)
+	}
+	c.mu.Unlock()
+	if deleted {
+		c.config.logger.Info("del forward", slog.String("name", n.name), slog.String("addr", n.addr.String()))
+		c.boardcast(ForwardStop{Name: n.name, Addr: n.addr})
+	}
+	return nil
+}
+
+// register validate registration of gateway or forward, the node name is
+// common name of certificate
+func (c *Control) register(conn *tls.Conn, enc *gob.Encoder, expect Role, addr netip.AddrPort, loc bvvd.Location) (*node, error) {
+	name, role, err := peer(conn.ConnectionState())
+	if err != nil {
+		return nil, err
+	} else if role != expect {
+		return nil, errors.Errorf("%s certificate %s can't register as %s", role, name, expect)
+	} else if !addr.IsValid() || addr.Port() == 0 {
+		return nil, errors.Errorf("node %s invalid address %s", name, addr.String())
+	}
+
+	if addr.Addr().IsUnspecified() {
+		raddr := conn.RemoteAddr().(*net.TCPAddr).AddrPort()
+		addr = netip.AddrPortFrom(raddr.Addr().Unmap(), addr.Port())
+	}
+	if role == RoleGateway {
+		if loc != 0 && loc.Valid() != nil {
+			return nil, errors.Errorf("gateway %s invalid location %d", name, loc)
+		}
+	} else if err := loc.Valid(); err != nil {
+		return nil, errors.WithMessagef(err, "forward %s", name)
+	}
+
+	return &node{name: name, addr: addr, loc: loc, conn: conn, enc: enc}, nil
+}
+
+// boardcast push message to all gateways, require hold pushMu
+func (c *Control) boardcast(msg Message) {
+	c.mu.RLock()
+	var gs = make([]*node, 0, len(c.gateways))
+	for _, e := range c.gateways {
+		gs = append(gs, e)
+	}
+	c.mu.RUnlock()
+
+	for _, g := range gs {
+		if err := g.send(msg); err != nil {
+			c.config.logger.Warn(err.Error(), slog.String("gateway", g.name), slog.String("kind", msg.Kind().String()))
+			g.conn.Close()
+		}
+	}
+}
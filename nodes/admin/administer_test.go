@@ -0,0 +1,189 @@
+package admin_test
+
+import (
+	"context"
+	"crypto/tls"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lysShub/anton-planet-accelerator/bvvd"
+	"github.com/lysShub/anton-planet-accelerator/nodes/admin"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+type auth struct{}
+
+func (auth) Authenticate(token string) (string, error) {
+	if token == "client" {
+		return "user", nil
+	}
+	return "", errors.New("invalid token")
+}
+
+// certs issue certificates, return tls config of controller, gateway, forward
+// and client
+type certs struct {
+	t           *testing.T
+	dir         string
+	caCrt, caKy []byte
+}
+
+func newCerts(t *testing.T) *certs {
+	crt, key, err := admin.NewCA("test ca", time.Hour)
+	require.NoError(t, err)
+	c := &certs{t: t, dir: t.TempDir(), caCrt: crt, caKy: key}
+	require.NoError(t, os.WriteFile(filepath.Join(c.dir, "ca.crt"), crt, 0o666))
+	return c
+}
+
+func (c *certs) issue(role admin.Role, name string) (crt, key string) {
+	cert, priv, err := admin.Issue(c.caCrt, c.caKy, role, name, []string{"127.0.0.1"}, time.Hour)
+	require.NoError(c.t, err)
+
+	crt, key = filepath.Join(c.dir, name+".crt"), filepath.Join(c.dir, name+".key")
+	require.NoError(c.t, os.WriteFile(crt, cert, 0o666))
+	require.NoError(c.t, os.WriteFile(key, priv, 0o666))
+	return crt, key
+}
+
+func (c *certs) server() *tls.Config {
+	crt, key := c.issue(admin.RoleController, "admin")
+	cfg, err := admin.ServerTLS(filepath.Join(c.dir, "ca.crt"), crt, key, filepath.Join(c.dir, "revoked.txt"))
+	require.NoError(c.t, err)
+	return cfg
+}
+
+func (c *certs) client(role admin.Role, name string) *tls.Config {
+	var crt, key string
+	if name != "" {
+		crt, key = c.issue(role, name)
+	}
+	cfg, err := admin.ClientTLS(filepath.Join(c.dir, "ca.crt"), crt, key)
+	require.NoError(c.t, err)
+	return cfg
+}
+
+func Test_Control(t *testing.T) {
+	cs := newCerts(t)
+	c, err := admin.New("127.0.0.1:0", &admin.Config{TLS: cs.server(), Authenticator: auth{}})
+	require.NoError(t, err)
+	defer c.Close()
+	go c.Serve(context.Background())
+	addr := c.Addr().String()
+
+	var (
+		gaddr  = netip.MustParseAddrPort("0.0.0.0:19986")
+		faddr1 = netip.MustParseAddrPort("1.1.1.1:19986")
+		faddr2 = netip.MustParseAddrPort("2.2.2.2:19986")
+
+		client = cs.client("", "")
+		gcfg   = cs.client(admin.RoleGateway, "g")
+		f1cfg  = cs.client(admin.RoleForward, "f1")
+	)
+
+	t.Run("invalid token", func(t *testing.T) {
+		_, err := admin.Gateways(addr, client, "x")
+		require.Error(t, err)
+	})
+
+	t.Run("invalid location", func(t *testing.T) {
+		_, _, err := admin.Register(addr, f1cfg, admin.ForwardNew{Addr: faddr1})
+		require.Error(t, err)
+	})
+
+	t.Run("without certificate", func(t *testing.T) {
+		_, _, err := admin.Register(addr, client, admin.GatewayNew{Addr: gaddr})
+		require.Error(t, err)
+	})
+
+	t.Run("role mismatch", func(t *testing.T) {
+		_, _, err := admin.Register(addr, f1cfg, admin.GatewayNew{Addr: gaddr})
+		require.Error(t, err)
+	})
+
+	t.Run("revoked", func(t *testing.T) {
+		rcfg := cs.client(admin.RoleGateway, "r")
+		cert, err := os.ReadFile(filepath.Join(cs.dir, "r.crt"))
+		require.NoError(t, err)
+		require.NoError(t, admin.Revoke(filepath.Join(cs.dir, "revoked.txt"), cert))
+
+		_, _, err = admin.Register(addr, rcfg, admin.GatewayNew{Addr: gaddr})
+		require.Error(t, err)
+	})
+
+	t.Run("other ca", func(t *testing.T) {
+		rogue := newCerts(t).client(admin.RoleGateway, "g")
+		rogue.RootCAs = client.RootCAs
+
+		_, _, err := admin.Register(addr, rogue, admin.GatewayNew{Addr: gaddr})
+		require.Error(t, err)
+	})
+
+	f1, reply, err := admin.Register(addr, f1cfg, admin.ForwardNew{Addr: faddr1, Location: bvvd.Moscow})
+	require.NoError(t, err)
+	defer f1.Close()
+	require.Equal(t, "f1", reply.(admin.ForwardNew).Name)
+
+	g, reply, err := admin.Register(addr, gcfg, admin.GatewayNew{Addr: gaddr})
+	require.NoError(t, err)
+	defer g.Close()
+	require.Equal(t, "g", reply.(admin.GatewayNew).Name)
+	require.Equal(t, "127.0.0.1:19986", reply.(admin.GatewayNew).Addr.String())
+
+	msg, err := g.Recv()
+	require.NoError(t, err)
+	require.Equal(t, admin.ProxyAddForward{Name: "f1", Addr: faddr1, Location: bvvd.Moscow}, msg)
+
+	gaddrs, err := admin.Gateways(addr, client, "client")
+	require.NoError(t, err)
+	require.Equal(t, []netip.AddrPort{netip.MustParseAddrPort("127.0.0.1:19986")}, gaddrs)
+
+	t.Run("add forward", func(t *testing.T) {
+		f2, _, err := admin.Register(addr, cs.client(admin.RoleForward, "f2"), admin.ForwardNew{Addr: faddr2, Location: bvvd.LosAngeles})
+		require.NoError(t, err)
+
+		msg, err := g.Recv()
+		require.NoError(t, err)
+		require.Equal(t, admin.ProxyAddForward{Name: "f2", Addr: faddr2, Location: bvvd.LosAngeles}, msg)
+
+		// disconnect means stop
+		require.NoError(t, f2.Close())
+		msg, err = g.Recv()
+		require.NoError(t, err)
+		require.Equal(t, admin.ForwardStop{Name: "f2", Addr: faddr2}, msg)
+	})
+
+	t.Run("forward stop", func(t *testing.T) {
+		require.NoError(t, f1.Send(admin.ForwardStop{Name: "f1", Addr: faddr1}))
+
+		msg, err := g.Recv()
+		require.NoError(t, err)
+		require.Equal(t, admin.ForwardStop{Name: "f1", Addr: faddr1}, msg)
+	})
+}
+
+func Test_Issue(t *testing.T) {
+	crt, key, err := admin.NewCA("test ca", time.Hour)
+	require.NoError(t, err)
+
+	_, _, err = admin.Issue(crt, key, admin.Role("proxy"), "p", nil, time.Hour)
+	require.Error(t, err)
+
+	_, _, err = admin.Issue(crt, key, admin.RoleController, "admin", nil, time.Hour)
+	require.Error(t, err)
+
+	for _, name := range []string{"", "..", "../g", "a/b", `a\b`} {
+		_, _, err = admin.Issue(crt, key, admin.RoleGateway, name, nil, time.Hour)
+		require.Error(t, err, name)
+	}
+
+	// not ca
+	gcrt, gkey, err := admin.Issue(crt, key, admin.RoleGateway, "g", nil, time.Hour)
+	require.NoError(t, err)
+	_, _, err = admin.Issue(gcrt, gkey, admin.RoleForward, "f", nil, time.Hour)
+	require.Error(t, err)
+}
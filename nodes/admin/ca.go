@@ -0,0 +1,274 @@
+package admin
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Role role of node certificate, recorded in OrganizationalUnit
+type Role string
+
+const (
+	RoleController Role = "controller"
+	RoleGateway    Role = "gateway"
+	RoleForward    Role = "forward"
+)
+
+func (r Role) Valid() error {
+	switch r {
+	case RoleController, RoleGateway, RoleForward:
+		return nil
+	default:
+		return errors.Errorf("invalid role %q", string(r))
+	}
+}
+
+// ValidName check node name, it's also the file name of issued certificate,
+// so only letters, digits, '.', '-' and '_' are allowed
+func ValidName(name string) error {
+	if name == "" || name == "." || name == ".." || len(name) > 64 {
+		return errors.Errorf("invalid name %q", name)
+	}
+	for _, r := range name {
+		switch {
+		case 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z', '0' <= r && r <= '9':
+		case r == '.' || r == '-' || r == '_':
+		default:
+			return errors.Errorf("invalid name %q", name)
+		}
+	}
+	return nil
+}
+
+// NewCA create self-signed ca, return pem encoded certificate and key
+func NewCA(name string, validity time.Duration) (cert, key []byte, err error) {
+	tmpl, err := template(name, validity)
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl.IsCA = true
+	tmpl.BasicConstraintsValid = true
+	tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	return encode(tmpl, tmpl, &priv.PublicKey, priv, priv)
+}
+
+// Issue issue certificate signed by ca, name is the common name, that is the
+// registered node name. hosts are ip or dns name of controller.
+func Issue(caCert, caKey []byte, role Role, name string, hosts []string, validity time.Duration) (cert, key []byte, err error) {
+	if err := role.Valid(); err != nil {
+		return nil, nil, err
+	} else if err := ValidName(name); err != nil {
+		return nil, nil, err
+	}
+	ca, err := tls.X509KeyPair(caCert, caKey)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	parent, err := x509.ParseCertificate(ca.Certificate[0])
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	} else if !parent.IsCA {
+		return nil, nil, errors.New("not ca certificate")
+	}
+
+	tmpl, err := template(name, validity)
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl.Subject.OrganizationalUnit = []string{string(role)}
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	if role == RoleController {
+		if len(hosts) == 0 {
+			return nil, nil, errors.New("controller require hosts")
+		}
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+		for _, h := range hosts {
+			if ip := net.ParseIP(h); ip != nil {
+				tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+			} else {
+				tmpl.DNSNames = append(tmpl.DNSNames, h)
+			}
+		}
+	} else {
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	}
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	return encode(tmpl, parent, &priv.PublicKey, ca.PrivateKey, priv)
+}
+
+func template(name string, validity time.Duration) (*x509.Certificate, error) {
+	sn, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: sn,
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(validity),
+	}, nil
+}
+
+func encode(tmpl, parent *x509.Certificate, pub, signer any, priv *ecdsa.PrivateKey) (cert, key []byte, err error) {
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, signer)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	kder, err := x509.MarshalECPrivateKey(priv)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	cert = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	key = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: kder})
+	return cert, key, nil
+}
+
+// ServerTLS mutual tls config of controller, verify node certificate signed
+// by ca, the client without certificate only can fetch gateways. revokedFile
+// is deny list written by Revoke, reloaded on each handshake, so revocation
+// take effect for new connections without restart, empty is disabled.
+func ServerTLS(caFile, certFile, keyFile, revokedFile string) (*tls.Config, error) {
+	pool, err := loadCA(caFile)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	var cfg = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		MinVersion:   tls.VersionTLS13,
+	}
+	if revokedFile != "" {
+		if _, err := revoked(revokedFile); err != nil {
+			return nil, err
+		}
+		cfg.VerifyConnection = func(state tls.ConnectionState) error {
+			if len(state.PeerCertificates) == 0 {
+				return nil
+			}
+			cert := state.PeerCertificates[0]
+			serials, err := revoked(revokedFile)
+			if err != nil {
+				return err
+			} else if slices.Contains(serials, cert.SerialNumber.Text(16)) {
+				return errors.Errorf("certificate %s revoked", cert.Subject.CommonName)
+			}
+			return nil
+		}
+	}
+	return cfg, nil
+}
+
+// Revoke append serial number of the certificate to revokedFile
+func Revoke(revokedFile string, cert []byte) error {
+	b, _ := pem.Decode(cert)
+	if b == nil {
+		return errors.New("invalid certificate")
+	}
+	c, err := x509.ParseCertificate(b.Bytes)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	fh, err := os.OpenFile(revokedFile, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer fh.Close()
+	_, err = fmt.Fprintf(fh, "%s %s\n", c.SerialNumber.Text(16), c.Subject.CommonName)
+	return errors.WithStack(err)
+}
+
+// revoked load revoked serial numbers, each line is hex serial number and
+// optional comment
+func revoked(revokedFile string) (serials []string, err error) {
+	data, err := os.ReadFile(revokedFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) > 0 {
+			serials = append(serials, strings.ToLower(fields[0]))
+		}
+	}
+	return serials, nil
+}
+
+// ClientTLS tls config of gateway, forward or client, verify controller
+// certificate signed by ca, certFile and keyFile is empty for client.
+func ClientTLS(caFile, certFile, keyFile string) (*tls.Config, error) {
+	pool, err := loadCA(caFile)
+	if err != nil {
+		return nil, err
+	}
+	var cfg = &tls.Config{
+		RootCAs:    pool,
+		MinVersion: tls.VersionTLS13,
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+func loadCA(caFile string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.Errorf("invalid ca file %s", caFile)
+	}
+	return pool, nil
+}
+
+// peer get name and role of verified peer certificate
+func peer(state tls.ConnectionState) (name string, role Role, err error) {
+	if len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return "", "", errors.New("require certificate")
+	}
+	cert := state.VerifiedChains[0][0]
+	if len(cert.Subject.OrganizationalUnit) != 1 {
+		return "", "", errors.Errorf("certificate %s without role", cert.Subject.CommonName)
+	}
+	role = Role(cert.Subject.OrganizationalUnit[0])
+	if err := role.Valid(); err != nil {
+		return "", "", err
+	}
+	return cert.Subject.CommonName, role, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lysShub/anton-planet-accelerator/nodes/admin"
+	"github.com/pkg/errors"
+)
+
+// ca tool issue certificates of control channel mutual tls
+//
+//	go run . -init -dir certs
+//	go run . -dir certs -role controller -name admin -hosts 1.2.3.4,admin.example.com
+//	go run . -dir certs -role gateway -name gateway-beijing
+//	go run . -dir certs -role forward -name forward-moscow
+//	go run . -dir certs -revoke gateway-beijing
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "ca:", err.Error())
+		}
+		os.Exit(1)
+	}
+}
+
+func run(args []string) error {
+	var (
+		fs     = flag.NewFlagSet("ca", flag.ContinueOnError)
+		dir    = fs.String("dir", ".", "directory of ca and issued certificates")
+		create = fs.Bool("init", false, "create ca certificate ca.crt and key ca.key")
+		role   = fs.String("role", "", "role of issued certificate, controller, gateway or forward")
+		name   = fs.String("name", "", "common name of certificate, is the registered node name")
+		hosts  = fs.String("hosts", "", "ip or dns name of controller, comma separated")
+		days   = fs.Int("days", 365, "validity days")
+		revoke = fs.String("revoke", "", "revoke issued certificate of the name, append to revoked.txt")
+	)
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if *days <= 0 {
+		return errors.Errorf("invalid days %d", *days)
+	}
+	validity := time.Duration(*days) * time.Hour * 24
+	caCert, caKey := filepath.Join(*dir, "ca.crt"), filepath.Join(*dir, "ca.key")
+
+	if *create {
+		if _, err := os.Stat(caKey); err == nil {
+			return errors.Errorf("%s existed", caKey)
+		}
+		if *name == "" {
+			*name = "anton-planet-accelerator ca"
+		}
+		cert, key, err := admin.NewCA(*name, validity)
+		if err != nil {
+			return err
+		}
+		return write(caCert, cert, caKey, key)
+	}
+
+	if *revoke != "" {
+		if err := admin.ValidName(*revoke); err != nil {
+			return err
+		}
+		cert, err := os.ReadFile(filepath.Join(*dir, *revoke+".crt"))
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		revoked := filepath.Join(*dir, "revoked.txt")
+		if err := admin.Revoke(revoked, cert); err != nil {
+			return err
+		}
+		fmt.Println("revoke", *revoke, "to", revoked)
+		return nil
+	}
+
+	// name is also the file name of issued certificate
+	if err := admin.ValidName(*name); err != nil {
+		return err
+	} else if *name == "ca" {
+		return errors.New("name ca is reserved")
+	}
+	ca, err := os.ReadFile(caCert)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	key, err := os.ReadFile(caKey)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	var hs []string
+	for _, h := range strings.Split(*hosts, ",") {
+		if h = strings.TrimSpace(h); h != "" {
+			hs = append(hs, h)
+		}
+	}
+
+	cert, priv, err := admin.Issue(ca, key, admin.Role(*role), *name, hs, validity)
+	if err != nil {
+		return err
+	}
+	return write(filepath.Join(*dir, *name+".crt"), cert, filepath.Join(*dir, *name+".key"), priv)
+}
+
+func write(certPath string, cert []byte, keyPath string, key []byte) error {
+	if err := os.MkdirAll(filepath.Dir(certPath), 0o755); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := os.WriteFile(certPath, cert, 0o644); err != nil {
+		return errors.WithStack(err)
+	}
+	if err := os.WriteFile(keyPath, key, 0o600); err != nil {
+		return errors.WithStack(err)
+	}
+	fmt.Println("write", certPath, keyPath)
+	return nil
+}
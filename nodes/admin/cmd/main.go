@@ -0,0 +1,93 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/lysShub/anton-planet-accelerator/nodes/admin"
+	"github.com/lysShub/anton-planet-accelerator/nodes/gateway"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/config"
+	"github.com/pkg/errors"
+)
+
+type Config struct {
+	Listen   string `json:"listen" yaml:"listen" toml:"listen" flag:"listen" env:"LISTEN" usage:"listen address"`
+	LogPath  string `json:"log_path" yaml:"log_path" toml:"log_path" flag:"log" env:"LOG_PATH" usage:"log file path, empty is stdout"`
+	CA       string `json:"ca" yaml:"ca" toml:"ca" flag:"ca" env:"CA" usage:"ca certificate file, verify certificate of gateways and forwards"`
+	Cert     string `json:"cert" yaml:"cert" toml:"cert" flag:"cert" env:"CERT" usage:"controller certificate file issued by ca"`
+	Key      string `json:"key" yaml:"key" toml:"key" flag:"key" env:"KEY" usage:"private key file of controller certificate"`
+	Revoked  string `json:"revoked" yaml:"revoked" toml:"revoked" flag:"revoked" env:"REVOKED" usage:"revoked certificate list written by ca -revoke, empty is disabled"`
+	AuthFile string `json:"auth_file" yaml:"auth_file" toml:"auth_file" flag:"auth-file" env:"AUTH_FILE" usage:"token file of client authentication"`
+	AuthURL  string `json:"auth_url" yaml:"auth_url" toml:"auth_url" flag:"auth-url" env:"AUTH_URL" usage:"http url of client authentication"`
+}
+
+// go run . -config admin.yaml
+func main() {
+	if err := run(); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "admin:", err.Error())
+		}
+		os.Exit(1)
+	}
+}
+
+func run() error {
+	var c = Config{
+		Listen: ":19987",
+	}
+	if err := config.Parse("admin", "ADMIN_", &c, os.Args[1:]); err != nil {
+		return err
+	}
+	cfg, err := c.build()
+	if err != nil {
+		return err
+	}
+
+	ctr, err := admin.New(c.Listen, cfg)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return ctr.Serve(ctx)
+}
+
+// build validate and build controller config
+func (c *Config) build() (*admin.Config, error) {
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		return nil, errors.Errorf("invalid listen address %q", c.Listen)
+	}
+	if c.CA == "" || c.Cert == "" || c.Key == "" {
+		return nil, errors.New("require ca, cert and key")
+	}
+	tlsCfg, err := admin.ServerTLS(c.CA, c.Cert, c.Key, c.Revoked)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg = &admin.Config{
+		LogPath: c.LogPath,
+		TLS:     tlsCfg,
+	}
+	switch {
+	case c.AuthFile != "" && c.AuthURL != "":
+		return nil, errors.New("auth file and auth url are mutually exclusive")
+	case c.AuthFile != "":
+		auth, err := gateway.FileAuth(c.AuthFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Authenticator = auth
+	case c.AuthURL != "":
+		cfg.Authenticator = gateway.HTTPAuth(c.AuthURL)
+	}
+	return cfg, nil
+}
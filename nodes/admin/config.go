@@ -0,0 +1,62 @@
+package admin
+
+import (
+	"crypto/tls"
+	"log/slog"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+type Config struct {
+	LogPath string
+	logger  *slog.Logger
+	logFile *os.File // nil if stdout
+
+	// TLS mutual tls config, gateways and forwards register with
+	// certificate issued by ca, refer ServerTLS.
+	TLS *tls.Config
+
+	// Authenticator authenticate client token when fetching gateways, nil
+	// will disable authentication.
+	Authenticator Authenticator
+}
+
+// Authenticator authenticate client token, same as gateway
+type Authenticator interface {
+	Authenticate(token string) (user string, err error)
+}
+
+func (c *Config) init() error {
+	if c.TLS == nil {
+		return errors.New("require tls")
+	} else if c.TLS.ClientCAs == nil {
+		return errors.New("require client ca")
+	}
+
+	var fh *os.File
+	if c.LogPath == "" {
+		fh = os.Stdout
+	} else {
+		var err error
+		fh, err = os.OpenFile(c.LogPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o666)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		c.logFile = fh
+	}
+	c.logger = slog.New(slog.NewJSONHandler(fh, nil))
+	return nil
+}
+
+// closeLog flush and close log file, stdout is kept
+func (c *Config) closeLog() error {
+	if c.logFile == nil {
+		return nil
+	}
+	if err := c.logFile.Sync(); err != nil {
+		c.logFile.Close()
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(c.logFile.Close())
+}
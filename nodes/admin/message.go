@@ -0,0 +1,97 @@
+package admin
+
+import (
+	"encoding/gob"
+	"net/netip"
+	"reflect"
+
+	"github.com/lysShub/anton-planet-accelerator/bvvd"
+)
+
+type Message interface {
+	Kind() Kind
+}
+
+//go:generate stringer -output message_gen.go -type=Kind
+type Kind uint8
+
+const (
+	_ Kind = iota
+	KindClientNew
+	KindGatewayNew
+	KindForwardNew
+	KindProxyAddForward
+	KindForwardStop
+)
+
+func init() {
+	register(ClientNew{})
+	register(GatewayNew{})
+	register(ForwardNew{})
+	register(ProxyAddForward{})
+	register(ForwardStop{})
+}
+func register(v any) {
+	gob.RegisterName(reflect.TypeOf(v).Name(), v)
+}
+
+// result reply of request message
+type result interface {
+	result() (ok bool, msg string)
+}
+
+// 客户端获取Gateway列表
+type ClientNew struct {
+	Token string // session token, 验证由controller的Authenticator
+
+	Ok       bool
+	Msg      string
+	Gateways []netip.AddrPort
+}
+
+func (ClientNew) Kind() Kind               { return KindClientNew }
+func (c ClientNew) result() (bool, string) { return c.Ok, c.Msg }
+
+// 新增Gateway请求, 通过tls双向认证确保Gateway的合法性
+type GatewayNew struct {
+	Name     string         // common name of certificate, replied by controller
+	Addr     netip.AddrPort // unspecified ip will use remote ip of connection
+	Location bvvd.Location  // optional
+
+	Ok  bool
+	Msg string
+}
+
+func (GatewayNew) Kind() Kind               { return KindGatewayNew }
+func (g GatewayNew) result() (bool, string) { return g.Ok, g.Msg }
+
+// 新增Forward请求
+type ForwardNew struct {
+	Name     string // common name of certificate, replied by controller
+	Addr     netip.AddrPort
+	Location bvvd.Location
+
+	Ok  bool
+	Msg string
+}
+
+func (ForwardNew) Kind() Kind               { return KindForwardNew }
+func (f ForwardNew) result() (bool, string) { return f.Ok, f.Msg }
+
+// 为Gateway新增Forward
+type ProxyAddForward struct {
+	Name     string
+	Addr     netip.AddrPort
+	Location bvvd.Location
+}
+
+func (ProxyAddForward) Kind() Kind { return KindProxyAddForward }
+
+// Forward 请求停止向其转发 (通常是端口资源耗尽), 或者Forward断开连接, controller
+// 推送给所有Gateway
+type ForwardStop struct {
+	Name string
+	Addr netip.AddrPort
+}
+
+func (ForwardStop) Kind() Kind { return KindForwardStop }
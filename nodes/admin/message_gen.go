@@ -11,14 +11,13 @@ func _() {
 	_ = x[KindClientNew-1]
 	_ = x[KindGatewayNew-2]
 	_ = x[KindForwardNew-3]
-	_ = x[KindClientRoute-4]
-	_ = x[KindProxyAddForward-5]
-	_ = x[KindForwardStop-6]
+	_ = x[KindProxyAddForward-4]
+	_ = x[KindForwardStop-5]
 }
 
-const _Kind_name = "KindClientNewKindGatewayNewKindForwardNewKindClientRouteKindProxyAddForwardKindForwardStop"
+const _Kind_name = "KindClientNewKindGatewayNewKindForwardNewKindProxyAddForwardKindForwardStop"
 
-var _Kind_index = [...]uint8{0, 13, 27, 41, 56, 75, 90}
+var _Kind_index = [...]uint8{0, 13, 27, 41, 60, 75}
 
 func (i Kind) String() string {
 	i -= 1
@@ -0,0 +1,105 @@
+package admin
+
+import (
+	"crypto/tls"
+	"encoding/gob"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Registry registration of gateway or forward
+type Registry struct {
+	Addr string      // controller address
+	TLS  *tls.Config // with certificate issued by ca, refer ClientTLS
+}
+
+// Session registered session of node, closed means unregister
+type Session struct {
+	conn net.Conn
+	enc  *gob.Encoder
+	dec  *gob.Decoder
+}
+
+// Register register node to controller, msg is GatewayNew or ForwardNew,
+// return the reply of controller.
+func Register(addr string, config *tls.Config, msg Message) (*Session, Message, error) {
+	switch msg.(type) {
+	case GatewayNew, ForwardNew:
+	default:
+		return nil, nil, errors.Errorf("invalid register message kind %s", msg.Kind())
+	}
+
+	if config == nil || len(config.Certificates) == 0 {
+		return nil, nil, errors.New("register require certificate")
+	}
+	s, reply, err := request(addr, config, msg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return s, reply, nil
+}
+
+func (s *Session) Recv() (Message, error) {
+	var msg Message
+	if err := s.dec.Decode(&msg); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return msg, nil
+}
+
+func (s *Session) Send(msg Message) error {
+	return send(s.conn, s.enc, msg)
+}
+
+func (s *Session) Close() error { return errors.WithStack(s.conn.Close()) }
+
+// Gateways fetch gateways from controller, token is session token of client,
+// config verify controller certificate, refer ClientTLS.
+func Gateways(addr string, config *tls.Config, token string) ([]netip.AddrPort, error) {
+	s, reply, err := request(addr, config, ClientNew{Token: token})
+	if err != nil {
+		return nil, err
+	}
+	defer s.Close()
+
+	gaddrs := reply.(ClientNew).Gateways
+	if len(gaddrs) == 0 {
+		return nil, errors.New("controller without gateway")
+	}
+	return gaddrs, nil
+}
+
+func request(addr string, config *tls.Config, msg Message) (*Session, Message, error) {
+	if config == nil {
+		return nil, nil, errors.New("require tls config")
+	}
+	conn, err := tls.DialWithDialer(&net.Dialer{Timeout: timeout}, "tcp", addr, config)
+	if err != nil {
+		return nil, nil, errors.WithStack(err)
+	}
+	var s = &Session{conn: conn, enc: gob.NewEncoder(conn), dec: gob.NewDecoder(conn)}
+
+	if err := s.Send(msg); err != nil {
+		s.Close()
+		return nil, nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	reply, err := s.Recv()
+	if err != nil {
+		s.Close()
+		return nil, nil, err
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	if reply.Kind() != msg.Kind() {
+		s.Close()
+		return nil, nil, errors.Errorf("expect reply %s, not %s", msg.Kind(), reply.Kind())
+	} else if ok, m := reply.(result).result(); !ok {
+		s.Close()
+		return nil, nil, errors.Errorf("controller: %s", m)
+	}
+	return s, reply, nil
+}
@@ -16,20 +16,12 @@ import (
 )
 
 func (c *Client) boardcastPingForward(fn func(message) bool, timeout time.Duration) (err error) {
-	var pkt = packet.Make(msg.MinSize)
-
 	var m = msg.Fields{MsgID: rand.Uint32()}
 	m.Kind = bvvd.PingForward
-	if err := m.Encode(pkt); err != nil {
+	if err := c.boardcast(&m, c.config.Gateways); err != nil {
 		return err
 	}
 
-	for _, gaddr := range c.config.Gateways {
-		if err := c.conn.WriteToAddrPort(pkt, gaddr); err != nil {
-			return c.close(err)
-		}
-	}
-
 	_, ok := c.msgbuff.PopDeadline(func(msg message) (pop bool) {
 		if msg.msg.MsgID() == m.MsgID {
 			return fn(msg)
@@ -43,22 +35,14 @@ func (c *Client) boardcastPingForward(fn func(message) bool, timeout time.Durati
 }
 
 func (c *Client) boardcastPingServer(saddr netip.Addr, fn func(message) bool, timeout time.Duration) (err error) {
-	var pkt = packet.Make(msg.MinSize)
-
 	var m = msg.Fields{MsgID: rand.Uint32()}
 	m.Kind = bvvd.PingServer
 	m.Forward = netip.AddrPortFrom(netip.IPv4Unspecified(), 0)
 	m.Server = saddr
-	if err := m.Encode(pkt); err != nil {
+	if err := c.boardcast(&m, c.config.Gateways); err != nil {
 		return err
 	}
 
-	for _, gaddr := range c.config.Gateways {
-		if err := c.conn.WriteToAddrPort(pkt, gaddr); err != nil {
-			return c.close(err)
-		}
-	}
-
 	_, ok := c.msgbuff.PopDeadline(func(msg message) (pop bool) {
 		if msg.msg.MsgID() == m.MsgID {
 			return fn(msg)
@@ -70,3 +54,42 @@ func (c *Client) boardcastPingServer(saddr netip.Addr, fn func(message) bool, ti
 	}
 	return nil
 }
+
+// pingForward ping forward through gateways, fn called by every reply in order
+// of arrival until it return done
+func (c *Client) pingForward(gaddrs []netip.AddrPort, faddr netip.AddrPort, fn func(message) (done bool), timeout time.Duration) (err error) {
+	var m = msg.Fields{MsgID: rand.Uint32()}
+	m.Kind = bvvd.PingForward
+	m.Forward = faddr
+	if err := c.boardcast(&m, gaddrs); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(timeout)
+	for range gaddrs {
+		msg, ok := c.msgbuff.PopDeadline(func(msg message) (pop bool) {
+			return msg.msg.MsgID() == m.MsgID
+		}, deadline)
+		if !ok {
+			return errorx.WrapTemp(errors.Errorf("timeout"))
+		} else if fn(msg) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// boardcast send m to gateways, encode packet for every gateway, because
+// packet is signed or sealed in place by writeTo
+func (c *Client) boardcast(m *msg.Fields, gaddrs []netip.AddrPort) error {
+	for _, gaddr := range gaddrs {
+		var pkt = packet.Make(msg.MinSize)
+		if err := m.Encode(pkt); err != nil {
+			return err
+		}
+		if err := c.writeTo(pkt, gaddr); err != nil {
+			return c.close(err)
+		}
+	}
+	return nil
+}
@@ -4,12 +4,15 @@
 package client
 
 import (
+	"context"
+	"encoding/binary"
 	"fmt"
 	"log/slog"
 	"math"
 	"math/rand"
 	"net/netip"
 	"slices"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
@@ -17,13 +20,15 @@ import (
 	"github.com/lysShub/anton-planet-accelerator/bvvd"
 	"github.com/lysShub/anton-planet-accelerator/conn"
 	"github.com/lysShub/anton-planet-accelerator/nodes"
+	"github.com/lysShub/anton-planet-accelerator/nodes/admin"
 	"github.com/lysShub/anton-planet-accelerator/nodes/client/game"
 	"github.com/lysShub/anton-planet-accelerator/nodes/client/inject"
-	"github.com/lysShub/anton-planet-accelerator/nodes/internal"
 	"github.com/lysShub/anton-planet-accelerator/nodes/internal/checksum"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/fec"
 	"github.com/lysShub/anton-planet-accelerator/nodes/internal/heap"
 	"github.com/lysShub/anton-planet-accelerator/nodes/internal/msg"
 	"github.com/lysShub/anton-planet-accelerator/nodes/internal/stats"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/tunnel"
 	"github.com/lysShub/fatun"
 	"github.com/lysShub/netkit/debug"
 	"github.com/lysShub/netkit/errorx"
@@ -41,16 +46,27 @@ type Client struct {
 	game   game.Game
 	inject inject.Inject
 
-	conn       conn.Conn
+	conn       *tunnel.Conn
+	transports []string  // try in order, multiplexed by mux if more than one
+	mux        *conn.Mux // nil if single transport
 	uplinkId   atomic.Uint32
 	downlinkPL *stats.PLStats
+	uplinkPL   atomic.Uint64 // bits of uplink pack loss reported by trunk gateway
+	plID       uint32        // msg id of uplink pack loss query
+	encoders   *fec.Encoders // uplink fec
+	decoders   *fec.Decoders // downlink fec
 
 	route   *route
 	trunk   *trunkRouteRecorder
 	msgbuff *heap.Heap[message]
 
+	sessionsMu sync.RWMutex
+	sessions   map[netip.AddrPort]*session // gateway sessions
+
 	pcap *pcap.Pcap
 
+	done     chan struct{}  // closed when client close
+	services sync.WaitGroup // background services, wait by Close
 	closeErr errorx.CloseErr
 }
 
@@ -60,15 +76,37 @@ type message struct {
 	time  time.Time
 }
 
-func New(config *Config) (*Client, error) {
+// New create and start client, client will be closed when ctx done
+func New(ctx context.Context, config *Config) (*Client, error) {
+	if err := config.init(); err != nil {
+		return nil, err
+	}
 	var c = &Client{
-		config:     config.init(),
+		config:     config,
 		downlinkPL: stats.NewPLStats(bvvd.MaxID),
+		plID:       rand.Uint32() | 1,
+		encoders:   fec.NewEncoders(),
+		decoders:   fec.NewDecoders(),
 		route:      newRoute(config.FixRoute),
 		msgbuff:    heap.NewHeap[message](16),
+		sessions:   map[netip.AddrPort]*session{},
+		done:       make(chan struct{}),
 	}
 	var err error
 
+	if len(config.Gateways) == 0 {
+		tls, err := admin.ClientTLS(config.ControllerCA, "", "")
+		if err != nil {
+			return nil, c.close(err)
+		}
+		if config.Gateways, err = admin.Gateways(config.Controller, tls, config.Token); err != nil {
+			return nil, c.close(err)
+		} else if config.Redundant > len(config.Gateways) {
+			return nil, c.close(errors.Errorf("redundant %d more than gateways %d", config.Redundant, len(config.Gateways)))
+		}
+		c.config.logger.Info("fetch gateways", slog.String("controller", config.Controller), slog.Int("gateways", len(config.Gateways)))
+	}
+
 	if c.game, err = game.New(config.Name); err != nil {
 		return nil, c.close(err)
 	}
@@ -77,10 +115,11 @@ func New(config *Config) (*Client, error) {
 		return nil, c.close(err)
 	}
 
-	c.conn, err = conn.Bind(nodes.GatewayNetwork, "")
+	raw, err := c.bind()
 	if err != nil {
 		return nil, c.close(err)
 	}
+	c.conn = tunnel.New(raw, true)
 	c.laddr = c.conn.LocalAddr()
 
 	if config.PcapPath != "" {
@@ -90,7 +129,44 @@ func New(config *Config) (*Client, error) {
 		}
 	}
 
-	return c, c.start()
+	if err := c.start(); err != nil {
+		err = c.close(err)
+		c.services.Wait()
+		return nil, err
+	}
+	context.AfterFunc(ctx, func() { c.close(nil) })
+	return c, nil
+}
+
+// bind conn of transports, multiplex them if auto transport
+func (c *Client) bind() (conn.Conn, error) {
+	c.transports = []string{c.config.Transport}
+	if c.config.Transport == nodes.Auto {
+		c.transports = []string{nodes.UDP, nodes.TCP}
+	}
+
+	var conns []conn.Conn
+	for _, transport := range c.transports {
+		network, err := nodes.ClientNetwork(transport)
+		if err == nil {
+			var raw conn.Conn
+			if raw, err = conn.Bind(network, ""); err == nil {
+				conns = append(conns, raw)
+				continue
+			}
+		}
+
+		for _, e := range conns {
+			e.Close()
+		}
+		return nil, errors.WithMessage(err, transport)
+	}
+
+	if len(conns) == 1 {
+		return conns[0], nil
+	}
+	c.mux = conn.NewMux(conns...)
+	return c.mux, nil
 }
 
 func (c *Client) close(cause error) error {
@@ -103,6 +179,7 @@ func (c *Client) close(cause error) error {
 	}
 	return c.closeErr.Close(func() (errs []error) {
 		errs = append(errs, cause)
+		close(c.done)
 		if c.conn != nil {
 			errs = append(errs, c.conn.Close())
 		}
@@ -112,34 +189,70 @@ func (c *Client) close(cause error) error {
 		if c.inject != nil {
 			errs = append(errs, c.inject.Close())
 		}
+		errs = append(errs, c.downlinkPL.Close())
+		if c.pcap != nil {
+			errs = append(errs, c.pcap.Close())
+		}
+		errs = append(errs, c.config.closeLog())
 		return errs
 	})
 }
 
+// Close stop client and wait background services exit
+func (c *Client) Close() error {
+	err := c.close(nil)
+	c.services.Wait()
+	return err
+}
+
+// service start background service, Close wait it exit
+func (c *Client) service(fn func() error) {
+	c.services.Add(1)
+	go func() {
+		defer c.services.Done()
+		fn()
+	}()
+}
+
 func (c *Client) start() error {
-	go c.uplinkService()
-	go c.downlinkServic()
+	c.service(c.uplinkService)
+	c.service(c.downlinkServic)
 
-	// todo: use ping server (缓存各个游戏各节点server ip)
-	var start = time.Now()
-	var gaddr, faddr netip.AddrPort
-	if err := c.boardcastPingForward(func(m message) bool {
-		gaddr = m.gaddr
-		faddr = m.msg.Bvvd().Forward()
-		return true
-	}, time.Second*3); err != nil {
+	var (
+		start        = time.Now()
+		gaddr, faddr netip.AddrPort
+		transport    string
+		err          error
+	)
+	for i := range c.transports {
+		transport = c.transports[i]
+		if c.mux != nil {
+			c.mux.SetDefault(i)
+		}
+		if gaddr, faddr, err = c.connect(); err == nil {
+			break
+		} else if i+1 < len(c.transports) {
+			c.config.logger.Warn("fall back transport", slog.String("transport", transport), slog.String("error", err.Error()))
+		}
+	}
+	if err != nil {
 		return err
 	}
+	c.service(c.sessionService)
+
+	gaddrs := c.redundant(gaddr, faddr)
 
 	c.trunk = newTrunkRouteRecorder(time.Second*3, gaddr, faddr)
-	c.route.Init(c, gaddr, faddr)
+	c.service(c.plService)
+	c.route.Init(c, gaddrs, faddr)
 	c.game.Start()
 	c.config.logger.Info("start",
 		slog.String("addr", c.laddr.String()),
-		slog.String("network", nodes.GatewayNetwork),
+		slog.String("transport", transport),
 		slog.String("mode", "fix"),
 		slog.String("gateway", gaddr.String()),
 		slog.String("forward", faddr.String()),
+		slog.Int("redundant", len(gaddrs)),
 		slog.String("location", c.config.Location.String()),
 		slog.String("rtt", time.Since(start).String()),
 		slog.Bool("debug", debug.Debug()),
@@ -148,24 +261,71 @@ func (c *Client) start() error {
 	return nil
 }
 
-func (c *Client) RouteProbe(saddr netip.Addr) (gaddr, faddr netip.AddrPort, err error) {
+// connect handshake with gateways and probe the fastest forward by current
+// default transport
+func (c *Client) connect() (gaddr, faddr netip.AddrPort, err error) {
+	if err := c.handshakes(); err != nil {
+		return netip.AddrPort{}, netip.AddrPort{}, err
+	}
+
+	// todo: use ping server (缓存各个游戏各节点server ip)
+	err = c.boardcastPingForward(func(m message) bool {
+		gaddr = m.gaddr
+		faddr = m.msg.Bvvd().Forward()
+		return true
+	}, time.Second*3)
+	return gaddr, faddr, err
+}
+
+func (c *Client) RouteProbe(saddr netip.Addr) (gaddrs []netip.AddrPort, faddr netip.AddrPort, err error) {
 	start := time.Now()
 
+	var gaddr netip.AddrPort
 	if err := c.boardcastPingServer(saddr, func(msg message) (pop bool) {
 		gaddr = msg.gaddr
 		faddr = msg.msg.Bvvd().Forward()
 		return true
 	}, time.Second*3); err != nil {
-		return netip.AddrPort{}, netip.AddrPort{}, err
+		return nil, netip.AddrPort{}, err
 	}
+	gaddrs = c.redundant(gaddr, faddr)
 
 	c.config.logger.Info("route probe server",
 		slog.String("gateway", gaddr.String()),
 		slog.String("forward", faddr.String()), // todo: 屏蔽
 		slog.String("server", saddr.String()),
+		slog.Int("redundant", len(gaddrs)),
 		slog.Duration("rtt", time.Since(start)),
 	)
-	return gaddr, faddr, nil
+	return gaddrs, faddr, nil
+}
+
+// redundant select the best gateways that reach forward, the first is gaddr,
+// return gaddr only if redundant mode disabled
+func (c *Client) redundant(gaddr, faddr netip.AddrPort) (gaddrs []netip.AddrPort) {
+	gaddrs = append(gaddrs, gaddr)
+	if c.config.Redundant <= 1 {
+		return gaddrs
+	}
+
+	var others []netip.AddrPort
+	for _, e := range c.config.Gateways {
+		if e != gaddr {
+			others = append(others, e)
+		}
+	}
+
+	if err := c.pingForward(others, faddr, func(m message) (done bool) {
+		gaddrs = append(gaddrs, m.gaddr)
+		return len(gaddrs) >= c.config.Redundant
+	}, time.Second); err != nil {
+		c.config.logger.Warn("redundant gateways not enough",
+			slog.String("forward", faddr.String()),
+			slog.Int("redundant", len(gaddrs)),
+			slog.String("error", err.Error()),
+		)
+	}
+	return gaddrs
 }
 
 func (c *Client) MatchForward(loc bvvd.Location) (gaddr, faddr netip.AddrPort, err error) {
@@ -188,7 +348,7 @@ func (c *Client) MatchForward(loc bvvd.Location) (gaddr, faddr netip.AddrPort, e
 	}
 
 	for i, msg := range infos {
-		coord, err := internal.IPCoord(msg.msg.Bvvd().Forward().Addr())
+		coord, err := c.config.GeoProvider.Coord(msg.msg.Bvvd().Forward().Addr())
 		if err != nil {
 			return netip.AddrPort{}, netip.AddrPort{}, err
 		}
@@ -228,25 +388,24 @@ func (c *Client) NetworkStats(timeout time.Duration) (s *NetworkStates, err erro
 		start = time.Now()
 		kinds = []bvvd.Kind{
 			bvvd.PingGateway, bvvd.PingForward,
-			bvvd.PackLossClientUplink,
 			bvvd.PackLossGatewayUplink, bvvd.PackLossGatewayDownlink,
 		}
 	)
 	gaddr, faddr, _ := c.trunk.Trunk()
 
-	var pkt = packet.Make(msg.MinSize)
-
 	var m = msg.Fields{MsgID: rand.Uint32()}
-	m.Kind = kinds[0]
-	m.Forward = faddr
-	if err := m.Encode(pkt); err != nil {
-		return nil, err
+	for m.MsgID == 0 || m.MsgID == c.plID {
+		m.MsgID = rand.Uint32()
 	}
-	hdr := bvvd.Bvvd(pkt.Bytes())
-
+	m.Forward = faddr
 	for _, kind := range kinds {
-		hdr.SetKind(kind)
-		if err := c.conn.WriteToAddrPort(pkt, gaddr); err != nil {
+		// packet is signed or sealed in place, encode for every kind
+		var pkt = packet.Make(msg.MinSize)
+		m.Kind = kind
+		if err := m.Encode(pkt); err != nil {
+			return nil, err
+		}
+		if err := c.writeTo(pkt, gaddr); err != nil {
 			return nil, c.close(err)
 		}
 	}
@@ -265,10 +424,6 @@ func (c *Client) NetworkStats(timeout time.Duration) (s *NetworkStates, err erro
 			s.PingGateway = time.Since(start)
 		case bvvd.PingForward:
 			s.PingForward = time.Since(start)
-		case bvvd.PackLossClientUplink:
-			if err := msg.msg.Payload(&s.PackLossClientUplink); err != nil {
-				return nil, err
-			}
 		case bvvd.PackLossGatewayUplink:
 			if err := msg.msg.Payload(&s.PackLossGatewayUplink); err != nil {
 				return nil, err
@@ -281,6 +436,9 @@ func (c *Client) NetworkStats(timeout time.Duration) (s *NetworkStates, err erro
 		}
 	}
 
+	// gateway reset uplink pack loss window when queried, so not query it
+	// again, report the latest reply of plService
+	s.PackLossClientUplink = c.reportedPL()
 	s.PackLossClientDownlink = stats.PL(c.downlinkPL.PL(nodes.PLScale))
 	if s.PackLossClientDownlink == 0 {
 		s.PackLossClientDownlink = math.SmallestNonzeroFloat64
@@ -295,8 +453,10 @@ func (c *Client) uplinkService() (_ error) {
 			Kind:   bvvd.Data,
 			Client: netip.AddrPortFrom(netip.IPv4Unspecified(), 0),
 		}
-		head  = 64
-		gaddr netip.AddrPort
+		head   = 64
+		gaddrs []netip.AddrPort
+		seq    uint32 // redundant seq, shared by all flows, so forward see gaps of a flow
+		dup    = packet.Make(head, 0, c.config.MaxRecvBuff)
 	)
 
 	for {
@@ -324,7 +484,7 @@ func (c *Client) uplinkService() (_ error) {
 			continue // PackLossClientUplink
 		}
 
-		gaddr, hdr.Forward, err = c.route.Match(hdr.Server, info.PlayData)
+		gaddrs, hdr.Forward, err = c.route.Match(hdr.Server, info.PlayData)
 		if errorx.Temporary(err) {
 			if errors.Is(err, ErrRouteProbe) {
 				c.config.logger.Info("start route probe", slog.String("server", hdr.Server.String()))
@@ -334,36 +494,141 @@ func (c *Client) uplinkService() (_ error) {
 			return c.close(err)
 		}
 		if info.PlayData {
-			c.trunk.Update(gaddr, hdr.Forward)
+			c.trunk.Update(gaddrs[0], hdr.Forward)
+		} else {
+			gaddrs = gaddrs[:1]
+		}
+		ext := c.extension(gaddrs)
+		if !ext {
+			gaddrs = gaddrs[:1] // forward drop redundant duplicate by seq option
+		}
+
+		var fi fec.Info
+		var parity []byte
+		hdr.Version, hdr.Options = 0, nil
+		if info.PlayData && ext {
+			flow := fec.Flow{Peer: hdr.Forward, Server: hdr.Server, Proto: hdr.Proto}
+			fi, parity = c.encoders.Encoder(flow, c.reportedPL).Encode(pkt.Bytes())
+			if fi.Size > 0 {
+				hdr.Version, hdr.Options = bvvd.Version, bvvd.Options{fi.Option()}
+			}
+			if len(gaddrs) > 1 {
+				seq++
+				hdr.Version = bvvd.Version
+				hdr.Options = append(hdr.Options, bvvd.Option{
+					Type: bvvd.OptionSeq, Value: binary.BigEndian.AppendUint32(nil, seq),
+				})
+			}
 		}
 
 		if err := hdr.Encode(pkt); err != nil {
 			return c.close(err)
 		}
-		if err = c.conn.WriteToAddrPort(pkt, gaddr); err != nil {
+		if err = c.writeRedundant(pkt, dup, gaddrs); err != nil {
+			return c.close(err)
+		}
+
+		if parity != nil {
+			var p = hdr
+			p.Kind = bvvd.Parity
+			p.DataID = uint8(c.uplinkId.Add(1))
+			p.Options = bvvd.Options{fi.Parity().Option()}
+
+			ppkt := packet.Make(head, 0, len(parity)).Append(parity...)
+			if err := p.Encode(ppkt); err != nil {
+				return c.close(err)
+			}
+			if err = c.writeRedundant(ppkt, dup, gaddrs); err != nil {
+				return c.close(err)
+			}
+		}
+	}
+}
+
+// writeRedundant write packet to every gateway, writeTo maybe modify packet
+// in place, so use copy except the last one
+func (c *Client) writeRedundant(pkt, dup *packet.Packet, gaddrs []netip.AddrPort) error {
+	for i, gaddr := range gaddrs {
+		p := pkt
+		if i < len(gaddrs)-1 {
+			p = dup.Sets(64, 0).Append(pkt.Bytes()...)
+		}
+		if err := c.writeTo(p, gaddr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reportedPL uplink pack loss reported by trunk gateway, used by fec
+func (c *Client) reportedPL() stats.PL {
+	return stats.PL(math.Float64frombits(c.uplinkPL.Load()))
+}
+
+// plService query uplink pack loss from trunk gateway periodically, the
+// reply is recorded by downlink service
+func (c *Client) plService() (_ error) {
+	var ticker = time.NewTicker(plQuery)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return nil
+		case <-ticker.C:
+		}
+
+		gaddr, faddr, _ := c.trunk.Trunk()
+		var m = msg.Fields{MsgID: c.plID}
+		m.Kind = bvvd.PackLossClientUplink
+		m.Forward = faddr
+		pkt := packet.Make(64, 0, msg.MinSize+8)
+		if err := m.Encode(pkt); err != nil {
+			return c.close(err)
+		}
+		if err := c.writeTo(pkt, gaddr); err != nil {
 			return c.close(err)
 		}
 	}
 }
 
+// plQuery interval of uplink pack loss query, gateway reply pack loss of
+// the window since last query
+const plQuery = time.Second * 5
+
 func (c *Client) downlinkServic() (_ error) {
 	var (
-		laddr = tcpip.AddrFrom4(c.laddr.Addr().As4())
-		pkt   = packet.Make(0, c.config.MaxRecvBuff)
+		pkt = packet.Make(0, c.config.MaxRecvBuff)
 	)
 
 	for {
 		gaddr, err := c.conn.ReadFromAddrPort(pkt.Sets(64, 0xffff))
 		if err != nil {
 			return c.close(err)
-		} else if pkt.Data() == 0 {
+		} else if pkt.Data() < bvvd.Size || pkt.Data() < bvvd.Bvvd(pkt.Bytes()).Len() {
 			continue
 		}
 
 		hdr := bvvd.Bvvd(pkt.Bytes())
+		if hdr.Kind() != bvvd.Session && !c.verify(pkt, gaddr) {
+			c.config.logger.Warn("invalid mac", slog.String("gateway", gaddr.String()), slog.String("kind", hdr.Kind().String()))
+			continue
+		}
 
-		if hdr.Kind() != bvvd.Data {
-			if pkt.Data() >= msg.MinSize {
+		if hdr.Kind() == bvvd.Unreachable {
+			c.unreachable(gaddr, hdr.Forward())
+			continue
+		} else if hdr.Kind() != bvvd.Data && hdr.Kind() != bvvd.Parity {
+			if m := (*msg.Message)(pkt); pkt.Data() >= m.Size() {
+				if hdr.Kind() == bvvd.PackLossClientUplink {
+					var pl stats.PL
+					if err := m.Payload(&pl); err == nil {
+						c.uplinkPL.Store(math.Float64bits(float64(pl)))
+					}
+					if m.MsgID() == c.plID {
+						continue // reply of plService
+					}
+				}
 				c.msgbuff.MustPut(message{
 					msg:   (*msg.Message)(packet.From(pkt.Bytes())),
 					gaddr: gaddr, time: time.Now(),
@@ -376,27 +641,60 @@ func (c *Client) downlinkServic() (_ error) {
 
 		c.downlinkPL.ID(int(hdr.DataID()))
 
-		pkt.DetachN(bvvd.Size)
-		if hdr.Proto() == header.TCPProtocolNumber {
-			fatun.UpdateTcpMssOption(pkt.Bytes(), c.config.TcpMssDelta)
-		}
-
-		ip := header.IPv4(pkt.AttachN(header.IPv4MinimumSize).Bytes())
-		ip.Encode(&header.IPv4Fields{
-			TotalLength: uint16(pkt.Data()),
-			TTL:         64,
-			Protocol:    uint8(hdr.Proto()),
-			SrcAddr:     tcpip.AddrFrom4(hdr.Server().As4()),
-			DstAddr:     laddr,
-		})
-		checksum.Rechecksum(ip)
-
-		if c.pcap != nil {
-			c.pcap.WriteIP(ip)
+		if info, protected := fec.Parse(hdr); protected {
+			flow := fec.Flow{Peer: hdr.Forward(), Server: hdr.Server(), Proto: hdr.Proto()}
+			pass, recovered := c.decoders.Decoder(flow).Decode(info, pkt.Bytes()[hdr.Len():])
+			if len(recovered) >= header.UDPMinimumSize {
+				rpkt := packet.Make(64, 0, hdr.Len()+len(recovered))
+				rpkt.Append(hdr[:hdr.Len()]...).Append(recovered...)
+				if err := c.deliver(rpkt); err != nil {
+					return c.close(err)
+				}
+			}
+			if !pass {
+				continue // parity or recovered
+			}
+		} else if hdr.Kind() == bvvd.Parity {
+			continue
 		}
 
-		if err := c.inject.Inject(ip); err != nil {
+		if err := c.deliver(pkt); err != nil {
 			return c.close(err)
 		}
 	}
 }
+
+// unreachable forward is down, expire routes to it, then re-probe route
+func (c *Client) unreachable(gaddr, faddr netip.AddrPort) {
+	n := c.route.Expire(faddr)
+	c.config.logger.Warn("forward unreachable",
+		slog.String("gateway", gaddr.String()),
+		slog.String("forward", faddr.String()),
+		slog.Int("routes", n),
+	)
+}
+
+// deliver inject downlink Data packet to local
+func (c *Client) deliver(pkt *packet.Packet) error {
+	hdr := bvvd.Bvvd(pkt.Bytes())
+	pkt.DetachN(hdr.Len())
+	if hdr.Proto() == header.TCPProtocolNumber {
+		fatun.UpdateTcpMssOption(pkt.Bytes(), c.config.TcpMssDelta)
+	}
+
+	ip := header.IPv4(pkt.AttachN(header.IPv4MinimumSize).Bytes())
+	ip.Encode(&header.IPv4Fields{
+		TotalLength: uint16(pkt.Data()),
+		TTL:         64,
+		Protocol:    uint8(hdr.Proto()),
+		SrcAddr:     tcpip.AddrFrom4(hdr.Server().As4()),
+		DstAddr:     tcpip.AddrFrom4(c.laddr.Addr().As4()),
+	})
+	checksum.Rechecksum(ip)
+
+	if c.pcap != nil {
+		c.pcap.WriteIP(ip)
+	}
+
+	return c.inject.Inject(ip)
+}
@@ -4,6 +4,7 @@
 package client_test
 
 import (
+	"context"
 	"fmt"
 	"net/netip"
 	"os"
@@ -42,7 +43,7 @@ func TestXxxx(t *testing.T) {
 	}
 	os.Remove(config.PcapPath)
 
-	c, err := client.New(config)
+	c, err := client.New(context.Background(), config)
 	require.NoError(t, err)
 
 	for {
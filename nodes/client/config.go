@@ -1,11 +1,16 @@
 package client
 
 import (
+	"crypto/ecdh"
 	"log/slog"
 	"net/netip"
 	"os"
 
 	"github.com/lysShub/anton-planet-accelerator/bvvd"
+	"github.com/lysShub/anton-planet-accelerator/nodes"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/geo"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/chacha20poly1305"
 )
 
 type Config struct {
@@ -16,17 +21,44 @@ type Config struct {
 
 	LogPath string
 	logger  *slog.Logger
+	logFile *os.File // nil if stdout
 
 	PcapPath string
 
 	FixRoute bool
 	Location bvvd.Location
 	Gateways []netip.AddrPort
+
+	// Controller fetch gateways from controller when Gateways is empty,
+	// authenticate by Token, ControllerCA verify certificate of controller.
+	Controller   string
+	ControllerCA string
+
+	// Redundant redundant mode, duplicate uplink play data over the best
+	// Redundant gateways that reach the same forward, forward drop duplicate
+	// by seq. downlink is not duplicated, 0 or 1 is disabled.
+	Redundant int
+
+	// Token session authenticate token, empty will handshake without token,
+	// only negotiate version, work with gateway disabled authentication.
+	Token string
+
+	// GatewayKey x25519 static public key of gateways, token is sealed by it
+	// and gateway is verified during session handshake, required by gateway
+	// enabled mac or seal. nil will send token as plaintext.
+	GatewayKey *ecdh.PublicKey
+
+	// GeoProvider lookup location of ip, nil will use http with cache.
+	GeoProvider geo.Provider
+
+	// Transport transport to gateways, nodes.UDP, nodes.TCP or nodes.Auto,
+	// default is udp. client only support ipv4 gateway, refer nodes.ClientNetwork.
+	Transport string
 }
 
-func (c *Config) init() *Config {
+func (c *Config) init() error {
 	if c.Name == "" {
-		panic("require game name")
+		return errors.New("require game name")
 	}
 
 	if c.MaxRecvBuff < 1500 {
@@ -36,6 +68,32 @@ func (c *Config) init() *Config {
 		c.TcpMssDelta = -64
 	}
 
+	if c.Location.Valid() != nil {
+		return errors.New("require location")
+	}
+
+	if len(c.Gateways) == 0 && (c.Controller == "" || c.ControllerCA == "") {
+		return errors.New("require gateways or controller")
+	}
+	if c.Redundant < 0 || (len(c.Gateways) > 0 && c.Redundant > len(c.Gateways)) {
+		return errors.Errorf("invalid redundant %d", c.Redundant)
+	}
+	if len(c.Token) > 0xff-chacha20poly1305.Overhead {
+		return errors.Errorf("token too long %d", len(c.Token))
+	}
+
+	switch c.Transport {
+	case "":
+		c.Transport = nodes.UDP
+	case nodes.UDP, nodes.TCP, nodes.Auto:
+	default:
+		return errors.Errorf("not support transport %q", c.Transport)
+	}
+
+	if c.GeoProvider == nil {
+		c.GeoProvider = geo.Cache(geo.HTTP(), 1024)
+	}
+
 	var fh *os.File
 	if c.LogPath == "" {
 		fh = os.Stdout
@@ -43,18 +101,22 @@ func (c *Config) init() *Config {
 		var err error
 		fh, err = os.OpenFile(c.LogPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o666)
 		if err != nil {
-			panic(err)
+			return errors.WithStack(err)
 		}
+		c.logFile = fh
 	}
 	c.logger = slog.New(slog.NewJSONHandler(fh, nil))
+	return nil
+}
 
-	if c.Location.Valid() != nil {
-		panic("require location")
+// closeLog flush and close log file, stdout is kept
+func (c *Config) closeLog() error {
+	if c.logFile == nil {
+		return nil
 	}
-
-	if len(c.Gateways) == 0 {
-		panic("require gateways")
+	if err := c.logFile.Sync(); err != nil {
+		c.logFile.Close()
+		return errors.WithStack(err)
 	}
-
-	return c
+	return errors.WithStack(c.logFile.Close())
 }
@@ -15,8 +15,8 @@ type route struct {
 	fixRouteMode bool
 
 	// fixRoute 或者 autoRout 中的非PlayData, 都发送到default
-	defaultGateway netip.AddrPort
-	defaultForward netip.AddrPort
+	defaultGateways []netip.AddrPort
+	defaultForward  netip.AddrPort
 
 	mu     sync.RWMutex
 	routes map[netip.Addr]entry
@@ -35,42 +35,44 @@ func newRoute(fixRoute bool) *route {
 	}
 }
 
+// entry route entry, gateways is not empty, all of them can reach forward,
+// gateways[0] is the best one, others used by redundant mode
 type entry struct {
-	gateway netip.AddrPort
-	forward netip.AddrPort
+	gateways []netip.AddrPort
+	forward  netip.AddrPort
 }
 
 type RouteProbe interface {
-	RouteProbe(saddr netip.Addr) (gaddr, faddr netip.AddrPort, err error)
+	RouteProbe(saddr netip.Addr) (gaddrs []netip.AddrPort, faddr netip.AddrPort, err error)
 }
 
-func (r *route) Init(probe RouteProbe, defaultGateway, defaultForward netip.AddrPort) {
+func (r *route) Init(probe RouteProbe, defaultGateways []netip.AddrPort, defaultForward netip.AddrPort) {
 	if !r.inited.Swap(true) {
-		r.defaultGateway = defaultGateway
+		r.defaultGateways = defaultGateways
 		r.defaultForward = defaultForward
 
 		r.routeProbe = probe
 	}
 }
 
-func (r *route) Match(saddr netip.Addr, probe bool) (gaddr, faddr netip.AddrPort, err error) {
+func (r *route) Match(saddr netip.Addr, probe bool) (gaddrs []netip.AddrPort, faddr netip.AddrPort, err error) {
 	if !r.inited.Load() {
-		return netip.AddrPort{}, netip.AddrPort{}, errors.New("route not init")
+		return nil, netip.AddrPort{}, errors.New("route not init")
 	}
 	if !probe || r.fixRouteMode {
-		return r.defaultGateway, r.defaultForward, nil
+		return r.defaultGateways, r.defaultForward, nil
 	}
 
 	r.mu.RLock()
 	e, has := r.routes[saddr]
 	r.mu.RUnlock()
 	if has {
-		return e.gateway, e.forward, nil
+		return e.gateways, e.forward, nil
 	}
 	return r.probe(saddr)
 }
 
-func (r *route) probe(saddr netip.Addr) (gaddr, faddr netip.AddrPort, err error) {
+func (r *route) probe(saddr netip.Addr) (gaddrs []netip.AddrPort, faddr netip.AddrPort, err error) {
 	r.inflightMu.RLock()
 	rest, has := r.inflight[saddr]
 	r.inflightMu.RUnlock()
@@ -93,13 +95,28 @@ func (r *route) probe(saddr netip.Addr) (gaddr, faddr netip.AddrPort, err error)
 			e := r.routes[saddr]
 			r.mu.RUnlock()
 
-			gaddr, faddr = e.gateway, e.forward
+			gaddrs, faddr = e.gateways, e.forward
 		}
 	} else {
 		err = errorx.WrapTemp(ErrRouteProbing)
 	}
 
-	return gaddr, faddr, err
+	return gaddrs, faddr, err
+}
+
+// Expire delete routes to forward, return deleted count, not effect default
+// route of fix route mode
+func (r *route) Expire(faddr netip.AddrPort) (n int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for saddr, e := range r.routes {
+		if e.forward == faddr {
+			delete(r.routes, saddr)
+			n++
+		}
+	}
+	return n
 }
 
 type result struct {
@@ -108,10 +125,10 @@ type result struct {
 }
 
 func (r *route) probeRoute(saddr netip.Addr) {
-	gaddr, fid, err := r.routeProbe.RouteProbe(saddr)
+	gaddrs, fid, err := r.routeProbe.RouteProbe(saddr)
 	if err == nil {
 		r.mu.Lock()
-		r.routes[saddr] = entry{gaddr, fid}
+		r.routes[saddr] = entry{gaddrs, fid}
 		r.mu.Unlock()
 	}
 
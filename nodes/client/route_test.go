@@ -3,9 +3,11 @@ package client
 import (
 	"errors"
 	"fmt"
+	"net/netip"
 	"testing"
 
 	"github.com/lysShub/netkit/errorx"
+	"github.com/stretchr/testify/require"
 )
 
 func TestXxxxx(t *testing.T) {
@@ -14,3 +16,20 @@ func TestXxxxx(t *testing.T) {
 
 	fmt.Println(errors.Is(err, ErrRouteProbe))
 }
+
+func Test_RouteExpire(t *testing.T) {
+	var (
+		r      = newRoute(false)
+		gaddrs = []netip.AddrPort{netip.MustParseAddrPort("3.3.3.3:19986")}
+		faddr1 = netip.MustParseAddrPort("1.1.1.1:19986")
+		faddr2 = netip.MustParseAddrPort("2.2.2.2:19986")
+	)
+	r.routes[netip.MustParseAddr("8.8.8.8")] = entry{gaddrs, faddr1}
+	r.routes[netip.MustParseAddr("8.8.4.4")] = entry{gaddrs, faddr1}
+	r.routes[netip.MustParseAddr("1.0.0.1")] = entry{gaddrs, faddr2}
+
+	require.Equal(t, 2, r.Expire(faddr1))
+	require.Equal(t, 0, r.Expire(faddr1))
+	require.Len(t, r.routes, 1)
+	require.Equal(t, faddr2, r.routes[netip.MustParseAddr("1.0.0.1")].forward)
+}
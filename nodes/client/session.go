@@ -0,0 +1,211 @@
+//go:build windows
+// +build windows
+
+package client
+
+import (
+	"log/slog"
+	"math/rand"
+	"net/netip"
+	"time"
+
+	"github.com/lysShub/anton-planet-accelerator/bvvd"
+	"github.com/lysShub/anton-planet-accelerator/nodes"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/mac"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/msg"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/tunnel"
+	"github.com/lysShub/netkit/errorx"
+	"github.com/lysShub/netkit/packet"
+	"github.com/pkg/errors"
+)
+
+type session struct {
+	msg.Session
+	mac *mac.MAC // nil if gateway not require mac
+}
+
+// session get gateway session, return nil if not established
+func (c *Client) session(gaddr netip.AddrPort) *session {
+	c.sessionsMu.RLock()
+	defer c.sessionsMu.RUnlock()
+	return c.sessions[gaddr]
+}
+
+// writeTo write packet to gateway, append mac trailer if session require
+func (c *Client) writeTo(pkt *packet.Packet, gaddr netip.AddrPort) error {
+	if s := c.session(gaddr); s != nil && s.mac != nil {
+		s.mac.Sign(pkt)
+	}
+	return c.conn.WriteToAddrPort(pkt, gaddr)
+}
+
+// extension report whether all gateways support bvvd extension area,
+// legacy gateway not handshake or reply zero version
+func (c *Client) extension(gaddrs []netip.AddrPort) bool {
+	for _, gaddr := range gaddrs {
+		if s := c.session(gaddr); s == nil || s.Version < bvvd.Version {
+			return false
+		}
+	}
+	return true
+}
+
+// verify verify and remove mac trailer of packet from gateway
+func (c *Client) verify(pkt *packet.Packet, gaddr netip.AddrPort) bool {
+	if s := c.session(gaddr); s != nil && s.mac != nil {
+		return s.mac.Verify(pkt)
+	}
+	return true
+}
+
+// handshakes establish session with all gateways, require at least one success
+// if authenticate by token. without token, handshake only negotiate version,
+// legacy gateway not reply is regarded as not support extension
+func (c *Client) handshakes() error {
+	var err error
+	var ok bool
+	timeout := time.Second * 3
+	if c.config.Token == "" {
+		timeout = time.Second
+	}
+	for _, gaddr := range c.config.Gateways {
+		var s msg.Session
+		var keys *tunnel.Keys
+		if s, keys, err = c.handshake(gaddr, timeout); err != nil {
+			c.config.logger.Warn("session handshake", slog.String("gateway", gaddr.String()), slog.String("error", err.Error()))
+			continue
+		}
+
+		var e = &session{Session: s}
+		if old := c.session(gaddr); old != nil && old.ID == s.ID {
+			e.mac = old.mac // renewed, keep session keys
+		} else if keys != nil && s.Flags.Has(msg.FlagSeal) {
+			if err = c.conn.Add(gaddr, keys.Tunnel); err != nil {
+				return err
+			}
+		} else {
+			c.conn.Del(gaddr)
+		}
+		if keys != nil && s.Flags.Has(msg.FlagMAC) {
+			e.mac = mac.New(keys.MAC, true)
+		}
+
+		c.sessionsMu.Lock()
+		c.sessions[gaddr] = e
+		c.sessionsMu.Unlock()
+		ok = true
+	}
+	if !ok && c.config.Token != "" {
+		return errors.WithMessage(err, "session handshake failed")
+	}
+	return nil
+}
+
+// handshake handshake with gateway, return derived session keys, keys is nil
+// if session renewed or not require keys
+func (c *Client) handshake(gaddr netip.AddrPort, timeout time.Duration) (msg.Session, *tunnel.Keys, error) {
+	var pkt = packet.Make(msg.MinSize)
+
+	priv, pub, err := tunnel.KeyPair()
+	if err != nil {
+		return msg.Session{}, nil, err
+	}
+	var hello = msg.Hello{
+		Public: pub, Version: bvvd.Version,
+		Token: msg.Token(c.config.Token), Padding: rand.Intn(64),
+	}
+	old := c.session(gaddr)
+	if old != nil {
+		hello.Session = old.ID
+	}
+	var static [32]byte
+	if c.config.GatewayKey != nil {
+		copy(static[:], c.config.GatewayKey.Bytes())
+		hello.Sealed, hello.Time = true, time.Now().Unix()
+		sealed, err := tunnel.SealToken(priv, static, []byte(c.config.Token), hello.AD())
+		if err != nil {
+			return msg.Session{}, nil, err
+		}
+		hello.Token = msg.Token(sealed)
+	}
+	var m = msg.Fields{MsgID: rand.Uint32(), Payload: &hello}
+	m.Kind = bvvd.Session
+	if err := m.Encode(pkt); err != nil {
+		return msg.Session{}, nil, err
+	}
+
+	if err := c.conn.WriteToAddrPort(pkt, gaddr); err != nil {
+		return msg.Session{}, nil, c.close(err)
+	}
+
+	e, ok := c.msgbuff.PopDeadline(func(e message) (pop bool) {
+		return e.msg.MsgID() == m.MsgID && e.gaddr == gaddr
+	}, time.Now().Add(timeout))
+	if !ok {
+		return msg.Session{}, nil, errorx.WrapTemp(errors.New("timeout"))
+	}
+
+	var s msg.Session
+	if err := e.msg.Payload(&s); err != nil {
+		return msg.Session{}, nil, err
+	} else if s.Rejected() {
+		return msg.Session{}, nil, errors.Errorf("gateway %s reject token", gaddr.String())
+	}
+
+	if old != nil && old.ID == s.ID {
+		if s.Public != old.Public || s.Confirm != old.Confirm {
+			return msg.Session{}, nil, errors.Errorf("gateway %s renew mismatched session", gaddr.String())
+		}
+		return s, nil, nil
+	} else if !s.Flags.Has(msg.FlagMAC) && !s.Flags.Has(msg.FlagSeal) && c.config.GatewayKey == nil {
+		return s, nil, nil
+	}
+
+	ee, err := tunnel.ECDH(priv, s.Public)
+	if err != nil {
+		return msg.Session{}, nil, err
+	}
+	var secrets = [][]byte{ee}
+	if c.config.GatewayKey != nil {
+		es, err := tunnel.ECDH(priv, static)
+		if err != nil {
+			return msg.Session{}, nil, err
+		}
+		secrets = append(secrets, es)
+	}
+	keys, err := tunnel.Derive(s.Salt(pub, msg.Token(c.config.Token)), secrets...)
+	if err != nil {
+		return msg.Session{}, nil, err
+	} else if c.config.GatewayKey != nil && keys.Confirm != s.Confirm {
+		return msg.Session{}, nil, errors.Errorf("gateway %s not hold the static key", gaddr.String())
+	}
+	return s, &keys, nil
+}
+
+// sessionService renew sessions periodically, keep gateway not evict client,
+// failed renew is retried with exponential backoff
+func (c *Client) sessionService() (_ error) {
+	const interval = nodes.Keepalive / 2
+	var (
+		timer = time.NewTimer(interval)
+		retry time.Duration // zero if last renew success
+	)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return nil
+		case <-timer.C:
+		}
+
+		if err := c.handshakes(); err != nil {
+			retry = min(max(retry*2, time.Second), interval)
+			c.config.logger.Warn("renew session", slog.String("error", err.Error()), slog.Duration("retry", retry))
+			timer.Reset(retry)
+		} else {
+			retry = 0
+			timer.Reset(interval)
+		}
+	}
+}
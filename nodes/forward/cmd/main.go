@@ -4,22 +4,169 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/jftuga/geodist"
+	"github.com/lysShub/anton-planet-accelerator/bvvd"
+	"github.com/lysShub/anton-planet-accelerator/nodes"
+	"github.com/lysShub/anton-planet-accelerator/nodes/admin"
 	"github.com/lysShub/anton-planet-accelerator/nodes/forward"
-	"github.com/lysShub/rawsock/test"
-	"github.com/stretchr/testify/require"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/config"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/geo"
+	"github.com/pkg/errors"
 )
 
-// go run -tags "debug" . 莫斯科
+type Config struct {
+	Listen          string   `json:"listen" yaml:"listen" toml:"listen" flag:"listen" env:"LISTEN" usage:"listen address"`
+	MaxRecvBuffSize int      `json:"max_recv_buff" yaml:"max_recv_buff" toml:"max_recv_buff" flag:"max-recv-buff" env:"MAX_RECV_BUFF" usage:"max receive buffer size"`
+	LogPath         string   `json:"log_path" yaml:"log_path" toml:"log_path" flag:"log" env:"LOG_PATH" usage:"log file path, empty is stdout"`
+	GeoDB           string   `json:"geo_db" yaml:"geo_db" toml:"geo_db" flag:"geo-db" env:"GEO_DB" usage:"geolocation database, .mmdb or .csv"`
+	GeoHTTP         bool     `json:"geo_http" yaml:"geo_http" toml:"geo_http" flag:"geo-http" env:"GEO_HTTP" usage:"lookup geolocation by http when not found in database"`
+	PublicAddr      string   `json:"public_addr" yaml:"public_addr" toml:"public_addr" flag:"public-addr" env:"PUBLIC_ADDR" usage:"public ip address, empty will discover by stun or lookup by http"`
+	STUNServers     []string `json:"stun_servers" yaml:"stun_servers" toml:"stun_servers" flag:"stun-servers" env:"STUN_SERVERS" usage:"stun servers host:port, comma separated"`
+	Location        string   `json:"location" yaml:"location" toml:"location" flag:"location" env:"LOCATION" usage:"declared location, such as Moscow"`
+	Coord           string   `json:"coord" yaml:"coord" toml:"coord" flag:"coord" env:"COORD" usage:"declared coordinate lat,lon, used when location not declared"`
+	Controller      string   `json:"controller" yaml:"controller" toml:"controller" flag:"controller" env:"CONTROLLER" usage:"controller address, register to it then gateways will add this forward"`
+	ControllerCA    string   `json:"controller_ca" yaml:"controller_ca" toml:"controller_ca" flag:"controller-ca" env:"CONTROLLER_CA" usage:"ca certificate file of controller"`
+	Cert            string   `json:"cert" yaml:"cert" toml:"cert" flag:"cert" env:"CERT" usage:"certificate file issued by ca, common name is the registered name"`
+	Key             string   `json:"key" yaml:"key" toml:"key" flag:"key" env:"KEY" usage:"private key file of certificate"`
+	Metrics         string   `json:"metrics" yaml:"metrics" toml:"metrics" flag:"metrics" env:"METRICS" usage:"prometheus metrics http listen address, empty is disabled"`
+	Inspect         string   `json:"inspect" yaml:"inspect" toml:"inspect" flag:"inspect" env:"INSPECT" usage:"read-only json api listen address, loopback address or unix:path, empty is disabled"`
+	Transport       string   `json:"transport" yaml:"transport" toml:"transport" flag:"transport" env:"TRANSPORT" usage:"listen transport of gateways, udp or tcp(fake tcp)"`
+}
+
+// go run -tags "debug" . -config forward.yaml
 func main() {
-	t := test.T()
+	if err := run(); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "forward:", err.Error())
+		}
+		os.Exit(1)
+	}
+}
 
-	config := &forward.Config{
+func run() error {
+	var c = Config{
+		Listen:          ":19986",
 		MaxRecvBuffSize: 2048,
+		GeoHTTP:         true,
+		Inspect:         "127.0.0.1:19989",
+		Transport:       nodes.UDP,
+	}
+	if err := config.Parse("forward", "FORWARD_", &c, os.Args[1:]); err != nil {
+		return err
+	}
+	cfg, err := c.build()
+	if err != nil {
+		return err
+	}
+
+	f, err := forward.New(c.Listen, cfg)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return f.Serve(ctx)
+}
+
+// build validate and build forward config
+func (c *Config) build() (*forward.Config, error) {
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		return nil, errors.Errorf("invalid listen address %q", c.Listen)
+	}
+	if _, _, err := net.SplitHostPort(c.Metrics); c.Metrics != "" && err != nil {
+		return nil, errors.Errorf("invalid metrics address %q", c.Metrics)
+	}
+	if c.MaxRecvBuffSize < 1500 {
+		return nil, errors.Errorf("max receive buffer %d less than 1500", c.MaxRecvBuffSize)
+	}
+	if _, err := nodes.ForwardNetwork(c.Transport); err != nil {
+		return nil, err
 	}
 
-	f, err := forward.New(":19986", config)
-	require.NoError(t, err)
+	var cfg = &forward.Config{
+		MaxRecvBuffSize: c.MaxRecvBuffSize,
+		LogPath:         c.LogPath,
+		MetricsAddr:     c.Metrics,
+		InspectAddr:     c.Inspect,
+		Transport:       c.Transport,
+	}
+	provider, err := geo.Open(c.GeoDB, c.GeoHTTP)
+	if err != nil {
+		return nil, err
+	}
+	cfg.GeoProvider = provider
 
-	err = f.Serve()
-	require.NoError(t, err)
+	if c.PublicAddr != "" {
+		addr, err := netip.ParseAddr(c.PublicAddr)
+		if err != nil {
+			return nil, errors.Errorf("invalid public address %q", c.PublicAddr)
+		}
+		cfg.PublicAddr = addr
+	}
+	for _, e := range c.STUNServers {
+		addr, err := net.ResolveUDPAddr("udp", e)
+		if err != nil {
+			return nil, errors.Errorf("invalid stun server %q", e)
+		}
+		cfg.STUNServers = append(cfg.STUNServers, netip.AddrPortFrom(addr.AddrPort().Addr().Unmap(), addr.AddrPort().Port()))
+	}
+	if c.Location != "" {
+		loc, err := bvvd.ParseLocation(c.Location)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Location = loc
+	}
+	if c.Coord != "" {
+		coord, err := parseCoord(c.Coord)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Coord = &coord
+	}
+	if c.Controller != "" {
+		if cfg.Controller, err = c.registry(); err != nil {
+			return nil, err
+		}
+	}
+	return cfg, nil
+}
+
+// registry build controller registry with mutual tls
+func (c *Config) registry() (*admin.Registry, error) {
+	if _, _, err := net.SplitHostPort(c.Controller); err != nil {
+		return nil, errors.Errorf("invalid controller address %q", c.Controller)
+	}
+	if c.ControllerCA == "" || c.Cert == "" || c.Key == "" {
+		return nil, errors.New("controller require controller ca, cert and key")
+	}
+	cfg, err := admin.ClientTLS(c.ControllerCA, c.Cert, c.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &admin.Registry{Addr: c.Controller, TLS: cfg}, nil
+}
+
+// parseCoord parse coordinate from lat,lon
+func parseCoord(s string) (geodist.Coord, error) {
+	lat, lon, _ := strings.Cut(s, ",")
+	var c geodist.Coord
+	var err1, err2 error
+	c.Lat, err1 = strconv.ParseFloat(strings.TrimSpace(lat), 64)
+	c.Lon, err2 = strconv.ParseFloat(strings.TrimSpace(lon), 64)
+	if err1 != nil || err2 != nil || c.Lat < -90 || c.Lat > 90 || c.Lon < -180 || c.Lon > 180 {
+		return geodist.Coord{}, errors.Errorf("invalid coordinate %q", s)
+	}
+	return c, nil
 }
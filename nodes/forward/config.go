@@ -2,7 +2,15 @@ package forward
 
 import (
 	"log/slog"
+	"net/netip"
 	"os"
+
+	"github.com/jftuga/geodist"
+	"github.com/lysShub/anton-planet-accelerator/bvvd"
+	"github.com/lysShub/anton-planet-accelerator/nodes"
+	"github.com/lysShub/anton-planet-accelerator/nodes/admin"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/geo"
+	"github.com/pkg/errors"
 )
 
 type Config struct {
@@ -10,20 +18,84 @@ type Config struct {
 
 	LogPath string
 	logger  *slog.Logger
+	logFile *os.File // nil if stdout
+
+	// PublicAddr public address of forward, invalid will discover by stun.
+	// declare PublicAddr and Location (or Coord) to start without network
+	// lookup, such as offline or firewalled host.
+	PublicAddr netip.Addr
+
+	// STUNServers discover public address and nat type by stun, empty
+	// will lookup public address by http, assume without nat.
+	STUNServers []netip.AddrPort
+
+	// Location declared location of forward, prior to Coord, both not
+	// given will lookup by public address.
+	Location bvvd.Location
+	Coord    *geodist.Coord
+
+	// GeoProvider lookup location of ip, nil will use http with cache.
+	GeoProvider geo.Provider
+
+	// Controller register to controller, gateways will add the forward
+	// pushed by controller, nil will not register.
+	Controller *admin.Registry
+
+	// MetricsAddr http listen address of prometheus metrics, path is
+	// /metrics, empty will disable.
+	MetricsAddr string
+
+	// InspectAddr listen address of read-only json api, loopback address
+	// or unix socket path with unix: prefix, empty will disable.
+	InspectAddr string
+
+	// Transport listen transport of gateways, nodes.UDP or nodes.TCP,
+	// default is udp.
+	Transport string
 }
 
-func (c *Config) init() *Config {
-	var err error
+func (c *Config) init() error {
+	if c.Location != 0 {
+		if err := c.Location.Valid(); err != nil {
+			return err
+		}
+	}
+	if c.Controller != nil && (c.Controller.Addr == "" || c.Controller.TLS == nil) {
+		return errors.New("controller require address and tls")
+	}
+	if c.Transport == "" {
+		c.Transport = nodes.UDP
+	}
+	if _, err := nodes.ForwardNetwork(c.Transport); err != nil {
+		return err
+	}
+	if c.GeoProvider == nil {
+		c.GeoProvider = geo.Cache(geo.HTTP(), 1024)
+	}
 
 	var fh *os.File
 	if c.LogPath == "" {
 		fh = os.Stdout
 	} else {
+		var err error
 		fh, err = os.OpenFile(c.LogPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o666)
 		if err != nil {
-			panic(err)
+			return errors.WithStack(err)
 		}
+		c.logFile = fh
 	}
 	c.logger = slog.New(slog.NewJSONHandler(fh, nil))
-	return c
+	return nil
+}
+
+// closeLog flush and close log file, stdout is kept
+func (c *Config) closeLog() error {
+	if c.logFile == nil {
+		return nil
+	}
+	if err := c.logFile.Sync(); err != nil {
+		c.logFile.Close()
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(c.logFile.Close())
 }
@@ -0,0 +1,13 @@
+//go:build linux
+// +build linux
+
+package forward
+
+// Stopped report whether timers of forward stopped, closed link stop its
+// keepalive timer and remove self from links
+func Stopped(f *Forward) bool {
+	f.ps.mu.RLock()
+	ps := f.ps.closed
+	f.ps.mu.RUnlock()
+	return ps && f.links.Len() == 0
+}
@@ -4,20 +4,32 @@
 package forward
 
 import (
+	"context"
+	"encoding/binary"
 	"log/slog"
 	"math/rand"
 	"net"
 	"net/netip"
 	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	"github.com/jftuga/geodist"
 	"github.com/lysShub/anton-planet-accelerator/bvvd"
 	"github.com/lysShub/anton-planet-accelerator/conn"
 	"github.com/lysShub/anton-planet-accelerator/nodes"
+	"github.com/lysShub/anton-planet-accelerator/nodes/admin"
 	"github.com/lysShub/anton-planet-accelerator/nodes/forward/links"
 	"github.com/lysShub/anton-planet-accelerator/nodes/forward/pinger"
+	"github.com/lysShub/anton-planet-accelerator/nodes/inspect"
 	"github.com/lysShub/anton-planet-accelerator/nodes/internal"
 	"github.com/lysShub/anton-planet-accelerator/nodes/internal/checksum"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/ethtool"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/fec"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/metrics"
 	"github.com/lysShub/anton-planet-accelerator/nodes/internal/msg"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/stun"
 	"github.com/lysShub/netkit/debug"
 	"github.com/lysShub/netkit/errorx"
 	"github.com/lysShub/netkit/packet"
@@ -31,30 +43,55 @@ type Forward struct {
 	config *Config
 	faddr  netip.AddrPort
 	loc    bvvd.Location
+	nat    stun.NAT
 
 	conn conn.Conn
 	ps   *Gateways
 
-	links *links.Links
+	links    *links.Links
+	encoders *fec.Encoders // downlink fec
+	decoders *fec.Decoders // uplink fec
 
 	pinger *pinger.Pinger
 	pingCh chan pinger.Info
 
+	metrics *metrics.Metrics
+	mserver *metrics.Server // nil if metrics disabled
+	iserver *inspect.Server // nil if inspect disabled
+	traffic *metrics.Traffic
+	dropped dropped
+
+	offload *ethtool.Offload
+	control atomic.Pointer[admin.Session]
+
+	done     chan struct{}  // closed when forward close
+	services sync.WaitGroup // background services, wait after close
 	closeErr errorx.CloseErr
 }
 
 func New(addr string, config *Config) (*Forward, error) {
+	if err := config.init(); err != nil {
+		return nil, err
+	}
 	var f = &Forward{
-		config: config.init(),
+		config: config,
 		ps:     NewGateways(),
 		links:  links.NewLinks(),
+
+		encoders: fec.NewEncoders(),
+		decoders: fec.NewDecoders(),
+		done:     make(chan struct{}),
 	}
-	err := internal.DisableOffload(config.logger)
+	f.initMetrics()
+	network, err := nodes.ForwardNetwork(f.config.Transport)
 	if err != nil {
-		return nil, err
+		return nil, f.close(err)
 	}
-
-	f.conn, err = conn.Bind(nodes.ForwardNetwork, addr)
+	f.conn, err = conn.Bind(network, addr)
+	if err != nil {
+		return nil, f.close(err)
+	}
+	f.offload, err = ethtool.DisableOffload(f.conn.LocalAddr().Addr(), config.logger)
 	if err != nil {
 		return nil, f.close(err)
 	}
@@ -65,140 +102,396 @@ func New(addr string, config *Config) (*Forward, error) {
 		return nil, f.close(err)
 	}
 
-	public, err := internal.PublicAddr()
-	if err != nil {
+	if f.faddr, err = f.publicAddr(); err != nil {
 		return nil, f.close(err)
-	} else {
-		f.faddr = netip.MustParseAddrPort(f.conn.LocalAddr().String())
-		f.faddr = netip.AddrPortFrom(public, f.faddr.Port()) // without nat (require test)
 	}
-	coord, err := internal.IPCoord(f.faddr.Addr())
-	if err != nil {
+	if f.loc, err = f.location(); err != nil {
 		return nil, f.close(err)
 	}
+
+	if config.MetricsAddr != "" {
+		if f.mserver, err = metrics.Listen(config.MetricsAddr, f.metrics); err != nil {
+			return nil, f.close(err)
+		}
+	}
+	if config.InspectAddr != "" {
+		if f.iserver, err = inspect.NewServer(config.InspectAddr, f.inspectHandler()); err != nil {
+			return nil, f.close(err)
+		}
+	}
+	return f, nil
+}
+
+// publicAddr get advertised address of forward, prefer declared by config,
+// then discover by stun, fallback to lookup by http that assume without nat
+// if stun servers not given or discover failed.
+func (f *Forward) publicAddr() (netip.AddrPort, error) {
+	laddr := f.conn.LocalAddr()
+	if f.config.PublicAddr.IsValid() {
+		return netip.AddrPortFrom(f.config.PublicAddr, laddr.Port()), nil
+	}
+
+	// stun only work over udp
+	if len(f.config.STUNServers) > 0 && f.config.Transport == nodes.UDP {
+		mapped, nat, err := stun.Discover(f.conn, f.config.STUNServers, time.Second*3)
+		if err == nil {
+			f.nat = nat
+			if nat == stun.Dependent {
+				f.config.logger.Warn("endpoint-dependent nat, gateways maybe unreachable", slog.String("mapped", mapped.String()))
+			}
+			return mapped, nil
+		}
+		f.config.logger.Warn("stun discover failed, fallback to http", slog.String("error", err.Error()))
+	}
+
+	public, err := internal.PublicAddr()
+	if err != nil {
+		return netip.AddrPort{}, err
+	}
+	return netip.AddrPortFrom(public, laddr.Port()), nil
+}
+
+// location get forward location, prefer declared by config, fallback to lookup
+func (f *Forward) location() (bvvd.Location, error) {
+	if f.config.Location != 0 {
+		return f.config.Location, nil
+	}
+
+	var coord geodist.Coord
+	if f.config.Coord != nil {
+		coord = *f.config.Coord
+	} else {
+		var err error
+		if coord, err = f.config.GeoProvider.Coord(f.faddr.Addr()); err != nil {
+			return 0, err
+		}
+	}
+
 	loc, off := bvvd.Locations.Match(coord)
 	if off > 500 {
-		return nil, f.close(errors.Errorf("%s offset location %s  %fkm too large", public, loc, off))
+		return 0, errors.Errorf("%s offset location %s  %fkm too large", f.faddr.Addr(), loc, off)
 	}
-	f.loc = loc
-
-	return f, nil
+	return loc, nil
 }
 
 func (f *Forward) close(cause error) error {
 	return f.closeErr.Close(func() (errs []error) {
 		errs = append(errs, cause)
+		close(f.done)
 		if f.pinger != nil {
 			errs = append(errs, f.pinger.Close())
 		}
-		if f.pingCh != nil {
-			close(f.pingCh)
-		}
 		if f.links != nil {
 			errs = append(errs, f.links.Close())
 		}
 		if f.conn != nil {
 			errs = append(errs, f.conn.Close())
 		}
+		if f.mserver != nil {
+			errs = append(errs, f.mserver.Close())
+		}
+		if f.iserver != nil {
+			errs = append(errs, f.iserver.Close())
+		}
+		if s := f.control.Load(); s != nil {
+			errs = append(errs, s.Close())
+		}
+		if f.ps != nil {
+			errs = append(errs, f.ps.Close())
+		}
+		if f.offload != nil {
+			errs = append(errs, f.offload.Restore())
+		}
+		errs = append(errs, f.config.closeLog())
 		return errs
 	})
 }
 
-func (f *Forward) Serve() error {
+// Serve serve until ctx done or error occurred, stopped by ctx will
+// return nil. return after links drained and all services exited.
+func (f *Forward) Serve(ctx context.Context) error {
 	f.config.logger.Info("start",
 		slog.String("listen", f.conn.LocalAddr().String()),
+		slog.String("transport", f.config.Transport),
 		slog.String("faddr", f.faddr.String()),
 		slog.String("location", f.loc.Hans()),
+		slog.String("nat", f.nat.String()),
+		slog.String("metrics", f.config.MetricsAddr),
+		slog.String("inspect", f.config.InspectAddr),
 		slog.Bool("debug", debug.Debug()),
 	)
 
-	go f.pingService()
-	return f.uplinkService()
+	stop := context.AfterFunc(ctx, func() { f.close(nil) })
+	defer stop()
+
+	f.service(f.pingService)
+	if f.config.Controller != nil {
+		f.service(f.controlService)
+	}
+	if f.mserver != nil {
+		f.service(f.metricsService)
+	}
+	if f.iserver != nil {
+		f.service(f.inspectService)
+	}
+	err := f.close(f.uplinkService())
+
+	f.services.Wait()
+	return err
+}
+
+// metricsService serve metrics http until forward closed
+func (f *Forward) metricsService() (_ error) {
+	if err := f.mserver.Serve(); err != nil {
+		return f.close(err)
+	}
+	return nil
+}
+
+// inspectService serve inspect api until forward closed
+func (f *Forward) inspectService() (_ error) {
+	if err := f.iserver.Serve(); err != nil {
+		return f.close(err)
+	}
+	return nil
+}
+
+// Close stop forward, Serve will return nil
+func (f *Forward) Close() error { return f.close(nil) }
+
+// service start background service, Serve wait it exit
+func (f *Forward) service(fn func() error) {
+	f.services.Add(1)
+	go func() {
+		defer f.services.Done()
+		fn()
+	}()
+}
+
+// controlService register to controller, keep registered until closed,
+// re-register after disconnected
+func (f *Forward) controlService() (_ error) {
+	for {
+		if err := f.controlHandle(); err != nil && !f.closeErr.Closed() {
+			f.config.logger.Warn("controller", slog.String("error", err.Error()), errorx.Trace(err))
+		}
+
+		select {
+		case <-f.done:
+			return nil
+		case <-time.After(time.Second * 3):
+		}
+	}
+}
+
+func (f *Forward) controlHandle() error {
+	r := f.config.Controller
+	sess, reply, err := admin.Register(r.Addr, r.TLS, admin.ForwardNew{
+		Addr:     f.faddr,
+		Location: f.loc,
+	})
+	if err != nil {
+		return err
+	}
+	f.control.Store(sess)
+	defer sess.Close()
+	if f.closeErr.Closed() {
+		return nil
+	}
+	f.config.logger.Info("registered controller", slog.String("controller", r.Addr), slog.String("name", reply.(admin.ForwardNew).Name))
+
+	for {
+		// controller not push message to forward, only detect disconnect
+		if _, err := sess.Recv(); err != nil {
+			return err
+		}
+	}
 }
 
 func (f *Forward) uplinkService() (err error) {
-	var (
-		pkt = packet.Make(f.config.MaxRecvBuffSize)
-	)
+	var msgs = make([]conn.Message, nodes.BatchSize)
+	for i := range msgs {
+		msgs[i].Packet = packet.Make(f.config.MaxRecvBuffSize)
+	}
 
 	for {
-		gaddr, err := f.conn.ReadFromAddrPort(pkt.Sets(64, 0xffff))
+		for _, e := range msgs {
+			e.Packet.Sets(64, 0xffff)
+		}
+		n, err := f.conn.ReadBatch(msgs)
 		if err != nil {
 			return f.close(err)
-		} else if pkt.Data() < bvvd.Size {
-			continue
 		}
-
-		hdr := bvvd.Bvvd(pkt.Bytes())
-		hdr.SetForward(f.faddr)
-		if hdr.Kind() != bvvd.Data && pkt.Data() < msg.MinSize {
-			f.config.logger.Warn("too small", slog.Int("size", pkt.Data()), slog.String("gateway", gaddr.String()), slog.String("kind", hdr.Kind().String()))
-			continue
+		for _, e := range msgs[:n] {
+			if err := f.uplink(e.Packet, e.Addr); err != nil {
+				return err
+			}
 		}
+	}
+}
 
-		switch kind := hdr.Kind(); kind {
-		case bvvd.PingForward:
-			if err := f.conn.WriteToAddrPort(pkt, gaddr); err != nil {
-				return f.close(err)
-			}
-		case bvvd.PingServer:
-			if err := f.pinger.Ping(pinger.Info{
-				Addr: hdr.Server(), Gaddr: gaddr, Msg: slices.Clone(pkt.Bytes()),
-			}); err != nil {
-				return f.close(err)
-			}
-		case bvvd.PackLossGatewayUplink:
-			pl := f.ps.Gateway(gaddr).UplinkPL()
-			if err := (*msg.Message)(pkt).SetPayload(&pl); err != nil {
-				f.config.logger.Warn(err.Error(), errorx.Trace(err))
-			}
+// uplink handle packet from gateway
+func (f *Forward) uplink(pkt *packet.Packet, gaddr netip.AddrPort) error {
+	if pkt.Data() < bvvd.Size || pkt.Data() < bvvd.Bvvd(pkt.Bytes()).Len() {
+		f.dropped.tooSmall.Inc()
+		return nil
+	} else if slices.Contains(f.config.STUNServers, gaddr) && stun.Is(pkt.Bytes()) {
+		return nil // delayed stun response
+	}
+	f.traffic.Uplink(bvvd.Bvvd(pkt.Bytes()).Kind(), pkt.Data())
 
-			if err := f.conn.WriteToAddrPort(pkt, gaddr); err != nil {
-				return f.close(err)
+	hdr := bvvd.Fit(pkt, f.faddr.Addr())
+	hdr.SetForward(f.faddr)
+	if hdr.Kind() != bvvd.Data && pkt.Data() < (*msg.Message)(pkt).Size() {
+		f.dropped.tooSmall.Inc()
+		f.config.logger.Warn("too small", slog.Int("size", pkt.Data()), slog.String("gateway", gaddr.String()), slog.String("kind", hdr.Kind().String()))
+		return nil
+	}
+
+	switch kind := hdr.Kind(); kind {
+	case bvvd.PingForward:
+		if hdr.Client().Port() == 0 {
+			// health probe of gateway, reply supported version
+			v := msg.Version(bvvd.Version)
+			pkt.SetData((*msg.Message)(pkt).Size())
+			if err := (*msg.Message)(pkt).SetPayload(&v); err != nil {
+				f.config.logger.Warn(err.Error(), errorx.Trace(err))
 			}
-		case bvvd.Data:
-			f.ps.Gateway(gaddr).UplinkID(hdr.DataID())
-
-			// remove bvvd header
-			pkt = pkt.DetachN(bvvd.Size)
-			if debug.Debug() {
-				require.True(test.T(), checksum.ValidChecksum(pkt, uint8(hdr.Proto()), hdr.Server()))
-				require.Equal(test.T(), f.faddr, hdr.Forward())
+		}
+		if err := f.conn.WriteToAddrPort(pkt, gaddr); err != nil {
+			return f.close(err)
+		}
+	case bvvd.PingServer:
+		if err := f.pinger.Ping(pinger.Info{
+			Addr: hdr.Server(), Gaddr: gaddr, Msg: slices.Clone(pkt.Bytes()),
+		}); err != nil {
+			if errorx.Temporary(err) {
+				f.config.logger.Warn(err.Error(), slog.String("gateway", gaddr.String()))
+				return nil
 			}
+			return f.close(err)
+		}
+	case bvvd.PackLossGatewayUplink:
+		pl := f.ps.Gateway(gaddr).UplinkPL()
+		if err := (*msg.Message)(pkt).SetPayload(&pl); err != nil {
+			f.config.logger.Warn(err.Error(), errorx.Trace(err))
+		}
 
-			// only get port, tcp/udp is same
-			ep := links.NewEP(hdr, header.TCP(pkt.Bytes()))
+		if err := f.conn.WriteToAddrPort(pkt, gaddr); err != nil {
+			return f.close(err)
+		}
+	case bvvd.Data, bvvd.Parity:
+		f.ps.Gateway(gaddr).UplinkID(hdr.DataID())
 
-			// read/create corresponding link, the link will self-close by keepalive,
-			// so if Send/Recv return net.ErrClosed error should ignore.
-			link, new, err := f.links.Link(ep, gaddr, f.faddr)
-			if err != nil {
-				return f.close(err)
-			} else if new {
-				f.config.logger.Info("new link", slog.String("endpoint", ep.String()))
-				go f.downlinkService(link)
+		// remove bvvd header
+		info, protected := fec.Parse(hdr)
+		pkt = pkt.DetachN(hdr.Len())
+		if dup, err := f.duplicate(hdr, pkt, gaddr); err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
 			}
-
-			if err = link.Send(pkt); err != nil {
-				if errors.Is(err, net.ErrClosed) {
-					return nil
+			return f.close(err)
+		} else if dup {
+			return nil
+		}
+		if protected {
+			flow := fec.Flow{Peer: hdr.Client(), Server: hdr.Server(), Proto: hdr.Proto()}
+			pass, recovered := f.decoders.Decoder(flow).Decode(info, pkt.Bytes())
+			if len(recovered) >= header.UDPMinimumSize {
+				rpkt := packet.Make(64, 0, len(recovered)).Append(recovered...)
+				if err := f.send(hdr, rpkt, gaddr, true); err != nil {
+					if errors.Is(err, net.ErrClosed) {
+						return nil
+					}
+					return f.close(err)
 				}
-				return f.close(err)
 			}
-		default:
+			if !pass {
+				return nil // parity or recovered
+			}
+		} else if kind == bvvd.Parity {
+			return nil
+		}
+		if debug.Debug() {
+			require.True(test.T(), checksum.ValidChecksum(pkt, uint8(hdr.Proto()), hdr.Server()))
+			require.Equal(test.T(), f.faddr, hdr.Forward())
+		}
+
+		if err := f.send(hdr, pkt, gaddr, protected); err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return f.close(err)
 		}
+	default:
+		f.dropped.unknownKind.Inc()
 	}
+	return nil
 }
 
+// duplicate check Data with client assigned seq is duplicate, only redundant
+// mode carry seq
+func (f *Forward) duplicate(hdr bvvd.Bvvd, pkt *packet.Packet, gaddr netip.AddrPort) (bool, error) {
+	seq, has := hdr.Option(bvvd.OptionSeq)
+	if hdr.Kind() != bvvd.Data || !has || len(seq) != 4 {
+		return false, nil
+	}
+
+	link, err := f.link(hdr, pkt, gaddr)
+	if err != nil {
+		return false, err
+	}
+	return link.Duplicate(binary.BigEndian.Uint32(seq)), nil
+}
+
+// send send transport layer payload of Data to server
+func (f *Forward) send(hdr bvvd.Bvvd, pkt *packet.Packet, gaddr netip.AddrPort, protected bool) error {
+	link, err := f.link(hdr, pkt, gaddr)
+	if err != nil {
+		return err
+	}
+	if protected {
+		link.EnableFEC()
+	}
+	return link.Send(pkt)
+}
+
+// link read/create corresponding link, the link will self-close by keepalive,
+// so if Send/Recv return net.ErrClosed error should ignore.
+func (f *Forward) link(hdr bvvd.Bvvd, pkt *packet.Packet, gaddr netip.AddrPort) (*links.Link, error) {
+	// only get port, tcp/udp is same
+	ep := links.NewEP(hdr, header.TCP(pkt.Bytes()))
+
+	link, new, err := f.links.Link(ep, gaddr, f.faddr)
+	if err != nil {
+		return nil, err
+	} else if new {
+		f.config.logger.Info("new link", slog.String("endpoint", ep.String()))
+		f.service(func() error { return f.downlinkService(link) })
+	}
+	return link, nil
+}
+
+// Duplicates count of dropped duplicate uplink Data
+func (f *Forward) Duplicates() uint64 { return f.links.Duplicates() }
+
+// Gateways current gateways count, idle gateway is evicted after keepalive
+func (f *Forward) Gateways() int { return f.ps.Len() }
+
 func (f *Forward) downlinkService(link *links.Link) (_ error) {
 	var (
-		pkt = packet.Make(f.config.MaxRecvBuffSize)
+		pkt  = packet.Make(f.config.MaxRecvBuffSize)
+		hdr  = link.Header()
+		flow = fec.Flow{Peer: hdr.Client, Server: hdr.Server, Proto: hdr.Proto}
 	)
 
 	for {
 		if err := link.Recv(pkt.Sets(64, 0xffff)); err != nil {
 			if errors.Is(err, net.ErrClosed) {
-				f.config.logger.Info("del link", slog.String("endpoint", link.Endpoint().String()))
+				f.config.logger.Info("del link",
+					slog.String("endpoint", link.Endpoint().String()),
+					slog.Uint64("duplicates", link.Duplicates()),
+				)
 				return nil // close by keepalive
 			} else if errorx.Temporary(err) {
 				f.config.logger.Warn(err.Error(), errorx.Trace(err))
@@ -208,25 +501,60 @@ func (f *Forward) downlinkService(link *links.Link) (_ error) {
 			}
 		}
 
-		id := f.ps.Gateway(link.Gateway()).DownlinkID()
-		bvvd.Bvvd(pkt.Bytes()).SetDataID(id)
+		f.traffic.Downlink(bvvd.Data, pkt.Data())
+
+		g := f.ps.Gateway(link.Gateway())
+		var info fec.Info
+		var parity []byte
+		if link.FEC() {
+			pkt.DetachN(bvvd.Bvvd(pkt.Bytes()).Len())
+			// assume pack loss between forward and gateway is symmetric
+			info, parity = f.encoders.Encoder(flow, g.PeekUplinkPL).Encode(pkt.Bytes())
 
-		if debug.Debug() && rand.Int()%100 == 99 {
-			continue // PackLossGatewayDownlink
+			var fields = hdr
+			if info.Size > 0 {
+				fields.Version, fields.Options = bvvd.Version, bvvd.Options{info.Option()}
+			}
+			if err := fields.Encode(pkt); err != nil {
+				return f.close(err)
+			}
 		}
 
-		if err := f.conn.WriteToAddrPort(pkt, link.Gateway()); err != nil {
-			return f.close(err)
+		bvvd.Bvvd(pkt.Bytes()).SetDataID(g.DownlinkID())
+		lost := debug.Debug() && rand.Int()%100 == 99 // PackLossGatewayDownlink
+		if !lost {
+			if err := f.conn.WriteToAddrPort(pkt, link.Gateway()); err != nil {
+				return f.close(err)
+			}
+		}
+
+		if parity != nil {
+			var fields = hdr
+			fields.Kind = bvvd.Parity
+			fields.Version, fields.Options = bvvd.Version, bvvd.Options{info.Parity().Option()}
+
+			ppkt := packet.Make(64, 0, len(parity)).Append(parity...)
+			if err := fields.Encode(ppkt); err != nil {
+				return f.close(err)
+			}
+			bvvd.Bvvd(ppkt.Bytes()).SetDataID(g.DownlinkID())
+			if err := f.conn.WriteToAddrPort(ppkt, link.Gateway()); err != nil {
+				return f.close(err)
+			}
 		}
 	}
 }
 
 func (f *Forward) pingService() (_ error) {
-	for e := range f.pingCh {
-		err := f.conn.WriteToAddrPort(packet.From(e.Msg), e.Gaddr)
-		if err != nil {
-			return f.close(err)
+	for {
+		select {
+		case <-f.done:
+			return nil
+		case e := <-f.pingCh:
+			err := f.conn.WriteToAddrPort(packet.From(e.Msg), e.Gaddr)
+			if err != nil {
+				return f.close(err)
+			}
 		}
 	}
-	return nil
 }
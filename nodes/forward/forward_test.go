@@ -4,10 +4,19 @@
 package forward_test
 
 import (
+	"context"
 	"fmt"
+	"net/netip"
+	"path/filepath"
 	"testing"
+	"time"
 
+	"github.com/jftuga/geodist"
+	"github.com/lysShub/anton-planet-accelerator/bvvd"
 	"github.com/lysShub/anton-planet-accelerator/nodes/forward"
+	"github.com/lysShub/anton-planet-accelerator/nodes/inspect"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/geo"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/shutdown"
 	"github.com/lysShub/netkit/debug"
 	"github.com/stretchr/testify/require"
 )
@@ -22,6 +31,63 @@ func TestXxxx(t *testing.T) {
 	f, err := forward.New(":19986", config)
 	require.NoError(t, err)
 
-	err = f.Serve()
+	err = f.Serve(context.Background())
 	require.NoError(t, err)
 }
+
+func Test_Shutdown(t *testing.T) {
+	shutdown.Require(t, func() (*forward.Forward, error) {
+		return forward.New("127.0.0.1:0", &forward.Config{
+			MaxRecvBuffSize: 1536,
+			PublicAddr:      netip.MustParseAddr("127.0.0.1"),
+			Location:        bvvd.Moscow,
+			MetricsAddr:     "127.0.0.1:0",
+		})
+	}, forward.Stopped)
+}
+
+type offline struct{ t *testing.T }
+
+func (o offline) Coord(addr netip.Addr) (geodist.Coord, error) {
+	o.t.Errorf("lookup location of %s", addr)
+	return geodist.Coord{}, geo.ErrNotFound
+}
+
+func Test_Declared(t *testing.T) {
+	// declared public address and location, not access network
+	f, err := forward.New("127.0.0.1:0", &forward.Config{
+		MaxRecvBuffSize: 1536,
+		PublicAddr:      netip.MustParseAddr("127.0.0.1"),
+		STUNServers:     []netip.AddrPort{netip.MustParseAddrPort("192.0.2.1:3478")},
+		Location:        bvvd.Moscow,
+		GeoProvider:     offline{t},
+	})
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+}
+
+func Test_Inspect(t *testing.T) {
+	addr := "unix:" + filepath.Join(t.TempDir(), "forward.sock")
+	f, err := forward.New("127.0.0.1:0", &forward.Config{
+		MaxRecvBuffSize: 1536,
+		PublicAddr:      netip.MustParseAddr("127.0.0.1"),
+		Location:        bvvd.Moscow,
+		InspectAddr:     addr,
+	})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go f.Serve(ctx)
+	time.Sleep(time.Millisecond * 100)
+
+	for _, path := range []string{"/links", "/gateways"} {
+		body, err := inspect.Get(addr, path)
+		require.NoError(t, err)
+		require.JSONEq(t, `[]`, string(body))
+	}
+
+	_, err = inspect.Get(addr, "/links/tcp/1234")
+	require.ErrorContains(t, err, "not found")
+	_, err = inspect.Get(addr, "/gateways/invalid")
+	require.ErrorContains(t, err, "invalid gateway address")
+}
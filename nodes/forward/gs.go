@@ -4,6 +4,7 @@ import (
 	"net/netip"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/lysShub/anton-planet-accelerator/bvvd"
 	"github.com/lysShub/anton-planet-accelerator/nodes"
@@ -11,15 +12,21 @@ import (
 )
 
 type Gateways struct {
-	mu sync.RWMutex
-	ps map[netip.AddrPort]*Gateway
-	// todo: keepalive
+	mu     sync.RWMutex
+	ps     map[netip.AddrPort]*Gateway
+	timer  *time.Timer
+	closed bool
 }
 
 func NewGateways() *Gateways {
-	return &Gateways{
+	var ps = &Gateways{
 		ps: map[netip.AddrPort]*Gateway{},
 	}
+
+	ps.mu.Lock()
+	ps.timer = time.AfterFunc(nodes.Keepalive, ps.keepalive)
+	ps.mu.Unlock()
+	return ps
 }
 
 func (ps *Gateways) Gateway(gaddr netip.AddrPort) *Gateway {
@@ -28,15 +35,67 @@ func (ps *Gateways) Gateway(gaddr netip.AddrPort) *Gateway {
 	ps.mu.RUnlock()
 
 	if p == nil {
-		p = &Gateway{uplinkPL: stats.NewPLStats(bvvd.MaxID)}
 		ps.mu.Lock()
-		ps.ps[gaddr] = p
+		if p = ps.ps[gaddr]; p == nil {
+			p = &Gateway{uplinkPL: stats.NewPLStats(bvvd.MaxID)}
+			ps.ps[gaddr] = p
+		}
 		ps.mu.Unlock()
 	}
+	p.alive.Add(1)
 	return p
 }
 
+// Len current gateways count
+func (ps *Gateways) Len() int {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	return len(ps.ps)
+}
+
+// Range call fn for each gateway, fn should not call method of ps
+func (ps *Gateways) Range(fn func(gaddr netip.AddrPort, p *Gateway)) {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	for k, e := range ps.ps {
+		fn(k, e)
+	}
+}
+
+// keepalive evict gateways without traffic during keepalive period
+func (ps *Gateways) keepalive() {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.closed {
+		return
+	}
+
+	for k, e := range ps.ps {
+		if e.alive.Swap(0) == 0 {
+			delete(ps.ps, k)
+			e.uplinkPL.Close()
+		}
+	}
+	ps.timer.Reset(nodes.Keepalive)
+}
+
+// Close stop keepalive and release all gateways
+func (ps *Gateways) Close() error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	ps.closed = true
+	ps.timer.Stop()
+	for _, e := range ps.ps {
+		e.uplinkPL.Close()
+	}
+	clear(ps.ps)
+	return nil
+}
+
 type Gateway struct {
+	alive atomic.Uint32
+
 	uplinkPL   *stats.PLStats
 	downlinkID atomic.Uint32
 }
@@ -45,10 +104,17 @@ func (p *Gateway) UplinkID(id uint8) {
 	p.uplinkPL.ID(int(id))
 }
 
+// UplinkPL pack loss of current window, reset the window, should only be
+// called by the pack loss reply
 func (p *Gateway) UplinkPL() stats.PL {
 	return stats.PL(p.uplinkPL.PL(nodes.PLScale))
 }
 
+// PeekUplinkPL pack loss without reset the window
+func (p *Gateway) PeekUplinkPL() stats.PL {
+	return p.uplinkPL.Peek(nodes.PLScale)
+}
+
 func (p *Gateway) DownlinkID() uint8 {
 	return uint8(p.downlinkID.Add(1) - 1)
 }
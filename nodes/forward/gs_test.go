@@ -0,0 +1,36 @@
+package forward
+
+import (
+	"net/netip"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_GatewaysKeepalive(t *testing.T) {
+	var (
+		ps     = NewGateways()
+		gaddr1 = netip.MustParseAddrPort("1.1.1.1:19986")
+		gaddr2 = netip.MustParseAddrPort("2.2.2.2:19986")
+	)
+
+	g1 := ps.Gateway(gaddr1)
+	require.Same(t, g1, ps.Gateway(gaddr1))
+	ps.Gateway(gaddr2)
+	require.Equal(t, 2, ps.Len())
+
+	// both alive in the first period
+	ps.keepalive()
+	require.Equal(t, 2, ps.Len())
+
+	ps.Gateway(gaddr1)
+	ps.keepalive()
+	require.Equal(t, 1, ps.Len())
+
+	ps.keepalive()
+	require.Equal(t, 0, ps.Len())
+
+	// re-create after evicted
+	require.NotSame(t, g1, ps.Gateway(gaddr1))
+	require.Equal(t, 1, ps.Len())
+}
@@ -0,0 +1,133 @@
+//go:build linux
+// +build linux
+
+package forward
+
+import (
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"syscall"
+
+	"github.com/lysShub/anton-planet-accelerator/nodes/forward/links"
+	"github.com/lysShub/anton-planet-accelerator/nodes/inspect"
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+type LinkInfo struct {
+	Client      netip.AddrPort `json:"client"`
+	Proto       string         `json:"proto"`
+	ProcessPort uint16         `json:"process_port"`
+	Server      netip.AddrPort `json:"server"`
+	Gateway     netip.AddrPort `json:"gateway"`
+	LocalPort   uint16         `json:"local_port"`
+	Idle        string         `json:"idle"`
+	FEC         bool           `json:"fec"`
+	Duplicates  uint64         `json:"duplicates"`
+}
+
+type GatewayInfo struct {
+	Addr     netip.AddrPort `json:"addr"`
+	UplinkPL float64        `json:"uplink_pl"`
+	Links    []LinkInfo     `json:"links,omitempty"` // only for detail
+}
+
+// Links get current links
+func (f *Forward) Links() []LinkInfo {
+	ls := f.links.List()
+	var infos = make([]LinkInfo, 0, len(ls))
+	for _, l := range ls {
+		infos = append(infos, linkInfo(l))
+	}
+	slices.SortFunc(infos, func(a, b LinkInfo) int {
+		if n := a.Client.Compare(b.Client); n != 0 {
+			return n
+		} else if n = a.Server.Compare(b.Server); n != 0 {
+			return n
+		}
+		return int(a.LocalPort) - int(b.LocalPort)
+	})
+	return infos
+}
+
+func linkInfo(l *links.Link) LinkInfo {
+	ep := l.Endpoint()
+	return LinkInfo{
+		Client:      ep.Client(),
+		Proto:       protoName(ep.Proto()),
+		ProcessPort: ep.ProcessPort(),
+		Server:      ep.Server(),
+		Gateway:     l.Gateway(),
+		LocalPort:   l.LocalPort(),
+		Idle:        l.Idle().String(),
+		FEC:         l.FEC(),
+		Duplicates:  l.Duplicates(),
+	}
+}
+
+func protoName(proto tcpip.TransportProtocolNumber) string {
+	switch proto {
+	case syscall.IPPROTO_TCP:
+		return "tcp"
+	case syscall.IPPROTO_UDP:
+		return "udp"
+	default:
+		return strconv.Itoa(int(proto))
+	}
+}
+
+// GatewaysInfo get active gateways
+func (f *Forward) GatewaysInfo() []GatewayInfo {
+	var infos = []GatewayInfo{}
+	f.ps.Range(func(gaddr netip.AddrPort, p *Gateway) {
+		infos = append(infos, GatewayInfo{Addr: gaddr, UplinkPL: float64(p.PeekUplinkPL())})
+	})
+	slices.SortFunc(infos, func(a, b GatewayInfo) int { return a.Addr.Compare(b.Addr) })
+	return infos
+}
+
+// inspectHandler read-only api of live state
+func (f *Forward) inspectHandler() http.Handler {
+	var mux = http.NewServeMux()
+	mux.HandleFunc("GET /links", func(w http.ResponseWriter, r *http.Request) {
+		inspect.JSON(w, f.Links())
+	})
+	mux.HandleFunc("GET /links/{proto}/{port}", func(w http.ResponseWriter, r *http.Request) {
+		port, err := strconv.ParseUint(r.PathValue("port"), 10, 16)
+		if err != nil {
+			inspect.Error(w, http.StatusBadRequest, "invalid port %q", r.PathValue("port"))
+			return
+		}
+		for _, e := range f.Links() {
+			if e.Proto == r.PathValue("proto") && e.LocalPort == uint16(port) {
+				inspect.JSON(w, e)
+				return
+			}
+		}
+		inspect.Error(w, http.StatusNotFound, "link %s/%d not found", r.PathValue("proto"), port)
+	})
+	mux.HandleFunc("GET /gateways", func(w http.ResponseWriter, r *http.Request) {
+		inspect.JSON(w, f.GatewaysInfo())
+	})
+	mux.HandleFunc("GET /gateways/{addr}", func(w http.ResponseWriter, r *http.Request) {
+		gaddr, err := netip.ParseAddrPort(r.PathValue("addr"))
+		if err != nil {
+			inspect.Error(w, http.StatusBadRequest, "invalid gateway address %q", r.PathValue("addr"))
+			return
+		}
+		for _, e := range f.GatewaysInfo() {
+			if e.Addr == gaddr {
+				for _, l := range f.Links() {
+					if l.Gateway == gaddr {
+						e.Links = append(e.Links, l)
+					}
+				}
+				inspect.JSON(w, e)
+				return
+			}
+		}
+		inspect.Error(w, http.StatusNotFound, "gateway %s not found", gaddr.String())
+	})
+	return mux
+}
@@ -6,6 +6,7 @@ package links
 import (
 	"net"
 	"net/netip"
+	"sync"
 	"sync/atomic"
 	"syscall"
 	"time"
@@ -13,6 +14,7 @@ import (
 	"github.com/lysShub/anton-planet-accelerator/bvvd"
 	"github.com/lysShub/anton-planet-accelerator/nodes"
 	"github.com/lysShub/anton-planet-accelerator/nodes/internal/checksum"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/stats"
 	"github.com/lysShub/netkit/debug"
 	"github.com/lysShub/netkit/errorx"
 	"github.com/lysShub/netkit/packet"
@@ -28,8 +30,15 @@ type Link struct {
 	links *Links
 	lis   listener // occupy local port
 
-	raw   *net.IPConn
-	alive atomic.Uint32
+	raw    *net.IPConn
+	alive  atomic.Uint32
+	active atomic.Int64 // unix nano of last traffic
+	timer  *time.Timer
+	fec    atomic.Bool
+
+	dedupMu sync.Mutex
+	dedup   *stats.Window
+	dups    atomic.Uint64
 
 	ep     Endpoint
 	gaddr  netip.AddrPort
@@ -57,11 +66,16 @@ func newLink(links *Links, link Endpoint, gaddr, faddr netip.AddrPort) (*Link, e
 		err error
 	)
 
+	var family = "4"
+	if link.Is6() {
+		family = "6"
+	}
+
 	switch link.proto {
 	case syscall.IPPROTO_TCP:
-		l.lis, err = net.ListenTCP("tcp4", nil)
+		l.lis, err = net.ListenTCP("tcp"+family, nil)
 	case syscall.IPPROTO_UDP:
-		l.lis, err = wrapUDPLister(net.ListenUDP("udp4", nil))
+		l.lis, err = wrapUDPLister(net.ListenUDP("udp"+family, nil))
 	default:
 		return nil, errors.Errorf("unknown protocol %d", link.proto)
 	}
@@ -74,17 +88,18 @@ func newLink(links *Links, link Endpoint, gaddr, faddr netip.AddrPort) (*Link, e
 	}
 	locPort := netip.MustParseAddrPort(l.lis.Addr().String()).Port()
 
-	network := "ip4:" + l.LocalAddr().Network()
+	network := "ip" + family + ":" + l.LocalAddr().Network()
 	l.raw, err = net.DialIP(network, nil, &net.IPAddr{IP: link.server.Addr().AsSlice()})
 	if err != nil {
 		return nil, l.close(errors.WithStack(err))
 	}
 	l.laddr = netip.AddrPortFrom(netip.MustParseAddr(l.raw.LocalAddr().String()), locPort)
-	if err := bpfFilterPort(l.raw, l.ep.server.Port(), locPort); err != nil {
+	if err := bpfFilterPort(l.raw, link.Is6(), l.ep.server.Port(), locPort); err != nil {
 		return nil, l.close(err)
 	}
 
-	time.AfterFunc(nodes.Keepalive, l.keepalive)
+	l.active.Store(time.Now().UnixNano())
+	l.timer = time.AfterFunc(nodes.Keepalive, l.keepalive)
 	return l, nil
 }
 
@@ -92,6 +107,9 @@ func (l *Link) close(cause error) error {
 	cause = errors.WithStack(cause)
 	return l.closeErr.Close(func() (errs []error) {
 		errs = append(errs, cause)
+		if l.timer != nil {
+			l.timer.Stop()
+		}
 		if l.raw != nil {
 			errs = append(errs, l.raw.Close())
 		}
@@ -108,17 +126,20 @@ func (l *Link) keepalive() {
 	if l.alive.Swap(0) == 0 {
 		l.close(nil)
 	} else {
-		time.AfterFunc(nodes.Keepalive, l.keepalive)
+		l.timer.Reset(nodes.Keepalive)
 	}
 }
 
 func (l *Link) Recv(pkt *packet.Packet) error {
 	n, _, err := l.raw.ReadFrom(pkt.Bytes())
 	if err != nil {
-		return l.close(err)
+		// return read error, that is net.ErrClosed if closed by keepalive
+		l.close(err)
+		return errors.WithStack(err)
 	}
 	pkt.SetData(n)
 	l.alive.Add(1)
+	l.active.Store(time.Now().UnixNano())
 
 	hdr := header.TCP(pkt.Bytes())
 	if debug.Debug() {
@@ -134,8 +155,8 @@ func (l *Link) Send(pkt *packet.Packet) error {
 	if debug.Debug() {
 		sum := header.PseudoHeaderChecksum(
 			tcpip.TransportProtocolNumber(l.ep.proto),
-			tcpip.AddrFrom4(l.laddr.Addr().As4()),
-			tcpip.AddrFrom4(netip.MustParseAddr(l.raw.RemoteAddr().String()).As4()),
+			tcpip.AddrFromSlice(l.laddr.Addr().AsSlice()),
+			tcpip.AddrFromSlice(netip.MustParseAddr(l.raw.RemoteAddr().String()).AsSlice()),
 			uint16(pkt.Data()),
 		)
 		sum = stdsum.Checksum(pkt.Bytes(), sum)
@@ -143,12 +164,47 @@ func (l *Link) Send(pkt *packet.Packet) error {
 	}
 
 	l.alive.Add(1)
+	l.active.Store(time.Now().UnixNano())
 	_, err := l.raw.Write(pkt.Bytes())
 	if err != nil {
-		return l.close(errors.WithStack(err))
+		l.close(err)
+		return errors.WithStack(err)
 	}
 	return nil
 }
+
+// EnableFEC enable fec of downlink, when uplink is fec protected
+func (l *Link) EnableFEC()          { l.fec.Store(true) }
+func (l *Link) FEC() bool           { return l.fec.Load() }
+func (l *Link) Header() bvvd.Fields { return l.header }
+
+// Duplicate record uplink seq, return true if the seq already received. seq
+// is only carried by play data of redundant mode, that transmit through
+// multiple gateways, other data is not deduplicated.
+func (l *Link) Duplicate(seq uint32) bool {
+	l.dedupMu.Lock()
+	if l.dedup == nil {
+		l.dedup = stats.NewWindow()
+	}
+	dup := l.dedup.Duplicate(seq)
+	l.dedupMu.Unlock()
+
+	if dup {
+		l.dups.Add(1)
+		l.links.dups.Add(1)
+	}
+	return dup
+}
+
+// Duplicates count of dropped duplicate uplink packets
+func (l *Link) Duplicates() uint64 { return l.dups.Load() }
+
 func (l *Link) Endpoint() Endpoint      { return l.ep }
 func (l *Link) Gateway() netip.AddrPort { return l.gaddr }
 func (l *Link) LocalAddr() net.Addr     { return l.lis.Addr() }
+func (l *Link) LocalPort() uint16       { return l.laddr.Port() }
+
+// Idle duration since last traffic
+func (l *Link) Idle() time.Duration {
+	return time.Duration(time.Now().UnixNano() - l.active.Load())
+}
@@ -5,8 +5,10 @@ package links
 
 import (
 	"fmt"
+	"net"
 	"net/netip"
 	"sync"
+	"sync/atomic"
 
 	"github.com/lysShub/anton-planet-accelerator/bvvd"
 	"gvisor.dev/gvisor/pkg/tcpip"
@@ -14,8 +16,11 @@ import (
 )
 
 type Links struct {
-	mu    sync.RWMutex
-	links map[Endpoint]*Link
+	mu     sync.RWMutex
+	links  map[Endpoint]*Link
+	closed bool
+
+	dups atomic.Uint64
 }
 
 func NewLinks() *Links {
@@ -33,6 +38,10 @@ func (ls *Links) Link(ep Endpoint, gaddr, faddr netip.AddrPort) (l *Link, new bo
 			return nil, false, err
 		}
 		ls.mu.Lock()
+		if ls.closed {
+			ls.mu.Unlock()
+			return nil, false, l.close(net.ErrClosed)
+		}
 		ls.links[ep] = l
 		ls.mu.Unlock()
 		new = true
@@ -40,20 +49,49 @@ func (ls *Links) Link(ep Endpoint, gaddr, faddr netip.AddrPort) (l *Link, new bo
 	return l, new, nil
 }
 
+// List get current links
+func (ls *Links) List() []*Link {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+
+	var links = make([]*Link, 0, len(ls.links))
+	for _, e := range ls.links {
+		links = append(links, e)
+	}
+	return links
+}
+
+// Len current links count
+func (ls *Links) Len() int {
+	ls.mu.RLock()
+	defer ls.mu.RUnlock()
+	return len(ls.links)
+}
+
+// Duplicates count of dropped duplicate uplink packets of all links, include closed
+func (ls *Links) Duplicates() uint64 { return ls.dups.Load() }
+
 func (ls *Links) del(ep Endpoint) {
 	ls.mu.Lock()
 	defer ls.mu.Unlock()
 	delete(ls.links, ep)
 }
 
+// Close stop create new link and close all links, downlink of link
+// will return net.ErrClosed
 func (ls *Links) Close() error {
 	ls.mu.Lock()
-	defer ls.mu.Unlock()
-
+	ls.closed = true
+	var links = make([]*Link, 0, len(ls.links))
 	for _, e := range ls.links {
+		links = append(links, e)
+	}
+	ls.mu.Unlock()
+
+	// link close will del self
+	for _, e := range links {
 		e.close(nil)
 	}
-	clear(ls.links)
 	return nil
 }
 
@@ -73,6 +111,16 @@ func NewEP(hdr bvvd.Bvvd, t header.Transport) Endpoint {
 	}
 }
 
+func (e Endpoint) Client() netip.AddrPort               { return e.client }
+func (e Endpoint) Proto() tcpip.TransportProtocolNumber { return e.proto }
+func (e Endpoint) ProcessPort() uint16                  { return e.processPort }
+func (e Endpoint) Server() netip.AddrPort               { return e.server }
+
+// Is6 server is ipv6 address
+func (e Endpoint) Is6() bool {
+	return e.server.Addr().Is6()
+}
+
 func (e Endpoint) String() string {
 	return fmt.Sprintf(
 		"{Client:%s,Proto:%d,ProcessPort:%d,Server:%s}",
@@ -0,0 +1,42 @@
+//go:build linux
+// +build linux
+
+package links
+
+import (
+	"net"
+	"net/netip"
+	"syscall"
+	"testing"
+
+	"github.com/lysShub/netkit/packet"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_LinksClose(t *testing.T) {
+	var (
+		ls    = NewLinks()
+		gaddr = netip.MustParseAddrPort("127.0.0.1:19986")
+		faddr = netip.MustParseAddrPort("127.0.0.1:19987")
+		ep    = Endpoint{
+			client:      netip.MustParseAddrPort("127.0.0.2:1234"),
+			proto:       syscall.IPPROTO_UDP,
+			processPort: 5678,
+			server:      netip.MustParseAddrPort("127.0.0.3:53"),
+		}
+	)
+
+	l, new, err := ls.Link(ep, gaddr, faddr)
+	require.NoError(t, err)
+	require.True(t, new)
+
+	var recved = make(chan error, 1)
+	go func() { recved <- l.Recv(packet.Make(0, 1536)) }()
+
+	require.NoError(t, ls.Close())
+	require.True(t, errors.Is(<-recved, net.ErrClosed))
+
+	_, _, err = ls.Link(ep, gaddr, faddr)
+	require.True(t, errors.Is(err, net.ErrClosed))
+}
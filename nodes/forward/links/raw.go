@@ -41,23 +41,39 @@ func bpfFilterAll(raw raw) error {
 	return tcp.SetRawBPF(raw, []bpf.Instruction{bpf.RetConstant{Val: 0}})
 }
 
-func bpfFilterPort(raw raw, srcPort, dstPort uint16) error {
+func bpfFilterPort(raw raw, is6 bool, srcPort, dstPort uint16) error {
 	const SrcPortOffset = header.TCPSrcPortOffset // tcp/udp is same
 	const DstPortOffset = header.TCPDstPortOffset
 
-	var ins = []bpf.Instruction{
-		// store IPv4HdrLen regX
-		bpf.LoadMemShift{Off: 0},
-
-		bpf.LoadIndirect{Off: SrcPortOffset, Size: 2},
-		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(srcPort), SkipTrue: 1},
-		bpf.RetConstant{Val: 0},
-
-		bpf.LoadIndirect{Off: DstPortOffset, Size: 2},
-		bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(dstPort), SkipTrue: 1},
-		bpf.RetConstant{Val: 0},
-
-		bpf.RetConstant{Val: 0xffff},
+	var ins []bpf.Instruction
+	if is6 {
+		// ipv6 raw socket not include ip header
+		ins = []bpf.Instruction{
+			bpf.LoadAbsolute{Off: SrcPortOffset, Size: 2},
+			bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(srcPort), SkipTrue: 1},
+			bpf.RetConstant{Val: 0},
+
+			bpf.LoadAbsolute{Off: DstPortOffset, Size: 2},
+			bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(dstPort), SkipTrue: 1},
+			bpf.RetConstant{Val: 0},
+
+			bpf.RetConstant{Val: 0xffff},
+		}
+	} else {
+		ins = []bpf.Instruction{
+			// store IPv4HdrLen regX
+			bpf.LoadMemShift{Off: 0},
+
+			bpf.LoadIndirect{Off: SrcPortOffset, Size: 2},
+			bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(srcPort), SkipTrue: 1},
+			bpf.RetConstant{Val: 0},
+
+			bpf.LoadIndirect{Off: DstPortOffset, Size: 2},
+			bpf.JumpIf{Cond: bpf.JumpEqual, Val: uint32(dstPort), SkipTrue: 1},
+			bpf.RetConstant{Val: 0},
+
+			bpf.RetConstant{Val: 0xffff},
+		}
 	}
 
 	return tcp.SetRawBPF(raw, ins)
@@ -0,0 +1,57 @@
+//go:build linux
+// +build linux
+
+package forward
+
+import (
+	"net/netip"
+	"slices"
+
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/metrics"
+)
+
+// dropped counters of dropped packets, by reason
+type dropped struct {
+	tooSmall    *metrics.Counter
+	unknownKind *metrics.Counter
+}
+
+func (f *Forward) initMetrics() {
+	var m = metrics.New()
+	f.metrics = m
+	f.traffic = metrics.NewTraffic(m, "anton_forward")
+
+	d := m.CounterVec("anton_forward_dropped_packets_total", "dropped packets by reason", "reason")
+	f.dropped = dropped{
+		tooSmall:    d.With("too_small"),
+		unknownKind: d.With("unknown_kind"),
+	}
+
+	m.CounterFunc("anton_forward_duplicates_total", "dropped duplicate uplink Data", f.links.Duplicates)
+	m.CounterFunc("anton_forward_pinger_cache_hits_total", "ping server replied by cached rtt", func() uint64 {
+		if f.pinger == nil {
+			return 0
+		}
+		return f.pinger.CacheHits()
+	})
+	m.Gauge("anton_forward_links", "active links", func() float64 {
+		return float64(f.links.Len())
+	})
+	m.Gauge("anton_forward_gateways", "active gateways", func() float64 {
+		return float64(f.ps.Len())
+	})
+	m.GaugeVec("anton_forward_gateway_uplink_pl", "pack loss of gateway to forward", []string{"gateway"}, func(add func(float64, ...string)) {
+		type pl struct {
+			gaddr netip.AddrPort
+			pl    float64
+		}
+		var pls []pl
+		f.ps.Range(func(gaddr netip.AddrPort, p *Gateway) {
+			pls = append(pls, pl{gaddr, float64(p.PeekUplinkPL())})
+		})
+		slices.SortFunc(pls, func(a, b pl) int { return a.gaddr.Compare(b.gaddr) })
+		for _, e := range pls {
+			add(e.pl, e.gaddr.String())
+		}
+	})
+}
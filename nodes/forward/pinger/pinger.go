@@ -28,11 +28,14 @@ type Pinger struct {
 
 	cacheMu sync.RWMutex
 	cache   map[netip.Addr]time.Duration
+	hits    atomic.Uint64
 
 	inflightMu sync.RWMutex
 	inflight   map[netip.Addr]*key
 
-	conn *icmp.PacketConn
+	conn  *icmp.PacketConn
+	conn6 *icmp.PacketConn // nil if ipv6 not available
+	recvs sync.WaitGroup   // recvService exited
 
 	closeErr errorx.CloseErr
 }
@@ -65,16 +68,26 @@ func NewPinger(replayChan chan<- Info, log *slog.Logger) (*Pinger, error) {
 
 	p.conn, err = icmp.ListenPacket("ip4:icmp", "0.0.0.0")
 	if err != nil {
-		return nil, err
+		return nil, p.close(err)
+	}
+	p.conn6, err = icmp.ListenPacket("ip6:ipv6-icmp", "::")
+	if err != nil {
+		p.conn6 = nil
+		log.Warn("ping ipv6 not available", slog.String("error", err.Error()))
 	}
 
-	go p.recvService()
+	p.recvs.Add(1)
+	go p.recvService(p.conn, false)
+	if p.conn6 != nil {
+		p.recvs.Add(1)
+		go p.recvService(p.conn6, true)
+	}
 	return p, nil
 }
 
 func (p *Pinger) close(cause error) error {
 	return p.closeErr.Close(func() (errs []error) {
-		if cause == nil {
+		if cause != nil {
 			p.log.Error(cause.Error(), errorx.Trace(cause))
 		} else {
 			p.log.Info("pinger close")
@@ -84,19 +97,25 @@ func (p *Pinger) close(cause error) error {
 		if p.conn != nil {
 			errs = append(errs, errors.WithStack(p.conn.Close()))
 		}
+		if p.conn6 != nil {
+			errs = append(errs, errors.WithStack(p.conn6.Close()))
+		}
+		once.Store(false)
 		return errs
 	})
 }
 
 func (p *Pinger) Ping(info Info) error {
-	if !info.Addr.Is4() {
-		return errors.Errorf("only support ipv4 %s", info.Addr.String())
+	info.Addr = info.Addr.Unmap()
+	if !info.Addr.IsValid() || (info.Addr.Is6() && p.conn6 == nil) {
+		return errorx.WrapTemp(errors.Errorf("not support ping %s", info.Addr.String()))
 	}
 
 	p.cacheMu.RLock()
 	rtt, has := p.cache[info.Addr]
 	p.cacheMu.RUnlock()
 	if has {
+		p.hits.Add(1)
 		info.RTT = rtt
 		p.buff <- info
 		return nil
@@ -119,17 +138,29 @@ func (p *Pinger) Ping(info Info) error {
 const size = header.ICMPv4PayloadOffset + 16
 
 func (p *Pinger) send(info Info) error {
-	var echo = make(header.ICMPv4, size)
-	echo.SetType(header.ICMPv4Echo)
-	echo.SetCode(0)
-	echo.SetIdent(uint16(rand.Uint32()))
-	echo.SetSequence(uint16(rand.Uint32()))
-	echo.SetChecksum(^checksum.Checksum(echo, 0))
-	if debug.Debug() {
-		require.Equal(test.T(), uint16(0xffff), checksum.Checksum(echo, 0))
+	var conn = p.conn
+	var echo = make([]byte, size)
+	if info.Addr.Is4() {
+		hdr := header.ICMPv4(echo)
+		hdr.SetType(header.ICMPv4Echo)
+		hdr.SetCode(0)
+		hdr.SetIdent(uint16(rand.Uint32()))
+		hdr.SetSequence(uint16(rand.Uint32()))
+		hdr.SetChecksum(^checksum.Checksum(hdr, 0))
+		if debug.Debug() {
+			require.Equal(test.T(), uint16(0xffff), checksum.Checksum(hdr, 0))
+		}
+	} else {
+		// checksum of icmpv6 is calculated by kernel
+		conn = p.conn6
+		hdr := header.ICMPv6(echo)
+		hdr.SetType(header.ICMPv6EchoRequest)
+		hdr.SetCode(0)
+		hdr.SetIdent(uint16(rand.Uint32()))
+		hdr.SetSequence(uint16(rand.Uint32()))
 	}
 
-	_, err := p.conn.WriteTo(echo, &net.IPAddr{IP: info.Addr.AsSlice()})
+	_, err := conn.WriteTo(echo, &net.IPAddr{IP: info.Addr.AsSlice()})
 	if err != nil {
 		return err
 	}
@@ -143,15 +174,26 @@ func (p *Pinger) send(info Info) error {
 	return nil
 }
 
-func (p *Pinger) recvService() (_ error) {
+// recvService receive echo reply of conn, icmpv6 socket also receive other
+// messages like neighbor discovery, so only echo reply of icmpv6 is accepted
+func (p *Pinger) recvService(conn *icmp.PacketConn, v6 bool) (_ error) {
+	defer p.recvs.Done()
+
 	var b = make([]byte, size+header.IPv4MinimumSize)
 	for i := uint8(0); ; i++ {
-		_, rip, err := p.conn.ReadFrom(b)
+		n, rip, err := conn.ReadFrom(b)
 		if err != nil {
+			if p.closeErr.Closed() {
+				return nil
+			}
 			return p.close(err)
 		}
+		if v6 && (n < header.ICMPv6EchoMinimumSize || header.ICMPv6(b).Type() != header.ICMPv6EchoReply) {
+			continue
+		}
 
-		addr := netip.AddrFrom4([4]byte(rip.(*net.IPAddr).IP))
+		addr, _ := netip.AddrFromSlice(rip.(*net.IPAddr).IP)
+		addr = addr.Unmap()
 
 		p.inflightMu.RLock()
 		k, has := p.inflight[addr]
@@ -187,4 +229,13 @@ func (p *Pinger) recvService() (_ error) {
 	}
 }
 
-func (p *Pinger) Close() error { return p.close(nil) }
+// CacheHits count of ping replied by cached rtt
+func (p *Pinger) CacheHits() uint64 { return p.hits.Load() }
+
+// Close close pinger and wait recvService exit, so replay chan will not be
+// written anymore
+func (p *Pinger) Close() error {
+	err := p.close(nil)
+	p.recvs.Wait()
+	return err
+}
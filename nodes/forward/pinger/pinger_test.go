@@ -36,6 +36,32 @@ func Test_Pinger(t *testing.T) {
 	fmt.Println(info1.RTT, info2.RTT)
 }
 
+func Test_Loopback(t *testing.T) {
+	var ch = make(chan Info, 16)
+	var p, err = NewPinger(ch, slog.Default())
+	if err != nil {
+		t.Skip(err)
+	}
+	defer p.Close()
+
+	for _, addr := range []string{"127.0.0.1", "::1"} {
+		err := p.Ping(Info{Addr: netip.MustParseAddr(addr)})
+		if p.conn6 == nil && addr == "::1" {
+			require.Error(t, err)
+			continue
+		}
+		require.NoError(t, err)
+
+		select {
+		case info := <-ch:
+			require.Equal(t, addr, info.Addr.String())
+			require.NotZero(t, info.RTT)
+		case <-time.After(time.Second * 3):
+			t.Fatal("ping timeout", addr)
+		}
+	}
+}
+
 func TestXxxx(t *testing.T) {
 
 	go func() {
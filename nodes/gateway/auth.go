@@ -0,0 +1,97 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Authenticator authenticate client session handshake token
+type Authenticator interface {
+	// Authenticate return the user of token, or error if token is invalid
+	Authenticate(token string) (user string, err error)
+}
+
+type fileAuth struct {
+	tokens map[string]string // token:user
+}
+
+// FileAuth load user tokens from file, each line is "user token", line
+// start with '#' is comment.
+func FileAuth(path string) (Authenticator, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer fh.Close()
+
+	var a = &fileAuth{tokens: map[string]string{}}
+	s := bufio.NewScanner(fh)
+	for i := 1; s.Scan(); i++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, errors.Errorf("%s:%d invalid line %q", path, i, line)
+		}
+		a.tokens[fields[1]] = fields[0]
+	}
+	return a, errors.WithStack(s.Err())
+}
+
+func (a *fileAuth) Authenticate(token string) (string, error) {
+	user, has := a.tokens[token]
+	if !has {
+		return "", errors.New("invalid token")
+	}
+	return user, nil
+}
+
+type httpAuth struct {
+	url    string
+	client *http.Client
+}
+
+// HTTPAuth authenticate token by http service, post {"token":"xxx"} to url,
+// response status 200 with {"user":"xxx"} means authenticated.
+func HTTPAuth(url string) Authenticator {
+	return &httpAuth{
+		url:    url,
+		client: &http.Client{Timeout: time.Second * 3},
+	}
+}
+
+func (a *httpAuth) Authenticate(token string) (string, error) {
+	req, err := json.Marshal(map[string]string{"token": token})
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	resp, err := a.client.Post(a.url, "application/json", bytes.NewReader(req))
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("http code %d", resp.StatusCode)
+	}
+
+	var ret = struct {
+		User string
+	}{}
+	if err := json.NewDecoder(resp.Body).Decode(&ret); err != nil {
+		return "", errors.WithStack(err)
+	} else if ret.User == "" {
+		return "", errors.New("invalid response, require user")
+	}
+	return ret.User, nil
+}
@@ -0,0 +1,58 @@
+package gateway_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lysShub/anton-planet-accelerator/nodes/gateway"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_FileAuth(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens")
+	require.NoError(t, os.WriteFile(path, []byte("# user token\nalice  t0ken-a\n\nbob t0ken-b\n"), 0o666))
+
+	auth, err := gateway.FileAuth(path)
+	require.NoError(t, err)
+
+	user, err := auth.Authenticate("t0ken-b")
+	require.NoError(t, err)
+	require.Equal(t, "bob", user)
+
+	_, err = auth.Authenticate("bob")
+	require.Error(t, err)
+}
+
+func Test_HTTPAuth(t *testing.T) {
+	var tokens = make(chan string, 2)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// handler run in server goroutine, can't call require
+		var req struct{ Token string }
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		tokens <- req.Token
+		if req.Token != "t0ken" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"user": "alice"})
+	}))
+	defer srv.Close()
+
+	auth := gateway.HTTPAuth(srv.URL)
+
+	user, err := auth.Authenticate("t0ken")
+	require.NoError(t, err)
+	require.Equal(t, "alice", user)
+	require.Equal(t, "t0ken", <-tokens)
+
+	_, err = auth.Authenticate("invalid")
+	require.Error(t, err)
+	require.Equal(t, "invalid", <-tokens)
+}
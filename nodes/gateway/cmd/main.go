@@ -4,31 +4,102 @@
 package main
 
 import (
+	"context"
+	"crypto/ecdh"
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
 	"fmt"
+	"net"
 	"net/netip"
+	"os"
+	"os/signal"
+	"slices"
+	"strings"
+	"syscall"
 	"time"
 
+	"github.com/lysShub/anton-planet-accelerator/bvvd"
+	"github.com/lysShub/anton-planet-accelerator/nodes"
+	"github.com/lysShub/anton-planet-accelerator/nodes/admin"
 	"github.com/lysShub/anton-planet-accelerator/nodes/gateway"
-	"github.com/lysShub/netkit/debug"
-	"github.com/lysShub/rawsock/test"
-	"github.com/stretchr/testify/require"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/config"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/geo"
+	"github.com/pkg/errors"
 )
 
+type Config struct {
+	Listen           string   `json:"listen" yaml:"listen" toml:"listen" flag:"listen" env:"LISTEN" usage:"listen address"`
+	MaxRecvBuff      int      `json:"max_recv_buff" yaml:"max_recv_buff" toml:"max_recv_buff" flag:"max-recv-buff" env:"MAX_RECV_BUFF" usage:"max receive buffer size"`
+	LogPath          string   `json:"log_path" yaml:"log_path" toml:"log_path" flag:"log" env:"LOG_PATH" usage:"log file path, empty is stdout"`
+	PcapBuiltinPath  string   `json:"pcap_builtin_path" yaml:"pcap_builtin_path" toml:"pcap_builtin_path" flag:"pcap" env:"PCAP_BUILTIN_PATH" usage:"pcap file path of builtin traffic"`
+	Forwards         []string `json:"forwards" yaml:"forwards" toml:"forwards" flag:"forwards" env:"FORWARDS" usage:"forward addresses, comma separated, location can be declared by addr@location"`
+	AuthFile         string   `json:"auth_file" yaml:"auth_file" toml:"auth_file" flag:"auth-file" env:"AUTH_FILE" usage:"token file of session authentication"`
+	AuthURL          string   `json:"auth_url" yaml:"auth_url" toml:"auth_url" flag:"auth-url" env:"AUTH_URL" usage:"http url of session authentication"`
+	MAC              bool     `json:"mac" yaml:"mac" toml:"mac" flag:"mac" env:"MAC" usage:"require mac of authenticated session"`
+	GeoDB            string   `json:"geo_db" yaml:"geo_db" toml:"geo_db" flag:"geo-db" env:"GEO_DB" usage:"geolocation database, .mmdb or .csv"`
+	GeoHTTP          bool     `json:"geo_http" yaml:"geo_http" toml:"geo_http" flag:"geo-http" env:"GEO_HTTP" usage:"lookup geolocation by http when not found in database"`
+	Seal             bool     `json:"seal" yaml:"seal" toml:"seal" flag:"seal" env:"SEAL" usage:"encrypt traffic of authenticated session"`
+	SessionKey       string   `json:"session_key" yaml:"session_key" toml:"session_key" flag:"session-key" env:"SESSION_KEY" usage:"x25519 private key pem file of session handshake, generated by openssl genpkey -algorithm x25519, required by mac and seal"`
+	Controller       string   `json:"controller" yaml:"controller" toml:"controller" flag:"controller" env:"CONTROLLER" usage:"controller address, forwards will be pushed by controller"`
+	ControllerCA     string   `json:"controller_ca" yaml:"controller_ca" toml:"controller_ca" flag:"controller-ca" env:"CONTROLLER_CA" usage:"ca certificate file of controller"`
+	Cert             string   `json:"cert" yaml:"cert" toml:"cert" flag:"cert" env:"CERT" usage:"certificate file issued by ca, common name is the registered name"`
+	Key              string   `json:"key" yaml:"key" toml:"key" flag:"key" env:"KEY" usage:"private key file of certificate"`
+	Location         string   `json:"location" yaml:"location" toml:"location" flag:"location" env:"LOCATION" usage:"declared location registered to controller, optional"`
+	PublicAddr       string   `json:"public_addr" yaml:"public_addr" toml:"public_addr" flag:"public-addr" env:"PUBLIC_ADDR" usage:"public ip address registered to controller, empty will use remote address seen by controller"`
+	Metrics          string   `json:"metrics" yaml:"metrics" toml:"metrics" flag:"metrics" env:"METRICS" usage:"prometheus metrics http listen address, empty is disabled"`
+	Inspect          string   `json:"inspect" yaml:"inspect" toml:"inspect" flag:"inspect" env:"INSPECT" usage:"read-only json api listen address, loopback address or unix:path, empty is disabled"`
+	Transports       []string `json:"transports" yaml:"transports" toml:"transports" flag:"transports" env:"TRANSPORTS" usage:"listen transports of clients on the same port, udp or tcp(fake tcp), comma separated"`
+	ForwardTransport string   `json:"forward_transport" yaml:"forward_transport" toml:"forward_transport" flag:"forward-transport" env:"FORWARD_TRANSPORT" usage:"transport to forwards, udp or tcp(fake tcp)"`
+	Offload          bool     `json:"offload" yaml:"offload" toml:"offload" flag:"offload" env:"OFFLOAD" usage:"coalesce bulk data of udp transports by UDP_SEGMENT and UDP_GRO, linux only"`
+}
+
+// go run . -config gateway.yaml
 func main() {
-	fmt.Println(debug.Debug())
+	if err := run(); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "gateway:", err.Error())
+		}
+		os.Exit(1)
+	}
+}
 
-	config := gateway.Config{
-		MaxRecvBuff: 2048,
+func run() error {
+	var c = Config{
+		Listen:           ":19986",
+		MaxRecvBuff:      2048,
+		GeoHTTP:          true,
+		Inspect:          "127.0.0.1:19988",
+		Transports:       []string{nodes.UDP},
+		ForwardTransport: nodes.UDP,
+	}
+	if err := config.Parse("gateway", "GATEWAY_", &c, os.Args[1:]); err != nil {
+		return err
+	}
+	cfg, forwards, err := c.build()
+	if err != nil {
+		return err
 	}
 
-	var t = test.T()
-	p, err := gateway.New(":19986", &config)
-	require.NoError(t, err)
+	p, err := gateway.New(c.Listen, cfg)
+	if err != nil {
+		return err
+	}
 
 	go func() {
 		time.Sleep(time.Second)
-		require.NoError(t, p.AddForward(netip.MustParseAddrPort("45.131.69.50:19986")))  // 莫斯科
-		require.NoError(t, p.AddForward(netip.MustParseAddrPort("103.94.185.61:19986"))) // 洛杉矶
+		for _, e := range forwards {
+			var err error
+			if e.loc != 0 {
+				err = p.AddForwardWithLocation(e.faddr, e.loc)
+			} else {
+				err = p.AddForward(e.faddr)
+			}
+			if err != nil {
+				fmt.Fprintln(os.Stderr, "gateway: add forward", e.faddr.String(), err.Error())
+				os.Exit(1)
+			}
+		}
 
 		for {
 			time.Sleep(time.Second * 3)
@@ -38,6 +109,157 @@ func main() {
 		}
 	}()
 
-	err = p.Serve()
-	require.NoError(t, err)
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return p.Serve(ctx)
+}
+
+type forward struct {
+	faddr netip.AddrPort
+	loc   bvvd.Location // zero will lookup by ip
+}
+
+// parseForward parse forward from addr or addr@location
+func parseForward(s string) (f forward, err error) {
+	addr, loc, has := strings.Cut(s, "@")
+	if f.faddr, err = netip.ParseAddrPort(addr); err != nil {
+		return forward{}, errors.Errorf("invalid forward address %q", addr)
+	}
+	if has {
+		if f.loc, err = bvvd.ParseLocation(loc); err != nil {
+			return forward{}, errors.WithMessagef(err, "forward %s", addr)
+		}
+	}
+	return f, nil
+}
+
+// registry build controller registry with mutual tls
+func (c *Config) registry() (*admin.Registry, error) {
+	if _, _, err := net.SplitHostPort(c.Controller); err != nil {
+		return nil, errors.Errorf("invalid controller address %q", c.Controller)
+	}
+	if c.ControllerCA == "" || c.Cert == "" || c.Key == "" {
+		return nil, errors.New("controller require controller ca, cert and key")
+	}
+	cfg, err := admin.ClientTLS(c.ControllerCA, c.Cert, c.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &admin.Registry{Addr: c.Controller, TLS: cfg}, nil
+}
+
+// build validate and build gateway config
+func (c *Config) build() (*gateway.Config, []forward, error) {
+	if _, _, err := net.SplitHostPort(c.Listen); err != nil {
+		return nil, nil, errors.Errorf("invalid listen address %q", c.Listen)
+	}
+	if _, _, err := net.SplitHostPort(c.Metrics); c.Metrics != "" && err != nil {
+		return nil, nil, errors.Errorf("invalid metrics address %q", c.Metrics)
+	}
+	if c.MaxRecvBuff < 1500 {
+		return nil, nil, errors.Errorf("max receive buffer %d less than 1500", c.MaxRecvBuff)
+	}
+	if len(c.Transports) == 0 {
+		return nil, nil, errors.New("require transports")
+	}
+	for i, e := range c.Transports {
+		if _, err := nodes.GatewayNetwork(e); err != nil {
+			return nil, nil, err
+		} else if slices.Contains(c.Transports[:i], e) {
+			return nil, nil, errors.Errorf("duplicate transport %s", e)
+		}
+	}
+	if _, err := nodes.ForwardNetwork(c.ForwardTransport); err != nil {
+		return nil, nil, err
+	}
+	if len(c.Forwards) == 0 && c.Controller == "" {
+		return nil, nil, errors.New("require forwards or controller")
+	}
+	var forwards []forward
+	for _, e := range c.Forwards {
+		f, err := parseForward(e)
+		if err != nil {
+			return nil, nil, err
+		}
+		forwards = append(forwards, f)
+	}
+
+	var cfg = &gateway.Config{
+		MaxRecvBuff:      c.MaxRecvBuff,
+		LogPath:          c.LogPath,
+		PcapBuiltinPath:  c.PcapBuiltinPath,
+		MAC:              c.MAC,
+		Seal:             c.Seal,
+		MetricsAddr:      c.Metrics,
+		InspectAddr:      c.Inspect,
+		Transports:       c.Transports,
+		ForwardTransport: c.ForwardTransport,
+		Offload:          c.Offload,
+	}
+	provider, err := geo.Open(c.GeoDB, c.GeoHTTP)
+	if err != nil {
+		return nil, nil, err
+	}
+	cfg.GeoProvider = provider
+
+	if c.Controller != "" {
+		if cfg.Controller, err = c.registry(); err != nil {
+			return nil, nil, err
+		}
+	}
+	if c.Location != "" {
+		if cfg.Location, err = bvvd.ParseLocation(c.Location); err != nil {
+			return nil, nil, err
+		}
+	}
+	if c.PublicAddr != "" {
+		if cfg.PublicAddr, err = netip.ParseAddr(c.PublicAddr); err != nil {
+			return nil, nil, errors.Errorf("invalid public address %q", c.PublicAddr)
+		}
+	}
+
+	switch {
+	case c.AuthFile != "" && c.AuthURL != "":
+		return nil, nil, errors.New("auth file and auth url are mutually exclusive")
+	case c.AuthFile != "":
+		auth, err := gateway.FileAuth(c.AuthFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		cfg.Authenticator = auth
+	case c.AuthURL != "":
+		cfg.Authenticator = gateway.HTTPAuth(c.AuthURL)
+	}
+	if (c.MAC || c.Seal) && cfg.Authenticator == nil {
+		return nil, nil, errors.New("mac and seal require auth file or auth url")
+	}
+	if c.SessionKey != "" {
+		if cfg.SessionKey, err = sessionKey(c.SessionKey); err != nil {
+			return nil, nil, err
+		}
+	} else if c.MAC || c.Seal {
+		return nil, nil, errors.New("mac and seal require session key")
+	}
+	return cfg, forwards, nil
+}
+
+// sessionKey load x25519 private key from pkcs8 pem file
+func sessionKey(file string) (*ecdh.PrivateKey, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.Errorf("invalid pem file %s", file)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	priv, ok := key.(*ecdh.PrivateKey)
+	if !ok || priv.Curve() != ecdh.X25519() {
+		return nil, errors.Errorf("%s is not x25519 private key", file)
+	}
+	return priv, nil
 }
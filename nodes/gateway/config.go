@@ -1,8 +1,18 @@
 package gateway
 
 import (
+	"crypto/ecdh"
 	"log/slog"
+	"net/netip"
 	"os"
+	"slices"
+	"time"
+
+	"github.com/lysShub/anton-planet-accelerator/bvvd"
+	"github.com/lysShub/anton-planet-accelerator/nodes"
+	"github.com/lysShub/anton-planet-accelerator/nodes/admin"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/geo"
+	"github.com/pkg/errors"
 )
 
 type Config struct {
@@ -10,23 +20,161 @@ type Config struct {
 
 	LogPath string
 	logger  *slog.Logger
+	logFile *os.File // nil if stdout
 
 	PcapBuiltinPath string
+
+	// Authenticator authenticate client session, nil will disable
+	// authentication, any client can relay traffic.
+	Authenticator Authenticator
+
+	// MAC require packets of authenticated session carry truncated mac
+	// trailer, prevent spoofed message or data, require Authenticator.
+	MAC bool
+
+	// Seal encrypt packets of authenticated session by tunnel, key is
+	// negotiated during session handshake, require Authenticator.
+	Seal bool
+
+	// SessionKey x25519 static key of session handshake, client seal token
+	// by its public key and verify gateway hold it, so key exchange can't
+	// be intercepted, require by MAC and Seal.
+	SessionKey *ecdh.PrivateKey
+
+	// SessionRate max session handshakes per second of each source ip,
+	// ipv6 is counted by /64 prefix, default 1.
+	SessionRate float64
+
+	// SessionBurst max burst session handshakes of each source ip, default 4.
+	SessionBurst int
+
+	// MaxHandshakes max concurrent session handshakes, handshake exceeded
+	// is dropped, default 64.
+	MaxHandshakes int
+
+	// GeoProvider lookup location of ip, nil will use http with cache.
+	GeoProvider geo.Provider
+
+	// Controller register to controller, add forwards pushed by it, nil
+	// will add forward by AddForward manually.
+	Controller *admin.Registry
+
+	// Location declared location when register to controller, optional.
+	Location bvvd.Location
+
+	// PublicAddr public address registered to controller, invalid will use
+	// remote address seen by controller, which is wrong if the gateway
+	// connect controller by private network.
+	PublicAddr netip.Addr
+
+	// ProbeInterval health probe interval of forwards, default 1s.
+	ProbeInterval time.Duration
+
+	// ProbeFailures mark forward down after consecutive probe failures,
+	// down forward is excluded from route probe and Data relay, default 3.
+	ProbeFailures int
+
+	// MetricsAddr http listen address of prometheus metrics, path is
+	// /metrics, empty will disable.
+	MetricsAddr string
+
+	// InspectAddr listen address of read-only json api, loopback address
+	// or unix socket path with unix: prefix, empty will disable.
+	InspectAddr string
+
+	// Transports listen transports of clients, nodes.UDP or nodes.TCP, all
+	// listen on the same port, so client behind udp blocked network can
+	// fall back to tcp, default is udp. udp is dual-stack, tcp only ipv4.
+	Transports []string
+
+	// ForwardTransport transport to forwards, should be same as listen
+	// transport of forwards, default is udp.
+	ForwardTransport string
+
+	// Offload enable udp segmentation offload(UDP_SEGMENT and UDP_GRO) of
+	// udp transports, consecutive bulk Data to the same peer is coalesced,
+	// play data is written immediately. linux only, fall back if not support.
+	// rx-gro of egress interface is kept for UDP_GRO, but fake tcp require
+	// it disabled, so only UDP_SEGMENT work with tcp transport.
+	Offload bool
 }
 
-func (c *Config) init() *Config {
-	var err error
+func (c *Config) init() error {
+	if c.MAC && c.Authenticator == nil {
+		return errors.New("mac require authenticator")
+	}
+	if c.Seal && c.Authenticator == nil {
+		return errors.New("seal require authenticator")
+	}
+	if (c.MAC || c.Seal) && c.SessionKey == nil {
+		return errors.New("mac and seal require session key")
+	}
+
+	if c.Controller != nil && (c.Controller.Addr == "" || c.Controller.TLS == nil) {
+		return errors.New("controller require address and tls")
+	}
+
+	if len(c.Transports) == 0 {
+		c.Transports = []string{nodes.UDP}
+	}
+	for i, e := range c.Transports {
+		if _, err := nodes.GatewayNetwork(e); err != nil {
+			return err
+		} else if slices.Contains(c.Transports[:i], e) {
+			return errors.Errorf("duplicate transport %s", e)
+		}
+	}
+	if c.ForwardTransport == "" {
+		c.ForwardTransport = nodes.UDP
+	}
+	if _, err := nodes.ForwardNetwork(c.ForwardTransport); err != nil {
+		return err
+	}
+
+	if c.SessionRate <= 0 {
+		c.SessionRate = 1
+	}
+	if c.SessionBurst <= 0 {
+		c.SessionBurst = 4
+	}
+	if c.MaxHandshakes <= 0 {
+		c.MaxHandshakes = 64
+	}
+
+	if c.ProbeInterval <= 0 {
+		c.ProbeInterval = time.Second
+	}
+	if c.ProbeFailures <= 0 {
+		c.ProbeFailures = 3
+	}
+
+	if c.GeoProvider == nil {
+		c.GeoProvider = geo.Cache(geo.HTTP(), 1024)
+	}
 
 	var fh *os.File
 	if c.LogPath == "" {
 		fh = os.Stdout
 	} else {
+		var err error
 		fh, err = os.OpenFile(c.LogPath, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o666)
 		if err != nil {
-			panic(err)
+			return errors.WithStack(err)
 		}
+		c.logFile = fh
 	}
 	c.logger = slog.New(slog.NewJSONHandler(fh, nil))
+	return nil
+}
 
-	return c
+// closeLog flush and close log file, stdout is kept
+func (c *Config) closeLog() error {
+	if c.logFile == nil {
+		return nil
+	}
+	if err := c.logFile.Sync(); err != nil {
+		c.logFile.Close()
+		return errors.WithStack(err)
+	}
+	return errors.WithStack(c.logFile.Close())
 }
@@ -1,6 +1,9 @@
 package gateway
 
 import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/binary"
 	"net/netip"
 	"sync"
 	"sync/atomic"
@@ -8,18 +11,29 @@ import (
 
 	"github.com/lysShub/anton-planet-accelerator/bvvd"
 	"github.com/lysShub/anton-planet-accelerator/nodes"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/mac"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/msg"
 	"github.com/lysShub/anton-planet-accelerator/nodes/internal/stats"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/tunnel"
+	"github.com/pkg/errors"
 )
 
 type Clients struct {
-	mu sync.RWMutex
-	cs map[netip.AddrPort]*Client
+	mu     sync.RWMutex
+	cs     map[netip.AddrPort]*Client
+	timer  *time.Timer
+	closed bool
+
+	evict func(client netip.AddrPort)
 }
 
-func NewClients() *Clients {
-	var cs = &Clients{cs: map[netip.AddrPort]*Client{}}
+// NewClients create clients, evict will be called when client expired, optional
+func NewClients(evict func(client netip.AddrPort)) *Clients {
+	var cs = &Clients{cs: map[netip.AddrPort]*Client{}, evict: evict}
 
-	time.AfterFunc(nodes.Keepalive, cs.keepalive)
+	cs.mu.Lock()
+	cs.timer = time.AfterFunc(nodes.Keepalive, cs.keepalive)
+	cs.mu.Unlock()
 	return cs
 }
 
@@ -39,34 +53,183 @@ func (cs *Clients) Client(client netip.AddrPort) *Client {
 	return c
 }
 
+// Len current clients count, include not authenticated
+func (cs *Clients) Len() int {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	return len(cs.cs)
+}
+
+// Range call fn for each client, fn should not call method of cs
+func (cs *Clients) Range(fn func(caddr netip.AddrPort, c *Client)) {
+	cs.mu.RLock()
+	defer cs.mu.RUnlock()
+	for k, e := range cs.cs {
+		fn(k, e)
+	}
+}
+
+// Get get client without create, return nil if not existed
+func (cs *Clients) Get(client netip.AddrPort) *Client {
+	cs.mu.RLock()
+	c := cs.cs[client]
+	cs.mu.RUnlock()
+
+	if c != nil {
+		c.alive.Add(1)
+	}
+	return c
+}
+
+// Session create authenticated client by hello, return the existed session
+// if hello renew it or is retransmitted, so handshake can be used as renew.
+// session keys are derived from x25519 public key of hello if FlagMAC or
+// FlagSeal, static is gateway static key to confirm sealed hello, token is
+// opened token of hello.
+func (cs *Clients) Session(client netip.AddrPort, user string, flags msg.Flags, hello *msg.Hello, static *ecdh.PrivateKey, token msg.Token) (c *Client, new bool, err error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	c = cs.cs[client]
+	renew := c != nil && c.session.ID != 0 && c.user == user && c.session.Flags == flags &&
+		(c.session.ID == hello.Session || c.peer == hello.Public)
+	if new = !renew; new {
+		if c != nil {
+			c.uplinkPL.Close()
+		}
+		c = &Client{uplinkPL: stats.NewPLStats(bvvd.MaxID), user: user}
+		c.session.Flags = flags
+		c.session.Version = bvvd.Version
+		for c.session.ID == 0 {
+			var b [8]byte
+			if _, err := rand.Read(b[:]); err != nil {
+				return nil, false, errors.WithStack(err)
+			}
+			c.session.ID = binary.BigEndian.Uint64(b[:])
+		}
+		c.peer = hello.Public
+		if flags.Has(msg.FlagMAC) || flags.Has(msg.FlagSeal) || hello.Sealed {
+			priv, pub, err := tunnel.KeyPair()
+			if err != nil {
+				return nil, false, err
+			}
+			c.session.Public = pub
+
+			ee, err := tunnel.ECDH(priv, hello.Public)
+			if err != nil {
+				return nil, false, err
+			}
+			var secrets = [][]byte{ee}
+			if hello.Sealed {
+				es, err := tunnel.ECDH(static, hello.Public)
+				if err != nil {
+					return nil, false, err
+				}
+				secrets = append(secrets, es)
+			}
+			keys, err := tunnel.Derive(c.session.Salt(hello.Public, token), secrets...)
+			if err != nil {
+				return nil, false, err
+			}
+			if hello.Sealed {
+				c.session.Confirm = keys.Confirm
+			}
+			if flags.Has(msg.FlagMAC) {
+				c.mac = mac.New(keys.MAC, false)
+			}
+			if flags.Has(msg.FlagSeal) {
+				c.key = keys.Tunnel
+			}
+		}
+		cs.cs[client] = c
+	}
+	c.version.Store(uint32(hello.Version))
+	c.alive.Add(1)
+	return c, new, nil
+}
+
 func (cs *Clients) keepalive() {
 	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if cs.closed {
+		return
+	}
+
 	for k, e := range cs.cs {
 		if e.alive.Swap(0) == 0 {
 			delete(cs.cs, k)
+			e.uplinkPL.Close()
+			if cs.evict != nil {
+				cs.evict(k)
+			}
 		}
 	}
-	cs.mu.Unlock()
+	cs.timer.Reset(nodes.Keepalive)
+}
 
-	time.AfterFunc(nodes.Keepalive, cs.keepalive)
+// Close stop keepalive and release all clients, without call evict
+func (cs *Clients) Close() error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	cs.closed = true
+	cs.timer.Stop()
+	for _, e := range cs.cs {
+		e.uplinkPL.Close()
+	}
+	clear(cs.cs)
+	return nil
 }
 
 type Client struct {
 	alive atomic.Uint32
 
+	user    string
+	session msg.Session   // authenticated session, zero if not authenticate
+	mac     *mac.MAC      // nil if session not require mac
+	key     []byte        // tunnel key, nil if session not sealed
+	peer    [32]byte      // client x25519 public key of session hello
+	version atomic.Uint32 // bvvd header version supported by client
+
 	uplinkPL   *stats.PLStats // uplink pl statistics
 	downlinkID atomic.Uint32  // downlink inc id
+
+	unreachable atomic.Int64 // unix nano of last notified unreachable
 }
 
+func (c *Client) User() string         { return c.user }
+func (c *Client) Session() msg.Session { return c.session }
+func (c *Client) MAC() *mac.MAC        { return c.mac }
+func (c *Client) TunnelKey() []byte    { return c.key }
+
+// Version bvvd header version supported by client, zero if legacy client
+// or not handshake
+func (c *Client) Version() uint8 { return uint8(c.version.Load()) }
+
 func (c *Client) UplinkID(id int) {
 	c.uplinkPL.ID(id)
 	c.alive.Add(1)
 }
 
+// Unreachable report whether notify client forward unreachable, limit once
+// per second
+func (c *Client) Unreachable() bool {
+	now := time.Now().UnixNano()
+	last := c.unreachable.Load()
+	return now-last > int64(time.Second) && c.unreachable.CompareAndSwap(last, now)
+}
+
+// UplinkPL pack loss of current window, reset the window, should only be
+// called by the pack loss reply
 func (c *Client) UplinkPL() stats.PL {
 	return stats.PL(c.uplinkPL.PL(nodes.PLScale))
 }
 
+// PeekUplinkPL pack loss without reset the window
+func (c *Client) PeekUplinkPL() stats.PL {
+	return c.uplinkPL.Peek(nodes.PLScale)
+}
+
 func (c *Client) DownlinkID() uint8 {
 	return uint8(c.downlinkID.Add(1) - 1)
 }
@@ -0,0 +1,16 @@
+//go:build linux
+// +build linux
+
+package gateway
+
+// Stopped report whether timers of gateway stopped
+func Stopped(p *Gateway) bool {
+	p.cs.mu.RLock()
+	cs := p.cs.closed
+	p.cs.mu.RUnlock()
+
+	p.fs.mu.RLock()
+	fs := p.fs.closed
+	p.fs.mu.RUnlock()
+	return cs && fs && p.speed.Closed()
+}
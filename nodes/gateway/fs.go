@@ -1,9 +1,12 @@
 package gateway
 
 import (
+	"math/rand"
+	"net"
 	"net/netip"
 	"sync"
 	"sync/atomic"
+	"time"
 
 	"github.com/lysShub/anton-planet-accelerator/bvvd"
 	"github.com/lysShub/anton-planet-accelerator/nodes"
@@ -12,8 +15,9 @@ import (
 )
 
 type Forwards struct {
-	mu sync.RWMutex
-	fs map[netip.AddrPort]*Forward // faddr
+	mu     sync.RWMutex
+	fs     map[netip.AddrPort]*Forward // faddr
+	closed bool
 }
 
 func NewForwards() *Forwards {
@@ -32,32 +36,102 @@ func (f *Forwards) Get(faddr netip.AddrPort) (*Forward, error) {
 	return fw, nil
 }
 
+// Forwards get active forwards, not include draining or down forwards
 func (f *Forwards) Forwards() (fs []netip.AddrPort) {
 	f.mu.RLock()
 	defer f.mu.RUnlock()
 
 	for _, e := range f.fs {
-		fs = append(fs, e.faddr)
+		if !e.Draining() && !e.Down() {
+			fs = append(fs, e.faddr)
+		}
 	}
 	return fs
 }
 
+// List get all forwards
+func (f *Forwards) List() (fs []*Forward) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	for _, e := range f.fs {
+		fs = append(fs, e)
+	}
+	return fs
+}
+
+// Add add forward, resume it if the forward is draining
 func (f *Forwards) Add(faddr netip.AddrPort, loc bvvd.Location) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return errors.WithStack(net.ErrClosed)
+	} else if fw, has := f.fs[faddr]; has {
+		if fw.loc == loc && fw.draining.CompareAndSwap(true, false) {
+			return nil
+		}
+		return errors.Errorf("forward faddr:%s location:%s existed", fw.faddr.String(), fw.loc.String())
+	}
+
 	fw, err := newForward(faddr, loc)
 	if err != nil {
 		return err
 	}
+	f.fs[faddr] = fw
+	return nil
+}
 
+// Remove remove forward immediately, flows to it will be dropped
+func (f *Forwards) Remove(faddr netip.AddrPort) error {
 	f.mu.Lock()
 	defer f.mu.Unlock()
-	if fw, has := f.fs[faddr]; has {
-		return errors.Errorf("forward faddr:%s location:%s existed", fw.faddr.String(), fw.loc.String())
+	fw, has := f.fs[faddr]
+	if !has {
+		return errors.Errorf("not forward %s record", faddr.String())
 	}
 
-	f.fs[faddr] = fw
+	delete(f.fs, faddr)
+	return fw.Close()
+}
+
+// Close release all forwards, following Add will fail
+func (f *Forwards) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.closed = true
+	for _, e := range f.fs {
+		e.Close()
+	}
+	clear(f.fs)
 	return nil
 }
 
+// Drain mark forward draining, existed flows continue, but new route probes
+// avoid it, it should be removed after idle
+func (f *Forwards) Drain(faddr netip.AddrPort) error {
+	fw, err := f.Get(faddr)
+	if err != nil {
+		return err
+	}
+	fw.active.Store(time.Now().UnixNano())
+	fw.draining.Store(true)
+	return nil
+}
+
+// Idled get draining forwards that without traffic over timeout
+func (f *Forwards) Idled(timeout time.Duration) (fs []netip.AddrPort) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	for _, e := range f.fs {
+		if e.Draining() && time.Since(time.Unix(0, e.active.Load())) > timeout {
+			fs = append(fs, e.faddr)
+		}
+	}
+	return fs
+}
+
 type Forward struct {
 	faddr netip.AddrPort
 	loc   bvvd.Location
@@ -65,6 +139,29 @@ type Forward struct {
 	uplinkID atomic.Uint32 // gateway-->forward inc id
 
 	donwlinkPL *stats.PLStats // forward-->gateway pl
+
+	draining atomic.Bool
+	active   atomic.Int64 // unix nano of last traffic
+
+	// health probe gateway <--> forward
+	probeSeq atomic.Uint32
+	probeAck atomic.Uint32   // latest replied seq
+	probes   [MaxProbe]probe // inflight probes, index by seq
+	probePL  *stats.PLStats
+	srtt     atomic.Int64  // smoothed rtt
+	fails    atomic.Uint32 // consecutive probe failures
+	down     atomic.Bool
+
+	version atomic.Uint32 // bvvd header version supported, replied by probe
+}
+
+// MaxProbe max inflight probes of forward
+const MaxProbe = bvvd.MaxID + 1
+
+type probe struct {
+	sent  atomic.Int64 // unix nano of sent probe
+	seq   atomic.Uint32
+	nonce atomic.Uint32
 }
 
 func newForward(faddr netip.AddrPort, loc bvvd.Location) (*Forward, error) {
@@ -78,6 +175,7 @@ func newForward(faddr netip.AddrPort, loc bvvd.Location) (*Forward, error) {
 		faddr:      faddr,
 		loc:        loc,
 		donwlinkPL: stats.NewPLStats(bvvd.MaxID),
+		probePL:    stats.NewPLStats(bvvd.MaxID),
 	}, nil
 }
 
@@ -85,14 +183,112 @@ func (f *Forward) Addr() netip.AddrPort {
 	return f.faddr
 }
 
+// Close stop pl statistics, the Forward should not be referenced anymore
+func (f *Forward) Close() error {
+	f.donwlinkPL.Close()
+	f.probePL.Close()
+	return nil
+}
+
+func (f *Forward) Draining() bool { return f.draining.Load() }
+
 func (f *Forward) UplinkID() uint8 {
+	f.activate()
 	return uint8(f.uplinkID.Add(1) - 1)
 }
 
 func (f *Forward) DownlinkID(id uint8) {
+	f.activate()
 	f.donwlinkPL.ID(int(id))
 }
 
+func (f *Forward) Down() bool { return f.down.Load() }
+
+// Version bvvd header version supported by forward, zero if legacy forward
+// or not probed
+func (f *Forward) Version() uint8 { return uint8(f.version.Load()) }
+
+func (f *Forward) SetVersion(v uint8) { f.version.Store(uint32(v)) }
+
+// Probe start a health probe, return the probe nonce, previous probe without
+// reply is counted as failure, downed report the forward down by failures.
+func (f *Forward) Probe(failures int) (nonce uint32, downed bool) {
+	seq := f.probeSeq.Add(1)
+	if seq == 0 {
+		seq = f.probeSeq.Add(1) // zero means not replied
+	}
+
+	if prev := seq - 1; prev != 0 && f.probeAck.Load() != prev {
+		if f.fails.Add(1) >= uint32(failures) {
+			downed = !f.down.Swap(true)
+		}
+	}
+
+	// nonce is message id of probe, low bits index the probe, high bits
+	// are random, prevent forged reply
+	nonce = rand.Uint32()&^(MaxProbe-1) | seq%MaxProbe
+	if nonce == 0 {
+		nonce = MaxProbe
+	}
+	p := &f.probes[seq%MaxProbe]
+	p.sent.Store(0)
+	p.seq.Store(seq)
+	p.nonce.Store(nonce)
+	p.sent.Store(time.Now().UnixNano())
+	return nonce, downed
+}
+
+// Reply probe replied, return rtt of the probe, zero means invalid reply,
+// up report the forward recover from down.
+func (f *Forward) Reply(nonce uint32) (rtt time.Duration, up bool) {
+	p := &f.probes[nonce%MaxProbe]
+	if p.nonce.Load() != nonce {
+		return 0, false
+	}
+	seq := p.seq.Load()
+	sent := p.sent.Swap(0)
+	if sent == 0 || f.probeSeq.Load()-seq >= MaxProbe {
+		return 0, false
+	}
+	rtt = max(time.Duration(time.Now().UnixNano()-sent), 1)
+
+	for ack := f.probeAck.Load(); seq-ack < MaxProbe && !f.probeAck.CompareAndSwap(ack, seq); {
+		ack = f.probeAck.Load()
+	}
+	f.probePL.ID(int(uint8(seq)))
+	f.probePL.PL(nodes.PLScale) // roll the window, observed by ProbePL
+	if srtt := f.srtt.Load(); srtt == 0 {
+		f.srtt.Store(int64(rtt))
+	} else {
+		f.srtt.Store(srtt - srtt/8 + int64(rtt)/8)
+	}
+
+	f.fails.Store(0)
+	return rtt, f.down.Swap(false)
+}
+
+// RTT smoothed rtt of health probe
+func (f *Forward) RTT() time.Duration { return time.Duration(f.srtt.Load()) }
+
+// ProbePL pack loss of health probe
+func (f *Forward) ProbePL() stats.PL {
+	return f.probePL.Peek(nodes.PLScale)
+}
+
+// activate record traffic, only draining forward need it
+func (f *Forward) activate() {
+	if f.draining.Load() {
+		f.active.Store(time.Now().UnixNano())
+	}
+}
+
+// DownlinkPL pack loss of current window, reset the window, should only be
+// called by the pack loss reply
 func (f *Forward) DownlinkPL() stats.PL {
 	return stats.PL(f.donwlinkPL.PL(nodes.PLScale))
 }
+
+// PeekDownlinkPL pack loss without reset the window
+func (f *Forward) PeekDownlinkPL() stats.PL {
+	return f.donwlinkPL.Peek(nodes.PLScale)
+}
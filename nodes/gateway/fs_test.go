@@ -0,0 +1,121 @@
+package gateway_test
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/lysShub/anton-planet-accelerator/bvvd"
+	"github.com/lysShub/anton-planet-accelerator/nodes/gateway"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Forwards(t *testing.T) {
+	var (
+		fs     = gateway.NewForwards()
+		faddr1 = netip.MustParseAddrPort("1.1.1.1:19986")
+		faddr2 = netip.MustParseAddrPort("2.2.2.2:19986")
+	)
+	require.NoError(t, fs.Add(faddr1, bvvd.Moscow))
+	require.NoError(t, fs.Add(faddr2, bvvd.Tokyo))
+	require.Error(t, fs.Add(faddr1, bvvd.Moscow))
+	require.ElementsMatch(t, []netip.AddrPort{faddr1, faddr2}, fs.Forwards())
+
+	t.Run("drain", func(t *testing.T) {
+		require.NoError(t, fs.Drain(faddr1))
+		require.Equal(t, []netip.AddrPort{faddr2}, fs.Forwards())
+
+		// existed flows continue
+		f, err := fs.Get(faddr1)
+		require.NoError(t, err)
+		require.True(t, f.Draining())
+		f.UplinkID()
+
+		require.Empty(t, fs.Idled(time.Millisecond*50))
+		time.Sleep(time.Millisecond * 100)
+		require.Equal(t, []netip.AddrPort{faddr1}, fs.Idled(time.Millisecond*50))
+	})
+
+	t.Run("resume", func(t *testing.T) {
+		require.NoError(t, fs.Add(faddr1, bvvd.Moscow))
+		require.ElementsMatch(t, []netip.AddrPort{faddr1, faddr2}, fs.Forwards())
+		require.Empty(t, fs.Idled(0))
+	})
+
+	t.Run("remove", func(t *testing.T) {
+		require.NoError(t, fs.Remove(faddr1))
+		require.Error(t, fs.Remove(faddr1))
+		require.Error(t, fs.Drain(faddr1))
+
+		_, err := fs.Get(faddr1)
+		require.Error(t, err)
+		require.Equal(t, []netip.AddrPort{faddr2}, fs.Forwards())
+	})
+
+	t.Run("close", func(t *testing.T) {
+		require.NoError(t, fs.Close())
+		require.Empty(t, fs.List())
+		require.Error(t, fs.Add(faddr1, bvvd.Moscow))
+	})
+}
+
+func Test_Probe(t *testing.T) {
+	var (
+		fs    = gateway.NewForwards()
+		faddr = netip.MustParseAddrPort("1.1.1.1:19986")
+	)
+	require.NoError(t, fs.Add(faddr, bvvd.Moscow))
+	f, err := fs.Get(faddr)
+	require.NoError(t, err)
+
+	t.Run("reply", func(t *testing.T) {
+		seq, downed := f.Probe(3)
+		require.False(t, downed)
+
+		rtt, up := f.Reply(seq)
+		require.NotZero(t, rtt)
+		require.False(t, up)
+		require.NotZero(t, f.RTT())
+
+		rtt, _ = f.Reply(seq)
+		require.Zero(t, rtt) // duplicate
+		rtt, _ = f.Reply(seq + 1)
+		require.Zero(t, rtt) // not sent
+	})
+
+	t.Run("forged", func(t *testing.T) {
+		nonce, _ := f.Probe(3)
+		rtt, _ := f.Reply(nonce ^ gateway.MaxProbe)
+		require.Zero(t, rtt) // same index, nonce mismatch
+
+		rtt, _ = f.Reply(nonce)
+		require.NotZero(t, rtt)
+	})
+
+	t.Run("down", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			_, downed := f.Probe(3)
+			require.False(t, downed)
+		}
+		_, downed := f.Probe(3)
+		require.True(t, downed)
+		require.True(t, f.Down())
+		require.Empty(t, fs.Forwards())
+
+		_, downed = f.Probe(3)
+		require.False(t, downed) // already down
+	})
+
+	t.Run("up", func(t *testing.T) {
+		seq, _ := f.Probe(3)
+		_, up := f.Reply(seq)
+		require.True(t, up)
+		require.False(t, f.Down())
+		require.Equal(t, []netip.AddrPort{faddr}, fs.Forwards())
+
+		seq, downed := f.Probe(3)
+		require.False(t, downed)
+		_, up = f.Reply(seq)
+		require.False(t, up)
+	})
+}
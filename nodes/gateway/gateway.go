@@ -4,20 +4,29 @@
 package gateway
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"math/rand"
 	"net/netip"
+	"slices"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/lysShub/anton-planet-accelerator/bvvd"
 	"github.com/lysShub/anton-planet-accelerator/conn"
 	"github.com/lysShub/anton-planet-accelerator/nodes"
-	"github.com/lysShub/anton-planet-accelerator/nodes/internal"
+	"github.com/lysShub/anton-planet-accelerator/nodes/admin"
+	"github.com/lysShub/anton-planet-accelerator/nodes/inspect"
 	"github.com/lysShub/anton-planet-accelerator/nodes/internal/checksum"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/ethtool"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/fec"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/metrics"
 	"github.com/lysShub/anton-planet-accelerator/nodes/internal/msg"
 	"github.com/lysShub/anton-planet-accelerator/nodes/internal/stats"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/tunnel"
 	"github.com/lysShub/netkit/debug"
 	"github.com/lysShub/netkit/errorx"
 	"github.com/lysShub/netkit/packet"
@@ -30,81 +39,311 @@ type Gateway struct {
 	config *Config
 	start  atomic.Bool
 
-	conn conn.Conn
+	conn *tunnel.Conn
+	mux  *conn.Mux // nil if listen single transport
 	cs   *Clients
+	lim  *limiter // limit session handshakes
 
 	sender conn.Conn
 	fs     *Forwards
 
-	speed *stats.LinkSpeed
-
+	speed   *stats.LinkSpeed
+	metrics *metrics.Metrics
+	mserver *metrics.Server // nil if metrics disabled
+	iserver *inspect.Server // nil if inspect disabled
+	traffic *metrics.Traffic
+	dropped dropped
+	offload *ethtool.Offload
+	control atomic.Pointer[admin.Session]
+
+	done     chan struct{}  // closed when gateway close
+	services sync.WaitGroup // background services, wait after close
 	closeErr errorx.CloseErr
 }
 
 func New(addr string, config *Config) (*Gateway, error) {
+	if err := config.init(); err != nil {
+		return nil, err
+	}
 	var p = &Gateway{
-		config: config.init(),
-		cs:     NewClients(),
+		config: config,
 		fs:     NewForwards(),
 		speed:  stats.NewLinkSpeed(time.Second),
+		done:   make(chan struct{}),
 	}
+	p.lim = newLimiter(config.SessionRate, config.SessionBurst, config.MaxHandshakes)
+	p.initMetrics()
 
-	err := internal.DisableOffload(config.logger)
+	raw, err := p.listen(addr)
 	if err != nil {
-		return nil, err
+		return nil, p.close(err)
 	}
+	p.conn = tunnel.New(raw, false)
+	p.cs = NewClients(func(caddr netip.AddrPort) {
+		p.conn.Del(caddr)
+		if p.mux != nil {
+			p.mux.Del(caddr)
+		}
+	})
 
-	p.conn, err = conn.Bind(nodes.GatewayNetwork, addr)
+	// fake tcp require disable gro, otherwise segments are merged
+	var keep []string
+	if config.Offload && !slices.Contains(config.Transports, nodes.TCP) && config.ForwardTransport != nodes.TCP {
+		keep = ethtool.UDPOffloads
+	} else if config.Offload {
+		config.logger.Warn("udp offload without gro, fake tcp transport require gro disabled")
+	}
+	p.offload, err = ethtool.DisableOffload(raw.LocalAddr().Addr(), config.logger, keep...)
 	if err != nil {
 		return nil, p.close(err)
 	}
 
-	p.sender, err = conn.Bind(nodes.ForwardNetwork, "")
+	network, err := nodes.ForwardNetwork(p.config.ForwardTransport)
+	if err != nil {
+		return nil, p.close(err)
+	}
+	p.sender, err = conn.Bind(network, "")
 	if err != nil {
 		return nil, p.close(err)
 	}
+	if config.Offload && p.config.ForwardTransport == nodes.UDP {
+		p.enableOffload(p.sender)
+	}
+
+	if config.MetricsAddr != "" {
+		if p.mserver, err = metrics.Listen(config.MetricsAddr, p.metrics); err != nil {
+			return nil, p.close(err)
+		}
+	}
+	if config.InspectAddr != "" {
+		if p.iserver, err = inspect.NewServer(config.InspectAddr, p.inspectHandler()); err != nil {
+			return nil, p.close(err)
+		}
+	}
 
 	return p, nil
 }
 
+// listen bind conn of each transport on the same port, multiplex them if
+// more than one
+func (p *Gateway) listen(addr string) (conn.Conn, error) {
+	var conns []conn.Conn
+	for _, transport := range p.config.Transports {
+		network, err := nodes.GatewayNetwork(transport)
+		if err == nil {
+			var c conn.Conn
+			if c, err = conn.Bind(network, addr); err == nil {
+				if p.config.Offload && transport == nodes.UDP {
+					p.enableOffload(c)
+				}
+				conns = append(conns, c)
+				if laddr := c.LocalAddr(); laddr.Addr().IsUnspecified() {
+					addr = fmt.Sprintf(":%d", laddr.Port()) // dual-stack udp
+				} else {
+					addr = laddr.String()
+				}
+				continue
+			}
+		}
+
+		for _, c := range conns {
+			c.Close()
+		}
+		return nil, errors.WithMessage(err, transport)
+	}
+
+	if len(conns) == 1 {
+		return conns[0], nil
+	}
+	p.mux = conn.NewMux(conns...)
+	return p.mux, nil
+}
+
+// enableOffload enable udp segmentation offload, keep normal io if not support
+func (p *Gateway) enableOffload(c conn.Conn) {
+	if err := conn.EnableOffload(c); err != nil {
+		p.config.logger.Warn("udp offload not enabled", slog.String("local", c.LocalAddr().String()), slog.String("error", err.Error()))
+	}
+}
+
 func (p *Gateway) close(cause error) error {
 	cause = errors.WithStack(cause)
-	if cause != nil {
-		p.config.logger.Error(cause.Error(), errorx.Trace(cause))
-	} else {
-		p.config.logger.Info("close")
+	if !p.closeErr.Closed() {
+		if cause != nil {
+			p.config.logger.Error(cause.Error(), errorx.Trace(cause))
+		} else {
+			p.config.logger.Info("close")
+		}
 	}
 	return p.closeErr.Close(func() (errs []error) {
 		errs = append(errs, cause)
+		close(p.done)
 		if p.speed != nil {
 			errs = append(errs, p.speed.Close())
 		}
 		if p.sender != nil {
 			errs = append(errs, p.sender.Close())
 		}
+		if p.mserver != nil {
+			errs = append(errs, p.mserver.Close())
+		}
+		if p.iserver != nil {
+			errs = append(errs, p.iserver.Close())
+		}
 		if p.conn != nil {
 			errs = append(errs, p.conn.Close())
 		}
+		if p.cs != nil {
+			errs = append(errs, p.cs.Close())
+		}
+		if p.fs != nil {
+			errs = append(errs, p.fs.Close())
+		}
+		if s := p.control.Load(); s != nil {
+			errs = append(errs, s.Close())
+		}
+		if p.offload != nil {
+			errs = append(errs, p.offload.Restore())
+		}
+		errs = append(errs, p.config.closeLog())
 		return errs
 	})
 }
 
-func (p *Gateway) Serve() (err error) {
+// Serve serve until ctx done or error occurred, stopped by ctx will
+// return nil. return after all services exited.
+func (p *Gateway) Serve(ctx context.Context) (err error) {
 	if p.start.Swap(true) {
 		return errors.Errorf("gateway started")
 	}
-	p.config.logger.Info("start", slog.String("listen", p.conn.LocalAddr().String()), slog.Bool("debug", debug.Debug()))
+	p.config.logger.Info("start",
+		slog.String("listen", p.conn.LocalAddr().String()),
+		slog.String("transports", strings.Join(p.config.Transports, ",")),
+		slog.String("forward_transport", p.config.ForwardTransport),
+		slog.Bool("offload", p.config.Offload),
+		slog.Bool("auth", p.config.Authenticator != nil),
+		slog.Bool("mac", p.config.MAC),
+		slog.Bool("seal", p.config.Seal),
+		slog.String("metrics", p.config.MetricsAddr),
+		slog.String("inspect", p.config.InspectAddr),
+		slog.Bool("debug", debug.Debug()),
+	)
+
+	stop := context.AfterFunc(ctx, func() { p.close(nil) })
+	defer stop()
+
+	p.service(p.donwlinkService)
+	p.service(p.drainService)
+	p.service(p.probeService)
+	if p.config.Controller != nil {
+		p.service(p.controlService)
+	}
+	if p.mserver != nil {
+		p.service(p.metricsService)
+	}
+	if p.iserver != nil {
+		p.service(p.inspectService)
+	}
+	err = p.close(p.uplinkService())
 
-	go p.donwlinkService()
-	return p.close(p.uplinkService())
+	p.services.Wait()
+	return err
 }
 
+// metricsService serve metrics http until gateway closed
+func (p *Gateway) metricsService() (_ error) {
+	if err := p.mserver.Serve(); err != nil {
+		return p.close(err)
+	}
+	return nil
+}
+
+// inspectService serve inspect api until gateway closed
+func (p *Gateway) inspectService() (_ error) {
+	if err := p.iserver.Serve(); err != nil {
+		return p.close(err)
+	}
+	return nil
+}
+
+// Close stop gateway, Serve will return nil
+func (p *Gateway) Close() error { return p.close(nil) }
+
+// service start background service, Serve wait it exit
+func (p *Gateway) service(fn func() error) {
+	p.services.Add(1)
+	go func() {
+		defer p.services.Done()
+		fn()
+	}()
+}
+
+// controlService register to controller, add or delete forwards pushed by it,
+// re-register after disconnected
+func (p *Gateway) controlService() (_ error) {
+	for {
+		if err := p.controlHandle(); err != nil && !p.closeErr.Closed() {
+			p.config.logger.Warn("controller", slog.String("error", err.Error()), errorx.Trace(err))
+		}
+
+		select {
+		case <-p.done:
+			return nil
+		case <-time.After(time.Second * 3):
+		}
+	}
+}
+
+func (p *Gateway) controlHandle() error {
+	r := p.config.Controller
+	addr := netip.IPv4Unspecified()
+	if p.config.PublicAddr.IsValid() {
+		addr = p.config.PublicAddr
+	}
+	sess, reply, err := admin.Register(r.Addr, r.TLS, admin.GatewayNew{
+		Addr:     netip.AddrPortFrom(addr, p.conn.LocalAddr().Port()),
+		Location: p.config.Location,
+	})
+	if err != nil {
+		return err
+	}
+	p.control.Store(sess)
+	defer sess.Close()
+	if p.closeErr.Closed() {
+		return nil
+	}
+	p.config.logger.Info("registered controller", slog.String("controller", r.Addr), slog.String("name", reply.(admin.GatewayNew).Name))
+
+	for {
+		msg, err := sess.Recv()
+		if err != nil {
+			return err
+		}
+
+		switch msg := msg.(type) {
+		case admin.ProxyAddForward:
+			if f, err := p.fs.Get(msg.Addr); err == nil && !f.Draining() {
+				continue // re-registered
+			}
+			err = p.AddForwardWithLocation(msg.Addr, msg.Location)
+		case admin.ForwardStop:
+			err = p.DrainForward(msg.Addr)
+		default:
+			err = errors.Errorf("invalid controller message kind %s", msg.Kind())
+		}
+		if err != nil {
+			p.config.logger.Warn(err.Error(), errorx.Trace(err))
+		}
+	}
+}
+
+// AddForward add forward, the location is looked up by ip
 func (p *Gateway) AddForward(faddr netip.AddrPort) error {
 	if !p.start.Load() {
 		return errors.Errorf("gateway not start")
 	}
 
-	coord, err := internal.IPCoord(faddr.Addr())
+	coord, err := p.config.GeoProvider.Coord(faddr.Addr())
 	if err != nil {
 		return err
 	}
@@ -112,6 +351,17 @@ func (p *Gateway) AddForward(faddr netip.AddrPort) error {
 	if offset > 500 {
 		return errors.Errorf("forward %s offset location %s %fkm", faddr.Addr(), loc, offset)
 	}
+	return p.AddForwardWithLocation(faddr, loc)
+}
+
+// AddForwardWithLocation add forward with declared location, without lookup
+func (p *Gateway) AddForwardWithLocation(faddr netip.AddrPort, loc bvvd.Location) error {
+	if !p.start.Load() {
+		return errors.Errorf("gateway not start")
+	}
+	if err := loc.Valid(); err != nil {
+		return err
+	}
 
 	if err := p.fs.Add(faddr, loc); err != nil {
 		return err
@@ -120,6 +370,131 @@ func (p *Gateway) AddForward(faddr netip.AddrPort) error {
 	return nil
 }
 
+// RemoveForward remove forward immediately, not relay to it anymore
+func (p *Gateway) RemoveForward(faddr netip.AddrPort) error {
+	if err := p.fs.Remove(faddr); err != nil {
+		return err
+	}
+	p.config.logger.Info("remove forward", slog.String("forward", faddr.String()))
+	return nil
+}
+
+// DrainForward drain forward, existed flows continue until idle, then remove
+// it, new route probes avoid it. add the forward again will resume it.
+func (p *Gateway) DrainForward(faddr netip.AddrPort) error {
+	if err := p.fs.Drain(faddr); err != nil {
+		return err
+	}
+	p.config.logger.Info("drain forward", slog.String("forward", faddr.String()))
+	return nil
+}
+
+// probeService probe health of forwards periodically, forward reply in
+// donwlinkService
+func (p *Gateway) probeService() (_ error) {
+	var (
+		ticker = time.NewTicker(p.config.ProbeInterval)
+		pkt    = packet.Make(64, msg.MinSize)
+	)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return nil
+		case <-ticker.C:
+		}
+
+		for _, f := range p.fs.List() {
+			nonce, downed := f.Probe(p.config.ProbeFailures)
+			if downed {
+				p.config.logger.Warn("forward down",
+					slog.String("forward", f.Addr().String()),
+					slog.Duration("rtt", f.RTT()),
+					slog.String("pl", f.ProbePL().String()),
+				)
+			}
+
+			// probe without client, forward reply its version
+			var v msg.Version
+			var m = msg.Fields{MsgID: nonce, Payload: &v}
+			m.Kind = bvvd.PingForward
+			m.DataID = uint8(nonce)
+			m.Forward = f.Addr()
+			if err := m.Encode(pkt.Sets(64, 0)); err != nil {
+				return p.close(err)
+			}
+			if err := p.sender.WriteToAddrPort(pkt, f.Addr()); err != nil {
+				return p.close(err)
+			}
+		}
+	}
+}
+
+// probeReply handle health probe reply of forward, reply must come from
+// the probed forward
+func (p *Gateway) probeReply(pkt *packet.Packet, faddr netip.AddrPort) {
+	m := (*msg.Message)(pkt)
+	if pkt.Data() < m.Size() {
+		return
+	}
+	f, err := p.fs.Get(m.Bvvd().Forward())
+	if err != nil {
+		return // removed
+	} else if faddr != f.Addr() {
+		p.config.logger.Warn("probe reply from other address", slog.String("forward", f.Addr().String()), slog.String("from", faddr.String()))
+		return
+	}
+
+	var v msg.Version
+	if err := m.Payload(&v); err == nil {
+		f.SetVersion(uint8(v))
+	}
+	if rtt, up := f.Reply(m.MsgID()); up {
+		p.config.logger.Info("forward up", slog.String("forward", f.Addr().String()), slog.Duration("rtt", rtt))
+	}
+}
+
+// legacy report whether forward not support ipv6 layout header, unknown
+// forward is not legacy
+func (p *Gateway) legacy(faddr netip.AddrPort) bool {
+	f, err := p.fs.Get(faddr)
+	return err == nil && f.Version() < bvvd.Version
+}
+
+// unreachable reply client the forward of Data is down, the reply is only
+// the bvvd header, client expire routes by the header forward
+func (p *Gateway) unreachable(pkt *packet.Packet, client *Client, caddr netip.AddrPort) error {
+	if !client.Unreachable() {
+		return nil
+	}
+
+	hdr := bvvd.Bvvd(pkt.Bytes())
+	hdr.SetKind(bvvd.Unreachable)
+	pkt.SetData(hdr.Len())
+	return p.writeToClient(pkt, client, caddr)
+}
+
+// drainService remove draining forwards after idle
+func (p *Gateway) drainService() (_ error) {
+	var ticker = time.NewTicker(time.Second * 5)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.done:
+			return nil
+		case <-ticker.C:
+		}
+
+		for _, faddr := range p.fs.Idled(nodes.Keepalive) {
+			if err := p.RemoveForward(faddr); err != nil {
+				p.config.logger.Warn(err.Error(), errorx.Trace(err))
+			}
+		}
+	}
+}
+
 func (p *Gateway) Speed() (up, down string) {
 	up1, down1 := p.speed.Speed()
 
@@ -146,148 +521,376 @@ func (p *Gateway) Speed() (up, down string) {
 
 func (p *Gateway) uplinkService() (_ error) {
 	var (
-		pkt = packet.Make(p.config.MaxRecvBuff)
+		msgs = make([]conn.Message, nodes.BatchSize)
+		up   = conn.NewBatch(p.sender, nodes.BatchSize)
 	)
+	for i := range msgs {
+		msgs[i].Packet = packet.Make(p.config.MaxRecvBuff)
+	}
 
 	for {
-		caddr, err := p.conn.ReadFromAddrPort(pkt.Sets(64, 0xffff))
+		for _, e := range msgs {
+			e.Packet.Sets(64, 0xffff)
+		}
+		n, err := p.conn.ReadBatch(msgs)
 		if err != nil {
 			return p.close(err)
-		} else if pkt.Data() < bvvd.Size {
-			continue
 		}
-		p.speed.Uplink(pkt.Data() + 20 + 8)
+		for _, e := range msgs[:n] {
+			if err := p.uplink(e.Packet, e.Addr, up); err != nil {
+				return err
+			}
+		}
+		if err := up.Flush(); err != nil {
+			return p.close(err)
+		}
+	}
+}
 
-		hdr := bvvd.Bvvd(pkt.Bytes())
-		hdr.SetClient(caddr)
-		if hdr.Kind() != bvvd.Data && pkt.Data() < msg.MinSize {
-			p.config.logger.Warn("too small", slog.Int("size", pkt.Data()), slog.String("client", caddr.String()), slog.String("kind", hdr.Kind().String()))
-			continue
+// uplink handle packet from client, Data to forward is collected by up
+func (p *Gateway) uplink(pkt *packet.Packet, caddr netip.AddrPort, up *conn.Batch) error {
+	if pkt.Data() < bvvd.Size || pkt.Data() < bvvd.Bvvd(pkt.Bytes()).Len() {
+		p.dropped.tooSmall.Inc()
+		return nil
+	}
+	p.speed.Uplink(pkt.Data() + 20 + 8)
+
+	kind := bvvd.Bvvd(pkt.Bytes()).Kind()
+	p.traffic.Uplink(kind, pkt.Data())
+	var client *Client
+	if kind != bvvd.Session {
+		if client = p.client(caddr); client == nil {
+			p.dropped.unauthenticated.Inc()
+			return nil // not authenticated
+		} else if m := client.MAC(); m != nil && !m.Verify(pkt) {
+			p.dropped.invalidMAC.Inc()
+			p.config.logger.Warn("invalid mac", slog.String("client", caddr.String()), slog.String("kind", kind.String()))
+			return nil
 		}
+	}
 
-		switch kind := hdr.Kind(); kind {
-		case bvvd.PingGateway:
-			if err := p.conn.WriteToAddrPort(pkt, caddr); err != nil {
-				return p.close(err)
-			}
-		case bvvd.PingForward, bvvd.PingServer:
-			var faddrs []netip.AddrPort
-			if hdr.Forward().Addr().IsUnspecified() {
-				faddrs = p.fs.Forwards() // boardcast
-			} else {
-				faddrs = []netip.AddrPort{hdr.Forward()}
-			}
-			for _, faddr := range faddrs {
-				hdr.SetForward(faddr)
-				if err = p.sender.WriteToAddrPort(pkt, faddr); err != nil {
-					return p.close(err)
-				}
-			}
-		case bvvd.PackLossClientUplink:
-			pl := p.cs.Client(caddr).UplinkPL()
-			if err := (*msg.Message)(pkt).SetPayload(&pl); err != nil {
-				p.config.logger.Warn(err.Error(), errorx.Trace(err))
-				continue
-			}
+	hdr := bvvd.Fit(pkt, caddr.Addr())
+	hdr.SetClient(caddr)
+	if kind != bvvd.Data && kind != bvvd.Parity && pkt.Data() < (*msg.Message)(pkt).Size() {
+		p.dropped.tooSmall.Inc()
+		p.config.logger.Warn("too small", slog.Int("size", pkt.Data()), slog.String("client", caddr.String()), slog.String("kind", kind.String()))
+		return nil
+	}
 
-			if err = p.conn.WriteToAddrPort(pkt, caddr); err != nil {
-				return p.close(err)
+	switch kind {
+	case bvvd.Session:
+		if !p.lim.Acquire(caddr.Addr()) {
+			p.dropped.rateLimited.Inc()
+			return nil
+		}
+		hello := pkt.Clone()
+		p.service(func() error {
+			defer p.lim.Release()
+			return p.sessionService(hello, caddr)
+		})
+	case bvvd.PingGateway:
+		if err := p.writeToClient(pkt, client, caddr); err != nil {
+			return p.close(err)
+		}
+	case bvvd.PingForward, bvvd.PingServer:
+		var faddrs []netip.AddrPort
+		if hdr.Forward().Addr().IsUnspecified() {
+			faddrs = p.fs.Forwards() // boardcast
+		} else {
+			faddrs = []netip.AddrPort{hdr.Forward()}
+		}
+		for _, faddr := range faddrs {
+			// Fit convert header in place, copy it for every forward
+			dup := pkt.Clone()
+			hdr := bvvd.Fit(dup, faddr.Addr())
+			if hdr.Is6() && p.legacy(faddr) {
+				p.dropped.legacyForward.Inc()
+				continue
 			}
-		case bvvd.PackLossGatewayUplink:
-			if err := p.sender.WriteToAddrPort(pkt, hdr.Forward()); err != nil {
+			hdr.SetForward(faddr)
+			if err := p.sender.WriteToAddrPort(dup, faddr); err != nil {
 				return p.close(err)
 			}
-		case bvvd.PackLossGatewayDownlink:
-			f, err := p.fs.Get(hdr.Forward())
-			if err != nil {
-				p.config.logger.Warn(err.Error(), errorx.Trace(err))
-				continue
-			}
-			pl := f.DownlinkPL()
+		}
+	case bvvd.PackLossClientUplink:
+		pl := client.UplinkPL()
+		if err := (*msg.Message)(pkt).SetPayload(&pl); err != nil {
+			p.config.logger.Warn(err.Error(), errorx.Trace(err))
+			return nil
+		}
 
-			if err := (*msg.Message)(pkt).SetPayload(&pl); err != nil {
-				p.config.logger.Warn(err.Error(), errorx.Trace(err))
-				continue
-			}
+		if err := p.writeToClient(pkt, client, caddr); err != nil {
+			return p.close(err)
+		}
+	case bvvd.PackLossGatewayUplink:
+		if err := p.sender.WriteToAddrPort(pkt, hdr.Forward()); err != nil {
+			return p.close(err)
+		}
+	case bvvd.PackLossGatewayDownlink:
+		f, err := p.fs.Get(hdr.Forward())
+		if err != nil {
+			p.config.logger.Warn(err.Error(), errorx.Trace(err))
+			return nil
+		}
+		pl := f.DownlinkPL()
 
-			if err = p.conn.WriteToAddrPort(pkt, caddr); err != nil {
+		if err := (*msg.Message)(pkt).SetPayload(&pl); err != nil {
+			p.config.logger.Warn(err.Error(), errorx.Trace(err))
+			return nil
+		}
+
+		if err := p.writeToClient(pkt, client, caddr); err != nil {
+			return p.close(err)
+		}
+	case bvvd.Data, bvvd.Parity:
+		// todo: 也许应该保留clinet data id, 现在PackLossGateway是共用的，可能会不准确
+		client.UplinkID(int(hdr.DataID()))
+
+		if debug.Debug() && kind == bvvd.Data {
+			ok := checksum.ValidChecksum(pkt.DetachN(hdr.Len()), uint8(hdr.Proto()), hdr.Server())
+			pkt.AttachN(hdr.Len())
+			require.True(test.T(), ok)
+		}
+
+		f, err := p.fs.Get(hdr.Forward())
+		if err != nil {
+			p.config.logger.Warn(err.Error(), errorx.Trace(err))
+			return nil
+		} else if f.Down() {
+			if err := p.unreachable(pkt, client, caddr); err != nil {
 				return p.close(err)
 			}
-		case bvvd.Data:
-			// todo: 也许应该保留clinet data id, 现在PackLossGateway是共用的，可能会不准确
-			p.cs.Client(caddr).UplinkID(int(hdr.DataID()))
-
-			if debug.Debug() {
-				ok := checksum.ValidChecksum(pkt.DetachN(bvvd.Size), uint8(hdr.Proto()), hdr.Server())
-				pkt.AttachN(bvvd.Size)
-				require.True(test.T(), ok)
-			}
+			return nil
+		}
 
-			f, err := p.fs.Get(hdr.Forward())
-			if err != nil {
-				p.config.logger.Warn(err.Error(), errorx.Trace(err))
-				continue
+		if f.Version() < bvvd.Version {
+			if kind == bvvd.Parity {
+				return nil // legacy forward not support fec
 			}
-
-			hdr.SetDataID(f.UplinkID())
-			if debug.Debug() && rand.Int()%100 == 99 {
-				continue // PackLossGatewayUplink
+			if hdr = bvvd.Strip(pkt); hdr.Is6() {
+				p.dropped.legacyForward.Inc()
+				return nil // legacy forward not support ipv6 layout
 			}
+		}
+		hdr.SetDataID(f.UplinkID())
+		if debug.Debug() && rand.Int()%100 == 99 {
+			return nil // PackLossGatewayUplink
+		}
 
-			if err = p.sender.WriteToAddrPort(pkt, f.Addr()); err != nil {
-				return p.close(err)
-			}
-		default:
-			p.config.logger.Warn("unknown kind from client", slog.String("kind", kind.String()), slog.String("client", caddr.String()))
+		if p.config.Offload && playData(hdr) {
+			err = p.sender.WriteToAddrPort(pkt, f.Addr())
+		} else {
+			err = up.Add(pkt, f.Addr())
+		}
+		if err != nil {
+			return p.close(err)
 		}
+	default:
+		p.dropped.unknownKind.Inc()
+		p.config.logger.Warn("unknown kind from client", slog.String("kind", kind.String()), slog.String("client", caddr.String()))
 	}
+	return nil
 }
 
 func (p *Gateway) donwlinkService() (_ error) {
 	var (
-		pkt = packet.Make(p.config.MaxRecvBuff)
+		msgs = make([]conn.Message, nodes.BatchSize)
+		down = conn.NewBatch(p.conn, nodes.BatchSize)
 	)
+	for i := range msgs {
+		msgs[i].Packet = packet.Make(p.config.MaxRecvBuff)
+	}
 
 	for {
-		faddr, err := p.sender.ReadFromAddrPort(pkt.Sets(64, 0xffff))
+		for _, e := range msgs {
+			e.Packet.Sets(64, 0xffff)
+		}
+		n, err := p.sender.ReadBatch(msgs)
 		if err != nil {
 			return p.close(err)
-		} else if pkt.Data() == 0 {
-			continue
 		}
-		p.speed.Downlink(pkt.Data() + 20 + 8)
+		for _, e := range msgs[:n] {
+			if err := p.downlink(e.Packet, e.Addr, down); err != nil {
+				return err
+			}
+		}
+		if err := down.Flush(); err != nil {
+			return p.close(err)
+		}
+	}
+}
 
-		hdr := bvvd.Bvvd(pkt.Bytes())
+// downlink handle packet from forward, Data to client is collected by down
+func (p *Gateway) downlink(pkt *packet.Packet, faddr netip.AddrPort, down *conn.Batch) error {
+	if pkt.Data() < bvvd.Size || pkt.Data() < bvvd.Bvvd(pkt.Bytes()).Len() {
+		p.dropped.tooSmall.Inc()
+		return nil
+	}
+	p.speed.Downlink(pkt.Data() + 20 + 8)
 
-		switch kind := hdr.Kind(); kind {
-		case bvvd.Data:
-			f, err := p.fs.Get(hdr.Forward())
-			if err != nil {
-				p.config.logger.Warn(err.Error(), errorx.Trace(err))
-				continue
-			}
-			f.DownlinkID(hdr.DataID())
+	hdr := bvvd.Bvvd(pkt.Bytes())
+	p.traffic.Downlink(hdr.Kind(), pkt.Data())
+	if hdr.Kind() == bvvd.PingForward && hdr.Client().Port() == 0 {
+		p.probeReply(pkt, faddr)
+		return nil
+	}
 
-			caddr := hdr.Client()
-			hdr.SetDataID(p.cs.Client(caddr).DownlinkID())
-			if debug.Debug() && rand.Int()%100 == 99 {
-				continue // PackLossClientDownlink
-			}
+	switch kind := hdr.Kind(); kind {
+	case bvvd.Data, bvvd.Parity:
+		f, err := p.fs.Get(hdr.Forward())
+		if err != nil {
+			p.config.logger.Warn(err.Error(), errorx.Trace(err))
+			return nil
+		}
+		f.DownlinkID(hdr.DataID())
 
-			if err = p.conn.WriteToAddrPort(pkt, caddr); err != nil {
-				return p.close(err)
+		caddr := hdr.Client()
+		client := p.client(caddr)
+		if client == nil {
+			return nil // session expired
+		}
+		if client.Version() < bvvd.Version {
+			if kind == bvvd.Parity {
+				return nil // legacy client not support fec
 			}
-		case bvvd.PackLossGatewayUplink:
-			if err = p.conn.WriteToAddrPort(pkt, hdr.Client()); err != nil {
-				return p.close(err)
+			if hdr = bvvd.Strip(pkt); hdr.Is6() {
+				return nil // legacy client not support ipv6 layout
 			}
-		case bvvd.PingForward, bvvd.PingServer:
-			if err = p.conn.WriteToAddrPort(pkt, hdr.Client()); err != nil {
-				return p.close(err)
+		}
+		hdr.SetDataID(client.DownlinkID())
+		if debug.Debug() && rand.Int()%100 == 99 {
+			return nil // PackLossClientDownlink
+		}
+
+		if m := client.MAC(); m != nil {
+			m.Sign(pkt)
+		}
+		if p.config.Offload && playData(hdr) {
+			err = p.conn.WriteToAddrPort(pkt, caddr)
+		} else {
+			err = down.Add(pkt, caddr)
+		}
+		if err != nil {
+			return p.close(err)
+		}
+	case bvvd.PackLossGatewayUplink, bvvd.PingForward, bvvd.PingServer:
+		caddr := hdr.Client()
+		client := p.client(caddr)
+		if client == nil {
+			return nil // session expired
+		}
+
+		if err := p.writeToClient(pkt, client, caddr); err != nil {
+			return p.close(err)
+		}
+	default:
+		p.dropped.unknownKind.Inc()
+		p.config.logger.Warn("invalid kind from forward", slog.String("kind", kind.String()), slog.String("forward", faddr.String()))
+	}
+	return nil
+}
+
+// playData fec protected Data or Parity, it is latency sensitive, so not
+// coalesced with bulk Data
+func playData(hdr bvvd.Bvvd) bool {
+	_, protected := fec.Parse(hdr)
+	return protected || hdr.Kind() == bvvd.Parity
+}
+
+// client get client state, require authenticated if authentication enabled
+func (p *Gateway) client(caddr netip.AddrPort) *Client {
+	if p.config.Authenticator == nil {
+		return p.cs.Client(caddr)
+	}
+	return p.cs.Get(caddr)
+}
+
+// writeToClient write packet to client, append mac trailer if session require
+func (p *Gateway) writeToClient(pkt *packet.Packet, client *Client, caddr netip.AddrPort) error {
+	if m := client.MAC(); m != nil {
+		m.Sign(pkt)
+	}
+	return p.conn.WriteToAddrPort(pkt, caddr)
+}
+
+func (p *Gateway) sessionService(pkt *packet.Packet, caddr netip.AddrPort) (_ error) {
+	var (
+		m       = (*msg.Message)(pkt)
+		hello   msg.Hello
+		session msg.Session
+	)
+	if pkt.Data() < m.Size()+msg.SessionSize {
+		// reply not larger than request, prevent amplification by spoofed source
+		p.config.logger.Warn("session request too small", slog.Int("size", pkt.Data()), slog.String("client", caddr.String()))
+		return nil
+	}
+	if err := m.Payload(&hello); err != nil {
+		p.config.logger.Warn(err.Error(), slog.String("client", caddr.String()), errorx.Trace(err))
+		return nil
+	}
+
+	var user string
+	var token = hello.Token
+	var err error
+	if hello.Sealed {
+		token, err = p.openToken(&hello)
+	} else if p.config.MAC || p.config.Seal {
+		err = errors.New("client not seal token")
+	}
+	if err == nil && p.config.Authenticator != nil {
+		user, err = p.config.Authenticator.Authenticate(string(token))
+	}
+	if err != nil {
+		p.config.logger.Warn("authenticate failed", slog.String("client", caddr.String()), slog.String("error", err.Error()))
+	} else {
+		var flags msg.Flags
+		if p.config.MAC {
+			flags |= msg.FlagMAC
+		}
+		if p.config.Seal {
+			flags |= msg.FlagSeal
+		}
+
+		c, new, err := p.cs.Session(caddr, user, flags, &hello, p.config.SessionKey, token)
+		if err != nil {
+			return p.close(err)
+		} else if new {
+			if key := c.TunnelKey(); key != nil {
+				if err := p.conn.Add(caddr, key); err != nil {
+					return p.close(err)
+				}
+			} else {
+				p.conn.Del(caddr)
 			}
-		default:
-			p.config.logger.Warn("invalid kind from forward", slog.String("kind", kind.String()), slog.String("forward", faddr.String()))
-			continue
+			p.config.logger.Info("new session", slog.String("client", caddr.String()), slog.String("user", user))
 		}
+		session = c.Session()
+	}
+
+	// random padding, not larger than request
+	session.Padding = rand.Intn(pkt.Data() - m.Size() - msg.SessionSize + 1)
+	pkt.SetData(m.Size())
+	if err := m.SetPayload(&session); err != nil {
+		p.config.logger.Warn(err.Error(), errorx.Trace(err))
+		return nil
+	}
+	if err := p.conn.WriteToAddrPort(pkt, caddr); err != nil {
+		return p.close(err)
+	}
+	return nil
+}
+
+// maxHelloSkew max clock skew of sealed hello, limit replay of hello
+const maxHelloSkew = time.Minute * 2
+
+// openToken open sealed token of hello, reject stale hello
+func (p *Gateway) openToken(hello *msg.Hello) (msg.Token, error) {
+	if p.config.SessionKey == nil {
+		return "", errors.New("not support sealed token")
+	}
+	if d := time.Since(time.Unix(hello.Time, 0)); d > maxHelloSkew || d < -maxHelloSkew {
+		return "", errors.Errorf("stale hello %s", d)
 	}
+	token, err := tunnel.OpenToken(p.config.SessionKey, hello.Public, []byte(hello.Token), hello.AD())
+	return msg.Token(token), err
 }
@@ -4,12 +4,31 @@
 package gateway_test
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
 	"testing"
+	"time"
 
+	"github.com/lysShub/anton-planet-accelerator/bvvd"
+	"github.com/lysShub/anton-planet-accelerator/conn"
+	"github.com/lysShub/anton-planet-accelerator/nodes"
 	"github.com/lysShub/anton-planet-accelerator/nodes/gateway"
+	"github.com/lysShub/anton-planet-accelerator/nodes/inspect"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/mac"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/msg"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/shutdown"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/tunnel"
 	"github.com/lysShub/netkit/debug"
+	"github.com/lysShub/netkit/packet"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
 )
 
 // go test -race -v -tags "debug" -run TestXxxx
@@ -34,6 +53,313 @@ func TestXxxx(t *testing.T) {
 	p, err := gateway.New(":19986", &config)
 	require.NoError(t, err)
 
-	err = p.Serve()
+	err = p.Serve(context.Background())
 	require.NoError(t, err)
 }
+
+func Test_Shutdown(t *testing.T) {
+	shutdown.Require(t, func() (*gateway.Gateway, error) {
+		return gateway.New("127.0.0.1:0", &gateway.Config{MaxRecvBuff: 1536, MetricsAddr: "127.0.0.1:0"})
+	}, gateway.Stopped)
+}
+
+func Test_Inspect(t *testing.T) {
+	addr := "unix:" + filepath.Join(t.TempDir(), "gateway.sock")
+	p, err := gateway.New("127.0.0.1:0", &gateway.Config{MaxRecvBuff: 1536, InspectAddr: addr})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.Serve(ctx)
+	time.Sleep(time.Millisecond * 100)
+
+	faddr := netip.MustParseAddrPort("1.2.3.4:19986")
+	require.NoError(t, p.AddForwardWithLocation(faddr, bvvd.Moscow))
+
+	body, err := inspect.Get(addr, "/clients")
+	require.NoError(t, err)
+	require.JSONEq(t, `[]`, string(body))
+
+	body, err = inspect.Get(addr, "/forwards")
+	require.NoError(t, err)
+	var fs []gateway.ForwardInfo
+	require.NoError(t, json.Unmarshal(body, &fs))
+	require.Equal(t, 1, len(fs))
+	require.Equal(t, faddr, fs[0].Addr)
+	require.Equal(t, bvvd.Moscow.String(), fs[0].Location)
+
+	body, err = inspect.Get(addr, "/forwards/"+faddr.String())
+	require.NoError(t, err)
+	var f gateway.ForwardInfo
+	require.NoError(t, json.Unmarshal(body, &f))
+	require.Equal(t, fs[0], f)
+
+	_, err = inspect.Get(addr, "/forwards/5.6.7.8:19986")
+	require.ErrorContains(t, err, "not found")
+	_, err = inspect.Get(addr, "/clients/invalid")
+	require.ErrorContains(t, err, "invalid client address")
+}
+
+func Test_Config(t *testing.T) {
+	for _, c := range []*gateway.Config{
+		{MAC: true},
+		{Seal: true},
+		{MAC: true, Authenticator: gateway.HTTPAuth("http://127.0.0.1")},
+		{Transports: []string{"sctp"}},
+		{Transports: []string{nodes.UDP, nodes.UDP}},
+	} {
+		_, err := gateway.New("127.0.0.1:0", c)
+		require.Error(t, err)
+	}
+}
+
+func Test_Transports(t *testing.T) {
+	var gaddr = netip.MustParseAddrPort("127.0.0.1:19976")
+	p, err := gateway.New(gaddr.String(), &gateway.Config{
+		MaxRecvBuff: 1536,
+		Transports:  []string{nodes.UDP, nodes.TCP},
+	})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.Serve(ctx)
+	time.Sleep(time.Millisecond * 100)
+
+	for _, transport := range []string{nodes.UDP, nodes.TCP} {
+		network, err := nodes.ClientNetwork(transport)
+		require.NoError(t, err)
+		c, err := conn.Bind(network, "127.0.0.1:0")
+		require.NoError(t, err)
+		defer c.Close()
+
+		var m = msg.Fields{MsgID: 1}
+		m.Kind = bvvd.PingGateway
+		m.Forward = netip.MustParseAddrPort("1.2.3.4:19986")
+		pkt := packet.Make(64, msg.MinSize)
+		require.NoError(t, m.Encode(pkt))
+		require.NoError(t, c.WriteToAddrPort(pkt, gaddr))
+
+		var replied = make(chan error, 1)
+		go func() {
+			for {
+				pkt := packet.Make(64, 1536)
+				raddr, err := c.ReadFromAddrPort(pkt)
+				if err != nil {
+					replied <- err
+					return
+				} else if pkt.Data() > 0 {
+					if raddr != gaddr || (*msg.Message)(pkt).Kind() != bvvd.PingGateway {
+						err = errors.Errorf("invalid reply from %s", raddr)
+					}
+					replied <- err
+					return
+				}
+			}
+		}()
+		select {
+		case err := <-replied:
+			require.NoError(t, err, transport)
+		case <-time.After(time.Second * 3):
+			t.Fatal(transport, "not reply")
+		}
+	}
+}
+
+func Test_DualStack(t *testing.T) {
+	p, err := gateway.New(":19974", &gateway.Config{MaxRecvBuff: 1536})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.Serve(ctx)
+	time.Sleep(time.Millisecond * 100)
+
+	c, err := conn.Bind("udp6", "[::1]:0")
+	require.NoError(t, err)
+	defer c.Close()
+
+	var m = msg.Fields{MsgID: 1}
+	m.Kind = bvvd.PingGateway
+	m.Forward = netip.MustParseAddrPort("1.2.3.4:19986")
+	pkt := packet.Make(64, msg.MinSize)
+	require.NoError(t, m.Encode(pkt))
+	gaddr := netip.MustParseAddrPort("[::1]:19974")
+	require.NoError(t, c.WriteToAddrPort(pkt, gaddr))
+
+	require.NoError(t, c.(interface{ SetReadDeadline(time.Time) error }).SetReadDeadline(time.Now().Add(time.Second*3)))
+	raddr, err := c.ReadFromAddrPort(pkt.Sets(64, 1536))
+	require.NoError(t, err)
+	require.Equal(t, gaddr, raddr)
+	require.Equal(t, bvvd.PingGateway, (*msg.Message)(pkt).Kind())
+}
+
+func Test_Offload(t *testing.T) {
+	var gaddr = netip.MustParseAddrPort("127.0.0.1:19975")
+	p, err := gateway.New(gaddr.String(), &gateway.Config{
+		MaxRecvBuff: 1536,
+		Offload:     true,
+	})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.Serve(ctx)
+	time.Sleep(time.Millisecond * 100)
+
+	bind := func(network string) conn.Conn {
+		c, err := conn.Bind(network, "127.0.0.1:0")
+		require.NoError(t, err)
+		t.Cleanup(func() { c.Close() })
+		require.NoError(t, conn.EnableOffload(c))
+		return c
+	}
+	var client, forward = bind("udp4"), bind("udp")
+	require.NoError(t, p.AddForwardWithLocation(forward.LocalAddr(), bvvd.Moscow))
+
+	// read n Data, skip probe of gateway
+	read := func(c conn.Conn, n int) (msgs []conn.Message) {
+		require.NoError(t, c.(interface{ SetReadDeadline(time.Time) error }).SetReadDeadline(time.Now().Add(time.Second*3)))
+		for len(msgs) < n {
+			pkt := packet.Make(64, 1536)
+			addr, err := c.ReadFromAddrPort(pkt)
+			require.NoError(t, err)
+			if bvvd.Bvvd(pkt.Bytes()).Kind() == bvvd.Data {
+				msgs = append(msgs, conn.Message{Packet: pkt, Addr: addr})
+			}
+		}
+		return msgs
+	}
+
+	// coalesced bulk Data is split by gateway, and coalesced again to peer
+	var msgs = make([]conn.Message, 8)
+	for i := range msgs {
+		var hdr = bvvd.Fields{
+			Kind: bvvd.Data, Proto: header.UDPProtocolNumber,
+			Forward: forward.LocalAddr(), Server: netip.MustParseAddr("1.2.3.4"),
+		}
+		pkt := packet.Make(64).Append(bytes.Repeat([]byte{byte(i)}, 1000)...)
+		require.NoError(t, hdr.Encode(pkt))
+		msgs[i] = conn.Message{Packet: pkt, Addr: gaddr}
+	}
+	_, err = client.WriteBatch(msgs)
+	require.NoError(t, err)
+
+	msgs = read(forward, len(msgs))
+	for i, e := range msgs {
+		hdr := bvvd.Bvvd(e.Packet.Bytes())
+		require.Equal(t, client.LocalAddr(), hdr.Client())
+		require.Equal(t, bytes.Repeat([]byte{byte(i)}, 1000), e.Packet.Bytes()[hdr.Len():])
+	}
+	_, err = forward.WriteBatch(msgs)
+	require.NoError(t, err)
+
+	for i, e := range read(client, len(msgs)) {
+		require.Equal(t, gaddr, e.Addr)
+		hdr := bvvd.Bvvd(e.Packet.Bytes())
+		require.Equal(t, bytes.Repeat([]byte{byte(i)}, 1000), e.Packet.Bytes()[hdr.Len():])
+	}
+}
+
+func Test_Session(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens")
+	require.NoError(t, os.WriteFile(path, []byte("alice t0ken\n"), 0o666))
+	auth, err := gateway.FileAuth(path)
+	require.NoError(t, err)
+
+	static, spub, err := tunnel.KeyPair()
+	require.NoError(t, err)
+
+	var gaddr = netip.MustParseAddrPort("127.0.0.1:19973")
+	p, err := gateway.New(gaddr.String(), &gateway.Config{
+		MaxRecvBuff:   1536,
+		Authenticator: auth,
+		MAC:           true,
+		SessionKey:    static,
+		SessionRate:   0.01,
+		SessionBurst:  3,
+	})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go p.Serve(ctx)
+	time.Sleep(time.Millisecond * 100)
+
+	c, err := conn.Bind("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer c.Close()
+
+	send := func(payload msg.Payload) {
+		var m = msg.Fields{MsgID: 1, Payload: payload}
+		m.Kind = bvvd.Session
+		pkt := packet.Make(64)
+		require.NoError(t, m.Encode(pkt))
+		require.NoError(t, c.WriteToAddrPort(pkt, gaddr))
+	}
+	// replies read until timeout
+	replies := func() (ss []msg.Session) {
+		require.NoError(t, c.(interface{ SetReadDeadline(time.Time) error }).SetReadDeadline(time.Now().Add(time.Second)))
+		for {
+			pkt := packet.Make(64, 1536)
+			if _, err := c.ReadFromAddrPort(pkt); err != nil {
+				return ss
+			}
+			var s msg.Session
+			require.NoError(t, (*msg.Message)(pkt).Payload(&s))
+			ss = append(ss, s)
+		}
+	}
+
+	// not reply request smaller than reply
+	token := msg.Token("t0ken")
+	send(&token)
+	require.Empty(t, replies())
+
+	// mac require token sealed by session key
+	priv, pub, err := tunnel.KeyPair()
+	require.NoError(t, err)
+	send(&msg.Hello{Token: "t0ken", Public: pub, Version: bvvd.Version})
+	ss := replies()
+	require.Equal(t, 1, len(ss))
+	require.True(t, ss[0].Rejected())
+
+	var hello = msg.Hello{Public: pub, Version: bvvd.Version, Sealed: true, Time: time.Now().Unix()}
+	sealed, err := tunnel.SealToken(priv, spub, []byte("t0ken"), hello.AD())
+	require.NoError(t, err)
+	hello.Token = msg.Token(sealed)
+	for range 4 {
+		send(&hello)
+	}
+	ss = replies()
+	require.Equal(t, 1, len(ss), "exceed burst")
+	require.False(t, ss[0].Rejected())
+	require.True(t, ss[0].Flags.Has(msg.FlagMAC))
+	require.Equal(t, uint8(bvvd.Version), ss[0].Version)
+
+	// mac key is derived, not transmitted, gateway confirm it hold session key
+	ee, err := tunnel.ECDH(priv, ss[0].Public)
+	require.NoError(t, err)
+	es, err := tunnel.ECDH(priv, spub)
+	require.NoError(t, err)
+	keys, err := tunnel.Derive(ss[0].Salt(pub, "t0ken"), ee, es)
+	require.NoError(t, err)
+	require.Equal(t, keys.Confirm, ss[0].Confirm)
+	m := mac.New(keys.MAC, true)
+
+	var ping = msg.Fields{MsgID: 2}
+	ping.Kind = bvvd.PingGateway
+	ping.Forward = netip.MustParseAddrPort("1.2.3.4:19986")
+	pkt := packet.Make(64)
+	require.NoError(t, ping.Encode(pkt))
+	m.Sign(pkt)
+	signed := slices.Clone(pkt.Bytes())
+	require.NoError(t, c.WriteToAddrPort(pkt, gaddr))
+
+	require.NoError(t, c.(interface{ SetReadDeadline(time.Time) error }).SetReadDeadline(time.Now().Add(time.Second)))
+	pkt = packet.Make(64, 1536)
+	_, err = c.ReadFromAddrPort(pkt)
+	require.NoError(t, err)
+	require.True(t, m.Verify(pkt))
+	require.Equal(t, bvvd.PingGateway, (*msg.Message)(pkt).Kind())
+
+	// replay is dropped
+	require.NoError(t, c.WriteToAddrPort(packet.Make(64).Append(signed...), gaddr))
+	_, err = c.ReadFromAddrPort(pkt.Sets(64, 1536))
+	require.Error(t, err)
+}
@@ -0,0 +1,121 @@
+//go:build linux
+// +build linux
+
+package gateway
+
+import (
+	"net/http"
+	"net/netip"
+	"slices"
+
+	"github.com/lysShub/anton-planet-accelerator/nodes/inspect"
+)
+
+type ClientInfo struct {
+	Addr          netip.AddrPort `json:"addr"`
+	User          string         `json:"user,omitempty"`
+	Authenticated bool           `json:"authenticated"`
+	MAC           bool           `json:"mac"`
+	Seal          bool           `json:"seal"`
+	UplinkPL      float64        `json:"uplink_pl"`
+}
+
+type ForwardInfo struct {
+	Addr       netip.AddrPort `json:"addr"`
+	Location   string         `json:"location"`
+	DownlinkPL float64        `json:"downlink_pl"`
+	ProbePL    float64        `json:"probe_pl"`
+	RTT        string         `json:"rtt"`
+	Down       bool           `json:"down"`
+	Draining   bool           `json:"draining"`
+}
+
+// Clients get known clients, include not authenticated
+func (p *Gateway) Clients() []ClientInfo {
+	var cs = []ClientInfo{}
+	p.cs.Range(func(caddr netip.AddrPort, c *Client) {
+		cs = append(cs, clientInfo(caddr, c))
+	})
+	slices.SortFunc(cs, func(a, b ClientInfo) int { return a.Addr.Compare(b.Addr) })
+	return cs
+}
+
+func clientInfo(caddr netip.AddrPort, c *Client) ClientInfo {
+	return ClientInfo{
+		Addr:          caddr,
+		User:          c.User(),
+		Authenticated: c.Session().ID != 0,
+		MAC:           c.MAC() != nil,
+		Seal:          c.TunnelKey() != nil,
+		UplinkPL:      float64(c.PeekUplinkPL()),
+	}
+}
+
+// Forwards get added forwards, include draining and down
+func (p *Gateway) Forwards() []ForwardInfo {
+	fs := p.fs.List()
+	slices.SortFunc(fs, func(a, b *Forward) int { return a.Addr().Compare(b.Addr()) })
+
+	var infos = make([]ForwardInfo, 0, len(fs))
+	for _, f := range fs {
+		infos = append(infos, forwardInfo(f))
+	}
+	return infos
+}
+
+func forwardInfo(f *Forward) ForwardInfo {
+	return ForwardInfo{
+		Addr:       f.Addr(),
+		Location:   f.loc.String(),
+		DownlinkPL: float64(f.PeekDownlinkPL()),
+		ProbePL:    float64(f.ProbePL()),
+		RTT:        f.RTT().String(),
+		Down:       f.Down(),
+		Draining:   f.Draining(),
+	}
+}
+
+// inspectHandler read-only api of live state
+func (p *Gateway) inspectHandler() http.Handler {
+	var mux = http.NewServeMux()
+	mux.HandleFunc("GET /clients", func(w http.ResponseWriter, r *http.Request) {
+		inspect.JSON(w, p.Clients())
+	})
+	mux.HandleFunc("GET /clients/{addr}", func(w http.ResponseWriter, r *http.Request) {
+		caddr, err := netip.ParseAddrPort(r.PathValue("addr"))
+		if err != nil {
+			inspect.Error(w, http.StatusBadRequest, "invalid client address %q", r.PathValue("addr"))
+			return
+		}
+		// not Clients.Get, that will keep client alive
+		var info *ClientInfo
+		p.cs.Range(func(addr netip.AddrPort, c *Client) {
+			if addr == caddr {
+				i := clientInfo(addr, c)
+				info = &i
+			}
+		})
+		if info == nil {
+			inspect.Error(w, http.StatusNotFound, "client %s not found", caddr.String())
+			return
+		}
+		inspect.JSON(w, info)
+	})
+	mux.HandleFunc("GET /forwards", func(w http.ResponseWriter, r *http.Request) {
+		inspect.JSON(w, p.Forwards())
+	})
+	mux.HandleFunc("GET /forwards/{addr}", func(w http.ResponseWriter, r *http.Request) {
+		faddr, err := netip.ParseAddrPort(r.PathValue("addr"))
+		if err != nil {
+			inspect.Error(w, http.StatusBadRequest, "invalid forward address %q", r.PathValue("addr"))
+			return
+		}
+		f, err := p.fs.Get(faddr)
+		if err != nil {
+			inspect.Error(w, http.StatusNotFound, "forward %s not found", faddr.String())
+			return
+		}
+		inspect.JSON(w, forwardInfo(f))
+	})
+	return mux
+}
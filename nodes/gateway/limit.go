@@ -0,0 +1,97 @@
+package gateway
+
+import (
+	"net/netip"
+	"sync"
+	"time"
+)
+
+// limiter limit session handshakes, rate of each source by token bucket,
+// and concurrent handshakes by semaphore. ipv6 source is limited by /64
+// prefix. idle buckets are swept lazily, without timer.
+type limiter struct {
+	mu      sync.Mutex
+	rate    float64 // tokens per second
+	burst   float64
+	buckets map[netip.Addr]*bucket
+	swept   time.Time
+
+	sem chan struct{}
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+const (
+	// maxBuckets max tracked sources, new source is rejected if exceeded
+	maxBuckets = 1 << 16
+
+	sweepInterval = time.Minute
+)
+
+func newLimiter(rate float64, burst, concurrency int) *limiter {
+	return &limiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: map[netip.Addr]*bucket{},
+		swept:   time.Now(),
+		sem:     make(chan struct{}, concurrency),
+	}
+}
+
+// Acquire report whether handshake from addr is allowed, Release should be
+// called after handshake if allowed
+func (l *limiter) Acquire(addr netip.Addr) bool {
+	if !l.allow(addr, time.Now()) {
+		return false
+	}
+	select {
+	case l.sem <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (l *limiter) Release() { <-l.sem }
+
+func (l *limiter) allow(addr netip.Addr, now time.Time) bool {
+	if addr = addr.Unmap(); addr.Is6() {
+		addr = netip.PrefixFrom(addr, 64).Masked().Addr()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if d := now.Sub(l.swept); d > sweepInterval || (len(l.buckets) >= maxBuckets && d > time.Second) {
+		l.sweep(now)
+	}
+
+	b := l.buckets[addr]
+	if b == nil {
+		if len(l.buckets) >= maxBuckets {
+			return false
+		}
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[addr] = b
+	} else {
+		b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+		b.last = now
+	}
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// sweep delete buckets refilled to burst, they are same as not existed
+func (l *limiter) sweep(now time.Time) {
+	for k, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, k)
+		}
+	}
+	l.swept = now
+}
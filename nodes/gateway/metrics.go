@@ -0,0 +1,66 @@
+//go:build linux
+// +build linux
+
+package gateway
+
+import (
+	"slices"
+
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/metrics"
+)
+
+// dropped counters of dropped packets, by reason
+type dropped struct {
+	tooSmall        *metrics.Counter
+	unknownKind     *metrics.Counter
+	unauthenticated *metrics.Counter
+	invalidMAC      *metrics.Counter
+	rateLimited     *metrics.Counter
+	legacyForward   *metrics.Counter // ipv6 layout header to legacy forward
+}
+
+func (p *Gateway) initMetrics() {
+	var m = metrics.New()
+	p.metrics = m
+	p.traffic = metrics.NewTraffic(m, "anton_gateway")
+
+	d := m.CounterVec("anton_gateway_dropped_packets_total", "dropped packets by reason", "reason")
+	p.dropped = dropped{
+		tooSmall:        d.With("too_small"),
+		unknownKind:     d.With("unknown_kind"),
+		unauthenticated: d.With("unauthenticated"),
+		invalidMAC:      d.With("invalid_mac"),
+		rateLimited:     d.With("rate_limited"),
+		legacyForward:   d.With("legacy_forward"),
+	}
+
+	m.Gauge("anton_gateway_clients", "active clients", func() float64 {
+		return float64(p.cs.Len())
+	})
+	m.GaugeVec("anton_gateway_forward_downlink_pl", "pack loss of forward to gateway", []string{"forward"}, p.forwardMetric(func(f *Forward) float64 {
+		return float64(f.PeekDownlinkPL())
+	}))
+	m.GaugeVec("anton_gateway_forward_probe_pl", "pack loss of forward health probe", []string{"forward"}, p.forwardMetric(func(f *Forward) float64 {
+		return float64(f.ProbePL())
+	}))
+	m.GaugeVec("anton_gateway_forward_rtt_seconds", "smoothed rtt of forward health probe", []string{"forward"}, p.forwardMetric(func(f *Forward) float64 {
+		return f.RTT().Seconds()
+	}))
+	m.GaugeVec("anton_gateway_forward_up", "forward is up, 0 if down or draining", []string{"forward"}, p.forwardMetric(func(f *Forward) float64 {
+		if f.Down() || f.Draining() {
+			return 0
+		}
+		return 1
+	}))
+}
+
+// forwardMetric collect metric of each forward
+func (p *Gateway) forwardMetric(fn func(f *Forward) float64) func(add func(float64, ...string)) {
+	return func(add func(float64, ...string)) {
+		fs := p.fs.List()
+		slices.SortFunc(fs, func(a, b *Forward) int { return a.Addr().Compare(b.Addr()) })
+		for _, f := range fs {
+			add(fn(f), f.Addr().String())
+		}
+	}
+}
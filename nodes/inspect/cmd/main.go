@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/lysShub/anton-planet-accelerator/nodes/inspect"
+	"github.com/pkg/errors"
+)
+
+// inspect tool query read-only json api of gateway or forward
+//
+//	go run . /clients
+//	go run . -addr unix:/run/gateway.sock /forwards/1.2.3.4:19986
+//	go run . -addr 127.0.0.1:19989 /links/tcp/41234
+func main() {
+	if err := run(os.Args[1:]); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "inspect:", err.Error())
+		}
+		os.Exit(1)
+	}
+}
+
+const paths = `
+gateway paths:
+  /clients                  known clients
+  /clients/{addr}           client detail
+  /forwards                 added forwards
+  /forwards/{addr}          forward detail
+forward paths:
+  /links                    active links
+  /links/{tcp|udp}/{port}   link detail by local port
+  /gateways                 active gateways
+  /gateways/{addr}          gateway detail with links
+`
+
+func run(args []string) error {
+	var (
+		fs   = flag.NewFlagSet("inspect", flag.ContinueOnError)
+		addr = fs.String("addr", "127.0.0.1:19988", "inspect address of node, unix socket with unix: prefix")
+	)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: inspect [-addr addr] path")
+		fs.PrintDefaults()
+		fmt.Fprint(fs.Output(), paths)
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if fs.NArg() != 1 {
+		fs.Usage()
+		return flag.ErrHelp
+	}
+
+	body, err := inspect.Get(*addr, fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	if err := json.Indent(&b, body, "", "  "); err != nil {
+		return errors.WithStack(err)
+	}
+	_, err = b.WriteTo(os.Stdout)
+	return err
+}
@@ -0,0 +1,138 @@
+// Package inspect read-only http json api of node live state, listen on
+// loopback address or unix socket.
+package inspect
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const unix = "unix:"
+
+// Listen listen local address, addr is loopback tcp address such as
+// 127.0.0.1:19988, or unix socket path with unix: prefix
+func Listen(addr string) (net.Listener, error) {
+	if path, ok := strings.CutPrefix(addr, unix); ok {
+		// remove stale socket of previous process
+		if fi, err := os.Stat(path); err == nil && fi.Mode().Type() == fs.ModeSocket {
+			os.Remove(path)
+		}
+		l, err := net.Listen("unix", path)
+		return l, errors.WithStack(err)
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if host != "localhost" {
+		ip := net.ParseIP(host)
+		if ip == nil || !ip.IsLoopback() {
+			return nil, errors.Errorf("inspect address %q is not loopback", addr)
+		}
+	}
+	l, err := net.Listen("tcp", addr)
+	return l, errors.WithStack(err)
+}
+
+type Server struct {
+	l net.Listener
+	s *http.Server
+}
+
+func NewServer(addr string, handler http.Handler) (*Server, error) {
+	l, err := Listen(addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{
+		l: l,
+		s: &http.Server{Handler: handler, ReadHeaderTimeout: time.Second * 5},
+	}, nil
+}
+
+// Addr listened address, unix socket with unix: prefix
+func (s *Server) Addr() string {
+	if s.l.Addr().Network() == "unix" {
+		return unix + s.l.Addr().String()
+	}
+	return s.l.Addr().String()
+}
+
+// Serve serve until closed, return nil if closed
+func (s *Server) Serve() error {
+	if err := s.s.Serve(s.l); !errors.Is(err, http.ErrServerClosed) {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (s *Server) Close() error {
+	err := s.s.Close()
+	// listener maybe closed by http server
+	if e := s.l.Close(); err == nil && !errors.Is(e, net.ErrClosed) {
+		err = e
+	}
+	return errors.WithStack(err)
+}
+
+// JSON reply v as json
+func JSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+// Error reply error message as json
+func Error(w http.ResponseWriter, code int, format string, args ...any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(struct {
+		Error string `json:"error"`
+	}{fmt.Sprintf(format, args...)})
+}
+
+// Get query path of node api, return the json body
+func Get(addr, path string) ([]byte, error) {
+	var c = &http.Client{Timeout: time.Second * 5}
+	var host = addr
+	if p, ok := strings.CutPrefix(addr, unix); ok {
+		host = "unix"
+		c.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", p)
+			},
+		}
+	}
+
+	resp, err := c.Get("http://" + host + "/" + strings.TrimPrefix(path, "/"))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &e) == nil && e.Error != "" {
+			return nil, errors.Errorf("%s: %s", resp.Status, e.Error)
+		}
+		return nil, errors.Errorf("%s", resp.Status)
+	}
+	return body, nil
+}
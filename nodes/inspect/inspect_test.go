@@ -0,0 +1,50 @@
+package inspect_test
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/lysShub/anton-planet-accelerator/nodes/inspect"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Listen(t *testing.T) {
+	for _, addr := range []string{"0.0.0.0:0", ":0", "8.8.8.8:0", "example.com:0", "127.0.0.1"} {
+		_, err := inspect.Listen(addr)
+		require.Error(t, err, addr)
+	}
+
+	for _, addr := range []string{"127.0.0.1:0", "localhost:0", "unix:" + filepath.Join(t.TempDir(), "inspect.sock")} {
+		l, err := inspect.Listen(addr)
+		require.NoError(t, err, addr)
+		require.NoError(t, l.Close())
+	}
+}
+
+func Test_Server(t *testing.T) {
+	var mux = http.NewServeMux()
+	mux.HandleFunc("GET /items", func(w http.ResponseWriter, r *http.Request) {
+		inspect.JSON(w, []int{1, 2})
+	})
+	mux.HandleFunc("GET /items/{id}", func(w http.ResponseWriter, r *http.Request) {
+		inspect.Error(w, http.StatusNotFound, "item %s not found", r.PathValue("id"))
+	})
+
+	for _, addr := range []string{"127.0.0.1:0", "unix:" + filepath.Join(t.TempDir(), "inspect.sock")} {
+		s, err := inspect.NewServer(addr, mux)
+		require.NoError(t, err)
+		var served = make(chan error, 1)
+		go func() { served <- s.Serve() }()
+
+		body, err := inspect.Get(s.Addr(), "/items")
+		require.NoError(t, err)
+		require.JSONEq(t, `[1,2]`, string(body))
+
+		_, err = inspect.Get(s.Addr(), "items/3")
+		require.ErrorContains(t, err, "item 3 not found")
+
+		require.NoError(t, s.Close())
+		require.NoError(t, <-served)
+	}
+}
@@ -35,10 +35,10 @@ func ChecksumClient(pkt *packet.Packet, proto uint8, dst netip.Addr) {
 	srcPort := t.SourcePort()
 	t.SetSourcePort(0)
 	t.SetChecksum(0)
+	src, dst1 := pseudoAddrs(dst)
 	sum := header.PseudoHeaderChecksum(
 		tcpip.TransportProtocolNumber(proto),
-		ip4zero,
-		tcpip.AddrFrom4(dst.As4()),
+		src, dst1,
 		uint16(pkt.Data()),
 	)
 	t.SetChecksum(^checksum.Checksum(pkt.Bytes(), sum))
@@ -58,10 +58,10 @@ func ValidChecksum(pkt *packet.Packet, proto uint8, dst netip.Addr) bool {
 	srcPort := t.SourcePort()
 	defer t.SetSourcePort(srcPort)
 
+	src, dst1 := pseudoAddrs(dst)
 	sum := header.PseudoHeaderChecksum(
 		tcpip.TransportProtocolNumber(proto),
-		ip4zero,
-		tcpip.AddrFrom4(dst.As4()),
+		src, dst1,
 		uint16(pkt.Data()),
 	)
 	t.SetSourcePort(0)
@@ -69,10 +69,23 @@ func ValidChecksum(pkt *packet.Packet, proto uint8, dst netip.Addr) bool {
 	return sum == 0xffff
 }
 
-var ip4zero = tcpip.AddrFrom4([4]byte{})
+var (
+	ip4zero = tcpip.AddrFrom4([4]byte{})
+	ip6zero = tcpip.AddrFrom16([16]byte{})
+)
+
+// pseudoAddrs pseudo header src(zero) and dst address, family follow dst
+func pseudoAddrs(dst netip.Addr) (tcpip.Address, tcpip.Address) {
+	if dst = dst.Unmap(); dst.Is4() {
+		return ip4zero, tcpip.AddrFrom4(dst.As4())
+	}
+	return ip6zero, tcpip.AddrFrom16(dst.As16())
+}
 
+// ChecksumForward update checksum which calculated by ChecksumClient, the
+// family of loc must same as the server.
 func ChecksumForward(pkt *packet.Packet, proto uint8, loc netip.AddrPort) {
-	sum := checksum.Checksum(loc.Addr().AsSlice(), loc.Port())
+	sum := checksum.Checksum(loc.Addr().Unmap().AsSlice(), loc.Port())
 
 	var t header.Transport
 	switch proto {
@@ -11,6 +11,7 @@ import (
 	"github.com/lysShub/rawsock/test"
 	"github.com/stretchr/testify/require"
 	"gvisor.dev/gvisor/pkg/tcpip"
+	stdsum "gvisor.dev/gvisor/pkg/tcpip/checksum"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
 )
 
@@ -49,3 +50,31 @@ func Test_Checksum(t *testing.T) {
 	))
 
 }
+
+func Test_Checksum6(t *testing.T) {
+	var (
+		local  = netip.MustParseAddrPort("[2001:db8::1]:1234")
+		server = netip.MustParseAddrPort("[2001:db8::2]:80")
+		pkt    = packet.Make(0, 20)
+	)
+	header.TCP(pkt.Bytes()).Encode(&header.TCPFields{
+		SrcPort:    uint16(rand.Uint32()),
+		DstPort:    server.Port(),
+		SeqNum:     rand.Uint32(),
+		AckNum:     rand.Uint32(),
+		DataOffset: header.TCPMinimumSize,
+		WindowSize: uint16(rand.Uint32()),
+	})
+
+	checksum.ChecksumClient(pkt, syscall.IPPROTO_TCP, server.Addr())
+	require.True(t, checksum.ValidChecksum(pkt, syscall.IPPROTO_TCP, server.Addr()))
+
+	checksum.ChecksumForward(pkt, syscall.IPPROTO_TCP, local)
+	sum := header.PseudoHeaderChecksum(
+		header.TCPProtocolNumber,
+		tcpip.AddrFrom16(local.Addr().As16()),
+		tcpip.AddrFrom16(server.Addr().As16()),
+		uint16(pkt.Data()),
+	)
+	require.Equal(t, uint16(0xffff), stdsum.Checksum(pkt.Bytes(), sum))
+}
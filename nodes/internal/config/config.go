@@ -0,0 +1,175 @@
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// Parse parse v from command line args, environment variables and config
+// file, priority: args > env > file > default value of v.
+//
+// v must be pointer to struct, supported field type: string, int, bool and
+// []string(comma separated in args and env), field is tagged by:
+//
+//	`flag:"name"` command line flag, usage is tagged by `usage:"..."`
+//	`env:"NAME"`  environment variable, with prefix
+//	`json:"name" yaml:"name" toml:"name"` config file key
+//
+// config file is specified by flag -config or environment variable
+// prefix+"CONFIG", format decided by extension: .json .yaml .yml .toml
+func Parse(name, prefix string, v any, args []string) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.Elem().Kind() != reflect.Struct {
+		return errors.Errorf("require struct pointer, not %T", v)
+	}
+	rv = rv.Elem()
+
+	var fs = flag.NewFlagSet(name, flag.ContinueOnError)
+	var path = fs.String("config", os.Getenv(prefix+"CONFIG"), "config file path, support .json .yaml .yml .toml")
+	var flags = map[string]string{}
+	for i := 0; i < rv.NumField(); i++ {
+		field := rv.Type().Field(i)
+		if name := field.Tag.Get("flag"); name != "" {
+			fs.Var(&value{flags: flags, name: name, def: rv.Field(i)}, name, field.Tag.Get("usage"))
+		}
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if fs.NArg() > 0 {
+		return errors.Errorf("unexpect arguments %s", strings.Join(fs.Args(), " "))
+	}
+
+	if *path != "" {
+		if err := Load(*path, v); err != nil {
+			return err
+		}
+	}
+
+	for i := 0; i < rv.NumField(); i++ {
+		field := rv.Type().Field(i)
+		if name := field.Tag.Get("env"); name != "" {
+			if s, has := os.LookupEnv(prefix + name); has {
+				if err := set(rv.Field(i), s); err != nil {
+					return errors.WithMessagef(err, "environment variable %s", prefix+name)
+				}
+			}
+		}
+		if name := field.Tag.Get("flag"); name != "" {
+			if s, has := flags[name]; has {
+				if err := set(rv.Field(i), s); err != nil {
+					return errors.WithMessagef(err, "flag -%s", name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Load decode config file to v, format decided by extension
+func Load(path string, v any) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".json":
+		err = json.Unmarshal(b, v)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, v)
+	case ".toml":
+		err = toml.Unmarshal(b, v)
+	default:
+		return errors.Errorf("not support config file format %q", ext)
+	}
+	if err != nil {
+		return errors.WithMessagef(err, "config file %s", path)
+	}
+	return nil
+}
+
+// value flag.Value record flag, that will be set after config file loaded
+type value struct {
+	flags map[string]string
+	name  string
+	def   reflect.Value
+}
+
+func (v *value) String() string {
+	if v == nil || !v.def.IsValid() {
+		return ""
+	}
+	switch v.def.Kind() {
+	case reflect.Slice:
+		return strings.Join(v.def.Interface().([]string), ",")
+	default:
+		return format(v.def)
+	}
+}
+
+func (v *value) Set(s string) error {
+	if err := set(reflect.New(v.def.Type()).Elem(), s); err != nil {
+		return err
+	}
+	v.flags[v.name] = s
+	return nil
+}
+
+func (v *value) IsBoolFlag() bool {
+	return v.def.IsValid() && v.def.Kind() == reflect.Bool
+}
+
+func format(v reflect.Value) string {
+	switch v.Kind() {
+	case reflect.String:
+		return v.String()
+	case reflect.Int:
+		return strconv.FormatInt(v.Int(), 10)
+	case reflect.Bool:
+		return strconv.FormatBool(v.Bool())
+	default:
+		return ""
+	}
+}
+
+func set(v reflect.Value, s string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return errors.Errorf("invalid integer %q", s)
+		}
+		v.SetInt(int64(n))
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return errors.Errorf("invalid boolean %q", s)
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		if v.Type().Elem().Kind() != reflect.String {
+			return errors.Errorf("not support type %s", v.Type())
+		}
+		var ss []string
+		for _, e := range strings.Split(s, ",") {
+			if e = strings.TrimSpace(e); e != "" {
+				ss = append(ss, e)
+			}
+		}
+		v.Set(reflect.ValueOf(ss))
+	default:
+		return errors.Errorf("not support type %s", v.Type())
+	}
+	return nil
+}
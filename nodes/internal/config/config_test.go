@@ -0,0 +1,100 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/config"
+	"github.com/stretchr/testify/require"
+)
+
+type Config struct {
+	Listen   string   `json:"listen" yaml:"listen" toml:"listen" flag:"listen" env:"LISTEN"`
+	Buff     int      `json:"buff" yaml:"buff" toml:"buff" flag:"buff" env:"BUFF"`
+	Debug    bool     `json:"debug" yaml:"debug" toml:"debug" flag:"debug" env:"DEBUG"`
+	Forwards []string `json:"forwards" yaml:"forwards" toml:"forwards" flag:"forwards" env:"FORWARDS"`
+}
+
+func Test_Load(t *testing.T) {
+	var files = map[string]string{
+		"c.json": `{"listen":":1","buff":2,"debug":true,"forwards":["a","b"]}`,
+		"c.yaml": "listen: \":1\"\nbuff: 2\ndebug: true\nforwards: [a, b]\n",
+		"c.yml":  "listen: \":1\"\nbuff: 2\ndebug: true\nforwards: [a, b]\n",
+		"c.toml": "listen = \":1\"\nbuff = 2\ndebug = true\nforwards = [\"a\", \"b\"]\n",
+	}
+	dir := t.TempDir()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(path, []byte(data), 0o666))
+
+		var c Config
+		require.NoError(t, config.Load(path, &c), name)
+		require.Equal(t, Config{":1", 2, true, []string{"a", "b"}}, c, name)
+	}
+
+	t.Run("unknown format", func(t *testing.T) {
+		path := filepath.Join(dir, "c.ini")
+		require.NoError(t, os.WriteFile(path, nil, 0o666))
+
+		var c Config
+		require.Error(t, config.Load(path, &c))
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		path := filepath.Join(dir, "invalid.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{"buff":"x"}`), 0o666))
+
+		var c Config
+		require.Error(t, config.Load(path, &c))
+	})
+}
+
+func Test_Parse(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "c.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("listen: \":1\"\nbuff: 2\n"), 0o666))
+
+	t.Run("default", func(t *testing.T) {
+		var c = Config{Listen: ":0", Buff: 1}
+		require.NoError(t, config.Parse("test", "TEST_", &c, nil))
+		require.Equal(t, Config{Listen: ":0", Buff: 1}, c)
+	})
+
+	t.Run("file", func(t *testing.T) {
+		var c = Config{Listen: ":0", Buff: 1}
+		require.NoError(t, config.Parse("test", "TEST_", &c, []string{"-config", path}))
+		require.Equal(t, Config{Listen: ":1", Buff: 2}, c)
+	})
+
+	t.Run("env", func(t *testing.T) {
+		t.Setenv("TEST_CONFIG", path)
+		t.Setenv("TEST_BUFF", "3")
+		t.Setenv("TEST_FORWARDS", "a, b")
+
+		var c Config
+		require.NoError(t, config.Parse("test", "TEST_", &c, nil))
+		require.Equal(t, Config{":1", 3, false, []string{"a", "b"}}, c)
+	})
+
+	t.Run("flag", func(t *testing.T) {
+		t.Setenv("TEST_BUFF", "3")
+
+		var c Config
+		require.NoError(t, config.Parse("test", "TEST_", &c, []string{
+			"-config", path, "-buff", "4", "-debug", "-forwards", "c",
+		}))
+		require.Equal(t, Config{":1", 4, true, []string{"c"}}, c)
+	})
+
+	t.Run("invalid flag", func(t *testing.T) {
+		var c Config
+		require.Error(t, config.Parse("test", "TEST_", &c, []string{"-buff", "x"}))
+	})
+
+	t.Run("invalid env", func(t *testing.T) {
+		t.Setenv("TEST_DEBUG", "x")
+
+		var c Config
+		require.Error(t, config.Parse("test", "TEST_", &c, nil))
+	})
+}
@@ -0,0 +1,150 @@
+//go:build linux
+// +build linux
+
+package ethtool
+
+import (
+	"encoding/binary"
+	"unsafe"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+)
+
+// refer linux/ethtool.h
+
+const (
+	ethSSFeatures = 4  // ETH_SS_FEATURES
+	stringLen     = 32 // ETH_GSTRING_LEN
+)
+
+// Feature offload feature state, refer ethtool -k
+type Feature struct {
+	Active bool
+	Fixed  bool // can't be changed
+}
+
+// Features get offload features of interface
+func Features(iface string) (map[string]Feature, error) {
+	names, err := featureNames(iface)
+	if err != nil {
+		return nil, err
+	}
+
+	// struct ethtool_gfeatures
+	size := (len(names) + 31) / 32
+	b := make([]byte, 8+size*16)
+	binary.NativeEndian.PutUint32(b[0:], unix.ETHTOOL_GFEATURES)
+	binary.NativeEndian.PutUint32(b[4:], uint32(size))
+	if err := ioctl(iface, unsafe.Pointer(&b[0])); err != nil {
+		return nil, errors.WithMessagef(err, "get %s features", iface)
+	}
+
+	var fs = make(map[string]Feature, len(names))
+	for i, name := range names {
+		// struct ethtool_get_features_block
+		block := b[8+i/32*16:]
+		bit := uint32(1) << (i % 32)
+		available := binary.NativeEndian.Uint32(block[0:])&bit != 0
+		active := binary.NativeEndian.Uint32(block[8:])&bit != 0
+		never := binary.NativeEndian.Uint32(block[12:])&bit != 0
+
+		fs[name] = Feature{Active: active, Fixed: !available || never}
+	}
+	return fs, nil
+}
+
+// SetFeatures set active state of features, unknown feature will return error
+func SetFeatures(iface string, features map[string]bool) error {
+	names, err := featureNames(iface)
+	if err != nil {
+		return err
+	}
+	var idxs = make(map[string]int, len(names))
+	for i, name := range names {
+		idxs[name] = i
+	}
+
+	// struct ethtool_sfeatures
+	size := (len(names) + 31) / 32
+	b := make([]byte, 8+size*8)
+	binary.NativeEndian.PutUint32(b[0:], unix.ETHTOOL_SFEATURES)
+	binary.NativeEndian.PutUint32(b[4:], uint32(size))
+	for name, active := range features {
+		i, has := idxs[name]
+		if !has {
+			return errors.Errorf("%s unknown feature %s", iface, name)
+		}
+
+		// struct ethtool_set_features_block
+		block := b[8+i/32*8:]
+		bit := uint32(1) << (i % 32)
+		binary.NativeEndian.PutUint32(block[0:], binary.NativeEndian.Uint32(block[0:])|bit)
+		if active {
+			binary.NativeEndian.PutUint32(block[4:], binary.NativeEndian.Uint32(block[4:])|bit)
+		}
+	}
+	if err := ioctl(iface, unsafe.Pointer(&b[0])); err != nil {
+		return errors.WithMessagef(err, "set %s features", iface)
+	}
+	return nil
+}
+
+func featureNames(iface string) ([]string, error) {
+	// struct ethtool_sset_info
+	var info struct {
+		cmd      uint32
+		reserved uint32
+		mask     uint64
+		data     uint32
+	}
+	info.cmd = unix.ETHTOOL_GSSET_INFO
+	info.mask = 1 << ethSSFeatures
+	if err := ioctl(iface, unsafe.Pointer(&info)); err != nil {
+		return nil, errors.WithMessagef(err, "get %s features count", iface)
+	} else if info.mask == 0 || info.data == 0 {
+		return nil, errors.Errorf("%s not support features", iface)
+	}
+	n := int(info.data)
+
+	// struct ethtool_gstrings
+	b := make([]byte, 12+n*stringLen)
+	binary.NativeEndian.PutUint32(b[0:], unix.ETHTOOL_GSTRINGS)
+	binary.NativeEndian.PutUint32(b[4:], ethSSFeatures)
+	binary.NativeEndian.PutUint32(b[8:], uint32(n))
+	if err := ioctl(iface, unsafe.Pointer(&b[0])); err != nil {
+		return nil, errors.WithMessagef(err, "get %s features name", iface)
+	}
+
+	var names = make([]string, 0, n)
+	for i := 0; i < n; i++ {
+		s := b[12+i*stringLen : 12+(i+1)*stringLen]
+		names = append(names, unix.ByteSliceToString(s))
+	}
+	return names, nil
+}
+
+type ifreq struct {
+	name [unix.IFNAMSIZ]byte
+	data unsafe.Pointer
+	_    [24 - unsafe.Sizeof(uintptr(0))]byte
+}
+
+func ioctl(iface string, data unsafe.Pointer) error {
+	if len(iface) >= unix.IFNAMSIZ {
+		return errors.Errorf("invalid interface name %s", iface)
+	}
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer unix.Close(fd)
+
+	var ifr = ifreq{data: data}
+	copy(ifr.name[:], iface)
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), unix.SIOCETHTOOL, uintptr(unsafe.Pointer(&ifr)))
+	if errno != 0 {
+		return errors.WithStack(errno)
+	}
+	return nil
+}
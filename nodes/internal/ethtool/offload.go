@@ -0,0 +1,215 @@
+//go:build linux
+// +build linux
+
+package ethtool
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"net"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// offloads tso, gso, gro, lro and hardware gro, node transmit packets by raw
+// socket, the offload will merge or split packets
+var offloads = []string{
+	"tx-tcp-segmentation", "tx-tcp-ecn-segmentation", "tx-tcp-mangleid-segmentation", "tx-tcp6-segmentation",
+	"tx-generic-segmentation",
+	"rx-gro",
+	"rx-lro",
+	"rx-gro-hw",
+}
+
+// UDPOffloads features required by udp segmentation offload, UDP_GRO not
+// work without rx-gro, keep them if node not use fake tcp
+var UDPOffloads = []string{"rx-gro", "tx-generic-segmentation"}
+
+// Offload disabled offload features, restore them by Restore
+type Offload struct {
+	logger *slog.Logger
+
+	mu      sync.Mutex
+	changed map[string][]string // interface: features
+}
+
+// DisableOffload disable offload features of egress interfaces, that carry
+// default route or bound address laddr(ignore if unspecified), features in
+// keep are not changed
+func DisableOffload(laddr netip.Addr, logger *slog.Logger, keep ...string) (*Offload, error) {
+	ifaces, err := egress(laddr)
+	if err != nil {
+		return nil, err
+	} else if len(ifaces) == 0 {
+		return nil, errors.New("not found egress interface")
+	}
+
+	var o = &Offload{logger: logger, changed: map[string][]string{}}
+	var ok = false
+	for _, iface := range ifaces {
+		if err := o.disable(iface, keep); err != nil {
+			logger.Error("disable offload", slog.String("interface", iface), slog.String("error", err.Error()))
+		} else {
+			ok = true
+		}
+	}
+	if !ok {
+		return nil, errors.Errorf("disable offload of %v failed", ifaces)
+	}
+	return o, nil
+}
+
+func (o *Offload) disable(iface string, keep []string) error {
+	fs, err := Features(iface)
+	if err != nil {
+		return err
+	}
+
+	var set = map[string]bool{}
+	for _, name := range offloads {
+		f, has := fs[name]
+		if !has || !f.Active || slices.Contains(keep, name) {
+			continue
+		} else if f.Fixed {
+			o.logger.Warn("offload feature fixed on", slog.String("interface", iface), slog.String("feature", name))
+			continue
+		}
+		set[name] = false
+	}
+	if len(set) == 0 {
+		return nil
+	}
+	if err := SetFeatures(iface, set); err != nil {
+		return err
+	}
+
+	fs, err = Features(iface)
+	if err != nil {
+		return err
+	}
+	for name := range set {
+		if fs[name].Active {
+			o.logger.Warn("offload feature not disabled", slog.String("interface", iface), slog.String("feature", name))
+		} else {
+			o.changed[iface] = append(o.changed[iface], name)
+		}
+	}
+	o.logger.Info("disable offload", slog.String("interface", iface), slog.Any("features", o.changed[iface]))
+	return nil
+}
+
+// Restore restore disabled offload features
+func (o *Offload) Restore() error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var err error
+	for iface, names := range o.changed {
+		var set = map[string]bool{}
+		for _, name := range names {
+			set[name] = true
+		}
+		if e := SetFeatures(iface, set); e != nil {
+			if err == nil {
+				err = e
+			}
+		} else {
+			o.logger.Info("restore offload", slog.String("interface", iface), slog.Any("features", names))
+		}
+	}
+	clear(o.changed)
+	return err
+}
+
+// egress get interfaces that carry default route or bound address
+func egress(laddr netip.Addr) (ifaces []string, err error) {
+	for _, path := range []string{"/proc/net/route", "/proc/net/ipv6_route"} {
+		fh, err := os.Open(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue // ipv6 disabled
+			}
+			return nil, errors.WithStack(err)
+		}
+		if path == "/proc/net/route" {
+			ifaces = append(ifaces, defaultRoute4(fh)...)
+		} else {
+			ifaces = append(ifaces, defaultRoute6(fh)...)
+		}
+		fh.Close()
+	}
+
+	if laddr.IsValid() && !laddr.IsUnspecified() {
+		iface, err := addrInterface(laddr)
+		if err != nil {
+			return nil, err
+		} else if iface != "" {
+			ifaces = append(ifaces, iface)
+		}
+	}
+
+	slices.Sort(ifaces)
+	return slices.Compact(ifaces), nil
+}
+
+// defaultRoute4 parse interfaces of default route from /proc/net/route
+func defaultRoute4(r io.Reader) (ifaces []string) {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		// Iface Destination Gateway Flags RefCnt Use Metric Mask ...
+		fields := strings.Fields(s.Text())
+		if len(fields) < 8 || fields[0] == "Iface" {
+			continue
+		}
+		if fields[1] == "00000000" && fields[7] == "00000000" && fields[0] != "lo" {
+			ifaces = append(ifaces, fields[0])
+		}
+	}
+	return ifaces
+}
+
+// defaultRoute6 parse interfaces of default route from /proc/net/ipv6_route
+func defaultRoute6(r io.Reader) (ifaces []string) {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		// destination prefix source prefix next-hop metric refcnt use flags iface
+		fields := strings.Fields(s.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		if fields[0] == strings.Repeat("0", 32) && fields[1] == "00" && fields[9] != "lo" {
+			ifaces = append(ifaces, fields[9])
+		}
+	}
+	return ifaces
+}
+
+func addrInterface(addr netip.Addr) (string, error) {
+	ifis, err := net.Interfaces()
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+	for _, ifi := range ifis {
+		addrs, err := ifi.Addrs()
+		if err != nil {
+			return "", errors.WithStack(err)
+		}
+		for _, e := range addrs {
+			if ip, ok := e.(*net.IPNet); ok {
+				if a, ok := netip.AddrFromSlice(ip.IP); ok && a.Unmap() == addr.Unmap() {
+					if ifi.Flags&net.FlagLoopback != 0 {
+						return "", nil
+					}
+					return ifi.Name, nil
+				}
+			}
+		}
+	}
+	return "", nil
+}
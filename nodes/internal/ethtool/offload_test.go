@@ -0,0 +1,47 @@
+//go:build linux
+// +build linux
+
+package ethtool
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_DefaultRoute(t *testing.T) {
+	t.Run("ipv4", func(t *testing.T) {
+		var route = `Iface	Destination	Gateway 	Flags	RefCnt	Use	Metric	Mask		MTU	Window	IRTT
+eth0	00000000	0102A8C0	0003	0	0	100	00000000	0	0	0
+eth0	0002A8C0	00000000	0001	0	0	100	00FFFFFF	0	0	0
+wlan0	00000000	0101A8C0	0003	0	0	600	00000000	0	0	0
+docker0	000011AC	00000000	0001	0	0	0	0000FFFF	0	0	0
+`
+		ifaces := defaultRoute4(strings.NewReader(route))
+		require.Equal(t, []string{"eth0", "wlan0"}, ifaces)
+	})
+
+	t.Run("ipv6", func(t *testing.T) {
+		var route = `00000000000000000000000000000000 00 00000000000000000000000000000000 00 fe800000000000000000000000000001 00000400 00000001 00000000 00450003     eth0
+fe800000000000000000000000000000 40 00000000000000000000000000000000 00 00000000000000000000000000000000 00000100 00000001 00000000 00000001     eth0
+00000000000000000000000000000000 00 00000000000000000000000000000000 00 00000000000000000000000000000000 ffffffff 00000001 00000000 00200200       lo
+`
+		ifaces := defaultRoute6(strings.NewReader(route))
+		require.Equal(t, []string{"eth0"}, ifaces)
+	})
+}
+
+func Test_Features(t *testing.T) {
+	fs, err := Features("lo")
+	if err != nil {
+		t.Skip(err)
+	}
+	require.NotEmpty(t, fs)
+
+	_, err = Features("not-exist")
+	require.Error(t, err)
+
+	err = SetFeatures("lo", map[string]bool{"not-exist-feature": false})
+	require.Error(t, err)
+}
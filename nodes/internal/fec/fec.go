@@ -0,0 +1,279 @@
+package fec
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"math/bits"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/lysShub/anton-planet-accelerator/bvvd"
+	"github.com/lysShub/anton-planet-accelerator/nodes"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/stats"
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+// Info fec group info, carried by bvvd.OptionFEC of Data and Parity packet
+type Info struct {
+	Group uint32
+	Index uint8 // index in group, equal Size means parity
+	Size  uint8 // data packets count of group
+}
+
+const infoSize = 4 + 1 + 1
+
+// IsParity info is parity packet
+func (i Info) IsParity() bool { return i.Index == i.Size }
+
+// Parity info of the parity packet of group
+func (i Info) Parity() Info { return Info{Group: i.Group, Index: i.Size, Size: i.Size} }
+
+func (i Info) Option() bvvd.Option {
+	var b = make([]byte, infoSize)
+	binary.BigEndian.PutUint32(b, i.Group)
+	b[4], b[5] = i.Index, i.Size
+	return bvvd.Option{Type: bvvd.OptionFEC, Value: b}
+}
+
+// Parse get fec info from header, has is false if packet not fec protected
+func Parse(hdr bvvd.Bvvd) (info Info, has bool) {
+	b, has := hdr.Option(bvvd.OptionFEC)
+	if !has || len(b) != infoSize {
+		return Info{}, false
+	}
+	info = Info{Group: binary.BigEndian.Uint32(b), Index: b[4], Size: b[5]}
+	if info.Size == 0 || info.Size > MaxSize || info.Index > info.Size {
+		return Info{}, false
+	}
+	return info, true
+}
+
+const (
+	MinSize = 2
+	MaxSize = 16
+
+	// update group size interval
+	update = time.Second
+)
+
+// Size group size adapted from pack loss, expect about one loss every 10
+// groups, 0 means fec disabled because of pack loss is negligible.
+func Size(pl stats.PL) int {
+	if pl < 0.002 {
+		return 0
+	}
+	return min(max(int(0.1/float64(pl)), MinSize), MaxSize)
+}
+
+// Flow identify fec encoder/decoder, peer is client on forward, or forward on client
+type Flow struct {
+	Peer   netip.AddrPort
+	Server netip.Addr
+	Proto  tcpip.TransportProtocolNumber
+}
+
+// Encoder xor parity encoder, generate one parity packet for every group
+// of data packets, group size adapted from pack loss periodically.
+type Encoder struct {
+	mu      sync.Mutex
+	pl      func() stats.PL
+	updated time.Time
+	last    time.Time
+
+	info   Info
+	parity []byte
+}
+
+func NewEncoder(pl func() stats.PL) *Encoder {
+	return &Encoder{pl: pl}
+}
+
+// Encode record payload of data packet, return fec info of the data packet,
+// info.Size is 0 if fec disabled. parity is the payload of parity packet
+// if group completed.
+func (e *Encoder) Encode(payload []byte) (info Info, parity []byte) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.info.Index == 0 && time.Since(e.updated) > update {
+		e.info.Size = uint8(Size(e.pl()))
+		e.updated = time.Now()
+	}
+	if e.info.Size == 0 {
+		return Info{}, nil
+	}
+
+	info = e.info
+	e.parity = xor(e.parity, payload)
+	if e.info.Index++; e.info.Index == e.info.Size {
+		parity, e.parity = e.parity, nil
+		e.info.Group++
+		e.info.Index = 0
+	}
+	return info, parity
+}
+
+// Decoder xor parity decoder, recover one lost data packet of group.
+type Decoder struct {
+	mu     sync.Mutex
+	groups map[uint32]*group
+	top    uint32
+	last   time.Time
+}
+
+type group struct {
+	size uint8
+	recv uint32 // received bitmap, bit size is parity
+	xor  []byte
+}
+
+// window groups count keep in decoder
+const window = 32
+
+func NewDecoder() *Decoder {
+	return &Decoder{groups: map[uint32]*group{}}
+}
+
+// Decode record data or parity packet, pass indicate the packet should be
+// delivered, is false for parity or data already recovered. recovered is the
+// payload of lost data packet, refer decoder buffer.
+func (d *Decoder) Decode(info Info, payload []byte) (pass bool, recovered []byte) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	pass = !info.IsParity()
+	g := d.groups[info.Group]
+	if g == nil {
+		if delta := int32(info.Group - d.top); len(d.groups) > 0 && delta <= -window {
+			return pass, nil // too old
+		} else if delta > 0 || len(d.groups) == 0 {
+			d.top = info.Group
+			for id := range d.groups {
+				if int32(d.top-id) >= window {
+					delete(d.groups, id)
+				}
+			}
+		}
+		g = &group{size: info.Size}
+		d.groups[info.Group] = g
+	} else if g.size != info.Size {
+		return pass, nil
+	}
+
+	bit := uint32(1) << info.Index
+	if g.recv&bit != 0 {
+		return false, nil // duplicate or recovered
+	}
+	g.recv |= bit
+
+	full := uint32(1)<<(g.size+1) - 1
+	if g.recv&^(1<<g.size) == full>>1 {
+		g.recv, g.xor = full, nil // all data received
+		return pass, nil
+	}
+	if info.IsParity() {
+		g.xor = xorRaw(g.xor, payload)
+	} else {
+		g.xor = xor(g.xor, payload)
+	}
+
+	if g.recv&(1<<g.size) != 0 && bits.OnesCount32(g.recv) == int(g.size) {
+		g.recv = full
+		n := int(binary.BigEndian.Uint16(g.xor))
+		if n+2 > len(g.xor) {
+			return pass, nil // invalid parity
+		}
+		return pass, g.xor[2 : 2+n]
+	}
+	return pass, nil
+}
+
+// xor xor length prefixed payload to dst, dst will grow if necessary
+func xor(dst []byte, payload []byte) []byte {
+	if n := 2 + len(payload); len(dst) < n {
+		dst = append(dst, make([]byte, n-len(dst))...)
+	}
+	dst[0] ^= byte(len(payload) >> 8)
+	dst[1] ^= byte(len(payload))
+	subtle.XORBytes(dst[2:], dst[2:], payload)
+	return dst
+}
+
+// xorRaw xor parity payload to dst, that already length prefixed
+func xorRaw(dst []byte, parity []byte) []byte {
+	if len(dst) < len(parity) {
+		dst = append(dst, make([]byte, len(parity)-len(dst))...)
+	}
+	subtle.XORBytes(dst, dst, parity)
+	return dst
+}
+
+// Encoders encoder of every flow, idle encoder will be evicted
+type Encoders struct {
+	mu    sync.Mutex
+	es    map[Flow]*Encoder
+	sweep time.Time
+}
+
+func NewEncoders() *Encoders {
+	return &Encoders{es: map[Flow]*Encoder{}}
+}
+
+// Encoder get encoder of flow, pl is used to create encoder if not existed
+func (es *Encoders) Encoder(flow Flow, pl func() stats.PL) *Encoder {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(es.sweep) > nodes.Keepalive {
+		for k, e := range es.es {
+			if now.Sub(e.last) > nodes.Keepalive {
+				delete(es.es, k)
+			}
+		}
+		es.sweep = now
+	}
+
+	e := es.es[flow]
+	if e == nil {
+		e = NewEncoder(pl)
+		es.es[flow] = e
+	}
+	e.last = now
+	return e
+}
+
+// Decoders decoder of every flow, idle decoder will be evicted
+type Decoders struct {
+	mu    sync.Mutex
+	ds    map[Flow]*Decoder
+	sweep time.Time
+}
+
+func NewDecoders() *Decoders {
+	return &Decoders{ds: map[Flow]*Decoder{}}
+}
+
+func (ds *Decoders) Decoder(flow Flow) *Decoder {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(ds.sweep) > nodes.Keepalive {
+		for k, d := range ds.ds {
+			if now.Sub(d.last) > nodes.Keepalive {
+				delete(ds.ds, k)
+			}
+		}
+		ds.sweep = now
+	}
+
+	d := ds.ds[flow]
+	if d == nil {
+		d = NewDecoder()
+		ds.ds[flow] = d
+	}
+	d.last = now
+	return d
+}
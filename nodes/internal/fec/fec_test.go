@@ -0,0 +1,155 @@
+package fec_test
+
+import (
+	"math/rand"
+	"net/netip"
+	"testing"
+
+	"github.com/lysShub/anton-planet-accelerator/bvvd"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/fec"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/stats"
+	"github.com/lysShub/netkit/packet"
+	"github.com/stretchr/testify/require"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+func Test_Size(t *testing.T) {
+	require.Equal(t, 0, fec.Size(0.001))
+	require.Equal(t, fec.MaxSize, fec.Size(0.003))
+	require.Equal(t, 10, fec.Size(0.01))
+	require.Equal(t, fec.MinSize, fec.Size(0.5))
+}
+
+func Test_Info(t *testing.T) {
+	var info = fec.Info{Group: rand.Uint32(), Index: 3, Size: 8}
+
+	var pkt = packet.Make(64, 0, 16).Append(make([]byte, 16)...)
+	require.NoError(t, (&bvvd.Fields{
+		Kind:    bvvd.Data,
+		Proto:   header.UDPProtocolNumber,
+		Forward: netip.MustParseAddrPort("1.2.3.4:19986"),
+		Server:  netip.MustParseAddr("8.8.8.8"),
+		Version: bvvd.Version,
+		Options: bvvd.Options{info.Option()},
+	}).Encode(pkt))
+
+	info2, has := fec.Parse(bvvd.Bvvd(pkt.Bytes()))
+	require.True(t, has)
+	require.Equal(t, info, info2)
+	require.False(t, info2.IsParity())
+	require.True(t, info2.Parity().IsParity())
+}
+
+func Test_FEC(t *testing.T) {
+	var payload = func() []byte {
+		var b = make([]byte, 20+rand.Intn(1200))
+		rand.Read(b)
+		return b
+	}
+
+	type packet struct {
+		info    fec.Info
+		payload []byte
+	}
+	var encode = func(t *testing.T, pl stats.PL, n int) (datas, ps []packet) {
+		var enc = fec.NewEncoder(func() stats.PL { return pl })
+		for i := 0; i < n; i++ {
+			b := payload()
+			info, parity := enc.Encode(b)
+			require.NotZero(t, info.Size)
+			datas = append(datas, packet{info, b})
+			ps = append(ps, packet{info, b})
+			if parity != nil {
+				ps = append(ps, packet{info.Parity(), parity})
+			}
+		}
+		return datas, ps
+	}
+
+	t.Run("disable", func(t *testing.T) {
+		var enc = fec.NewEncoder(func() stats.PL { return 0.0001 })
+		info, parity := enc.Encode(payload())
+		require.Zero(t, info.Size)
+		require.Nil(t, parity)
+	})
+
+	t.Run("no loss", func(t *testing.T) {
+		datas, ps := encode(t, 0.05, 64)
+
+		var dec = fec.NewDecoder()
+		var recvs [][]byte
+		for _, p := range ps {
+			pass, rec := dec.Decode(p.info, p.payload)
+			require.Nil(t, rec)
+			if pass {
+				recvs = append(recvs, p.payload)
+			}
+		}
+		require.Equal(t, len(datas), len(recvs))
+	})
+
+	t.Run("recover", func(t *testing.T) {
+		datas, ps := encode(t, 0.05, 64)
+
+		var dec = fec.NewDecoder()
+		var recvs = map[string]bool{}
+		for i, p := range ps {
+			if i%(fec.Size(0.05)+1) == 1 {
+				continue // lost one packet of every group
+			}
+
+			pass, rec := dec.Decode(p.info, p.payload)
+			if pass {
+				recvs[string(p.payload)] = true
+			}
+			if rec != nil {
+				require.False(t, recvs[string(rec)])
+				recvs[string(rec)] = true
+			}
+		}
+		require.Equal(t, len(datas), len(recvs))
+		for _, e := range datas {
+			require.True(t, recvs[string(e.payload)])
+		}
+	})
+
+	t.Run("recover duplicate", func(t *testing.T) {
+		_, ps := encode(t, 0.05, fec.Size(0.05))
+
+		var dec = fec.NewDecoder()
+		for _, p := range ps[1:] {
+			dec.Decode(p.info, p.payload)
+		}
+		pass, rec := dec.Decode(ps[0].info, ps[0].payload)
+		require.False(t, pass)
+		require.Nil(t, rec)
+	})
+
+	t.Run("disorder", func(t *testing.T) {
+		datas, ps := encode(t, 0.05, 64)
+		rand.Shuffle(len(ps), func(i, j int) { ps[i], ps[j] = ps[j], ps[i] })
+
+		var dec = fec.NewDecoder()
+		var n int
+		for _, p := range ps {
+			pass, rec := dec.Decode(p.info, p.payload)
+			if pass {
+				n++
+			}
+			if rec != nil {
+				n++
+			}
+		}
+		require.Equal(t, len(datas), n)
+	})
+}
+
+func Benchmark_Encode(b *testing.B) {
+	var enc = fec.NewEncoder(func() stats.PL { return 0.05 })
+	var payload = make([]byte, 1200)
+	b.SetBytes(int64(len(payload)))
+
+	for i := 0; i < b.N; i++ {
+		enc.Encode(payload)
+	}
+}
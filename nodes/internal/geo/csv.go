@@ -0,0 +1,92 @@
+package geo
+
+import (
+	"encoding/csv"
+	"io"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+
+	"github.com/jftuga/geodist"
+	"github.com/pkg/errors"
+)
+
+type ipRange struct {
+	start, end netip.Addr
+	coord      geodist.Coord
+}
+
+type ranges []ipRange
+
+// CSV lookup by ip range csv file, line format: start,end,lat,lon, such as
+//
+//	1.0.0.0,1.0.0.255,-33.494,143.2104
+//
+// the first line is skipped if it's a header
+func CSV(path string) (Provider, error) {
+	fh, err := os.Open(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer fh.Close()
+
+	return parseCSV(fh)
+}
+
+func parseCSV(r io.Reader) (Provider, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = 4
+	cr.ReuseRecord = true
+
+	var rs ranges
+	for line := 1; ; line++ {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.WithStack(err)
+		}
+
+		var e ipRange
+		e.start, err = netip.ParseAddr(rec[0])
+		if err != nil && line == 1 {
+			continue // header
+		}
+		if err == nil {
+			e.end, err = netip.ParseAddr(rec[1])
+		}
+		if err == nil {
+			e.coord.Lat, err = strconv.ParseFloat(rec[2], 64)
+		}
+		if err == nil {
+			e.coord.Lon, err = strconv.ParseFloat(rec[3], 64)
+		}
+		if err != nil {
+			return nil, errors.Errorf("line %d: %s", line, err.Error())
+		} else if e.start.Is4() != e.end.Is4() || e.end.Less(e.start) {
+			return nil, errors.Errorf("line %d: invalid range %s-%s", line, e.start, e.end)
+		}
+		e.start, e.end = e.start.Unmap(), e.end.Unmap()
+		rs = append(rs, e)
+	}
+
+	slices.SortFunc(rs, func(a, b ipRange) int { return a.start.Compare(b.start) })
+	return rs, nil
+}
+
+func (rs ranges) Coord(addr netip.Addr) (geodist.Coord, error) {
+	addr = addr.Unmap()
+
+	// the last range that start <= addr
+	i, found := slices.BinarySearchFunc(rs, addr, func(e ipRange, addr netip.Addr) int {
+		return e.start.Compare(addr)
+	})
+	if !found {
+		i--
+	}
+	if i >= 0 && rs[i].end.Compare(addr) >= 0 && rs[i].start.BitLen() == addr.BitLen() {
+		return rs[i].coord, nil
+	}
+	return geodist.Coord{}, errors.WithMessage(ErrNotFound, addr.String())
+}
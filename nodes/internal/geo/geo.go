@@ -0,0 +1,127 @@
+package geo
+
+import (
+	"container/list"
+	stderr "errors"
+	"net/netip"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/jftuga/geodist"
+	"github.com/pkg/errors"
+)
+
+// Provider lookup coordinate of ip address
+type Provider interface {
+	Coord(addr netip.Addr) (geodist.Coord, error)
+}
+
+var ErrNotFound = stderr.New("address not found")
+
+// Open open local geolocation database, format decided by extension: .mmdb
+// or .csv, http is optional fallback when not found in database. empty path
+// only use http.
+func Open(path string, http bool) (Provider, error) {
+	var ps []Provider
+	switch ext := strings.ToLower(filepath.Ext(path)); {
+	case path == "":
+	case ext == ".mmdb":
+		p, err := MMDB(path)
+		if err != nil {
+			return nil, err
+		}
+		ps = append(ps, p)
+	case ext == ".csv":
+		p, err := CSV(path)
+		if err != nil {
+			return nil, err
+		}
+		ps = append(ps, p)
+	default:
+		return nil, errors.Errorf("not support geolocation database format %q", ext)
+	}
+	if http || len(ps) == 0 {
+		ps = append(ps, HTTP())
+	}
+	return Cache(Chain(ps...), 1024), nil
+}
+
+type chain []Provider
+
+// Chain lookup by providers in order, until found
+func Chain(ps ...Provider) Provider {
+	if len(ps) == 1 {
+		return ps[0]
+	}
+	return chain(ps)
+}
+
+func (c chain) Coord(addr netip.Addr) (coord geodist.Coord, err error) {
+	err = ErrNotFound
+	for _, p := range c {
+		if coord, err = p.Coord(addr); err == nil {
+			return coord, nil
+		}
+	}
+	return geodist.Coord{}, err
+}
+
+// cache lru cache of provider, only cache found coordinate
+type cache struct {
+	p    Provider
+	size int
+
+	mu    sync.Mutex
+	list  *list.List // front is recently used
+	elems map[netip.Addr]*list.Element
+}
+
+type entry struct {
+	addr  netip.Addr
+	coord geodist.Coord
+}
+
+// Cache wrap provider with lru cache
+func Cache(p Provider, size int) Provider {
+	if size <= 0 {
+		panic("require positive")
+	}
+	return &cache{
+		p:     p,
+		size:  size,
+		list:  list.New(),
+		elems: map[netip.Addr]*list.Element{},
+	}
+}
+
+func (c *cache) Coord(addr netip.Addr) (geodist.Coord, error) {
+	addr = addr.Unmap()
+
+	c.mu.Lock()
+	if e, has := c.elems[addr]; has {
+		c.list.MoveToFront(e)
+		c.mu.Unlock()
+		return e.Value.(entry).coord, nil
+	}
+	c.mu.Unlock()
+
+	coord, err := c.p.Coord(addr)
+	if err != nil {
+		return geodist.Coord{}, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, has := c.elems[addr]; has {
+		c.list.MoveToFront(e)
+	} else {
+		c.elems[addr] = c.list.PushFront(entry{addr, coord})
+		if c.list.Len() > c.size {
+			e := c.list.Back()
+			c.list.Remove(e)
+			delete(c.elems, e.Value.(entry).addr)
+		}
+	}
+	return coord, nil
+}
@@ -0,0 +1,138 @@
+package geo_test
+
+import (
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jftuga/geodist"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/geo"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_CSV(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "geo.csv")
+	require.NoError(t, os.WriteFile(path, []byte(
+		"start,end,lat,lon\n"+
+			"1.0.0.0,1.0.0.255,-33.494,143.2104\n"+
+			"8.8.4.0,8.8.8.255,37.751,-97.822\n"+
+			"2001:db8::,2001:db8::ffff,55.769,37.586\n",
+	), 0o666))
+
+	p, err := geo.CSV(path)
+	require.NoError(t, err)
+
+	for addr, coord := range map[string]geodist.Coord{
+		"1.0.0.0":        {Lat: -33.494, Lon: 143.2104},
+		"1.0.0.128":      {Lat: -33.494, Lon: 143.2104},
+		"8.8.8.8":        {Lat: 37.751, Lon: -97.822},
+		"::ffff:8.8.8.8": {Lat: 37.751, Lon: -97.822},
+		"2001:db8::1":    {Lat: 55.769, Lon: 37.586},
+	} {
+		c, err := p.Coord(netip.MustParseAddr(addr))
+		require.NoError(t, err, addr)
+		require.Equal(t, coord, c, addr)
+	}
+
+	for _, addr := range []string{"0.0.0.1", "1.0.1.0", "8.8.9.0", "2001:db9::"} {
+		_, err := p.Coord(netip.MustParseAddr(addr))
+		require.True(t, errors.Is(err, geo.ErrNotFound), addr)
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "geo.csv")
+		require.NoError(t, os.WriteFile(path, []byte("1.0.0.0,1.0.0.255,-33.494,143.2104\n1.0.0.0,x,1,2\n"), 0o666))
+
+		_, err := geo.CSV(path)
+		require.Error(t, err)
+	})
+}
+
+type mockProvider struct {
+	calls int
+	m     map[netip.Addr]geodist.Coord
+}
+
+func (m *mockProvider) Coord(addr netip.Addr) (geodist.Coord, error) {
+	m.calls++
+	if c, has := m.m[addr]; has {
+		return c, nil
+	}
+	return geodist.Coord{}, geo.ErrNotFound
+}
+
+func Test_Cache(t *testing.T) {
+	var (
+		a1 = netip.MustParseAddr("1.1.1.1")
+		a2 = netip.MustParseAddr("2.2.2.2")
+		a3 = netip.MustParseAddr("3.3.3.3")
+		m  = &mockProvider{m: map[netip.Addr]geodist.Coord{
+			a1: {Lat: 1, Lon: 1}, a2: {Lat: 2, Lon: 2}, a3: {Lat: 3, Lon: 3},
+		}}
+		p = geo.Cache(m, 2)
+	)
+
+	for _, e := range []netip.Addr{a1, a2, a1, a2} {
+		c, err := p.Coord(e)
+		require.NoError(t, err)
+		require.Equal(t, m.m[e], c)
+	}
+	require.Equal(t, 2, m.calls)
+
+	// evict a1
+	_, err := p.Coord(a3)
+	require.NoError(t, err)
+	_, err = p.Coord(a2)
+	require.NoError(t, err)
+	require.Equal(t, 3, m.calls)
+	_, err = p.Coord(a1)
+	require.NoError(t, err)
+	require.Equal(t, 4, m.calls)
+
+	// not cache not found
+	for range 2 {
+		_, err = p.Coord(netip.MustParseAddr("4.4.4.4"))
+		require.True(t, errors.Is(err, geo.ErrNotFound))
+	}
+	require.Equal(t, 6, m.calls)
+}
+
+func Test_Chain(t *testing.T) {
+	var (
+		a1 = netip.MustParseAddr("1.1.1.1")
+		a2 = netip.MustParseAddr("2.2.2.2")
+		m1 = &mockProvider{m: map[netip.Addr]geodist.Coord{a1: {Lat: 1, Lon: 1}}}
+		m2 = &mockProvider{m: map[netip.Addr]geodist.Coord{a1: {Lat: 3, Lon: 3}, a2: {Lat: 2, Lon: 2}}}
+		p  = geo.Chain(m1, m2)
+	)
+
+	c, err := p.Coord(a1)
+	require.NoError(t, err)
+	require.Equal(t, geodist.Coord{Lat: 1, Lon: 1}, c)
+	require.Equal(t, 0, m2.calls)
+
+	c, err = p.Coord(a2)
+	require.NoError(t, err)
+	require.Equal(t, geodist.Coord{Lat: 2, Lon: 2}, c)
+
+	_, err = p.Coord(netip.MustParseAddr("3.3.3.3"))
+	require.True(t, errors.Is(err, geo.ErrNotFound))
+}
+
+func Test_Open(t *testing.T) {
+	dir := t.TempDir()
+
+	_, err := geo.Open(filepath.Join(dir, "geo.txt"), true)
+	require.Error(t, err)
+
+	path := filepath.Join(dir, "invalid.mmdb")
+	require.NoError(t, os.WriteFile(path, []byte("invalid"), 0o666))
+	_, err = geo.Open(path, true)
+	require.Error(t, err)
+
+	p, err := geo.Open("", false)
+	require.NoError(t, err)
+	require.NotNil(t, p)
+}
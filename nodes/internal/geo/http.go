@@ -0,0 +1,56 @@
+package geo
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/jftuga/geodist"
+	"github.com/pkg/errors"
+)
+
+type httpProvider struct {
+	client *http.Client
+}
+
+// HTTP lookup by ip-api.com free api, has rate limit
+func HTTP() Provider {
+	return &httpProvider{client: &http.Client{Timeout: time.Second * 5}}
+}
+
+func (h *httpProvider) Coord(addr netip.Addr) (geodist.Coord, error) {
+	addr = addr.Unmap()
+	if !addr.IsValid() {
+		return geodist.Coord{}, errors.Errorf("invalid address %s", addr.String())
+	}
+
+	url := fmt.Sprintf(`http://ip-api.com/json/%s?fields=status,country,lat,lon,query`, addr.String())
+
+	resp, err := h.client.Get(url)
+	if err != nil {
+		return geodist.Coord{}, errors.WithStack(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return geodist.Coord{}, errors.Errorf("http code %d", resp.StatusCode)
+	}
+
+	var ret = struct {
+		Status  string
+		Country string
+		Lat     float64
+		Lon     float64
+		Query   string
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(&ret)
+	if err != nil {
+		return geodist.Coord{}, errors.WithStack(err)
+	}
+	if ret.Status != "success" || ret.Query != addr.String() {
+		return geodist.Coord{}, errors.Errorf("invalid response %#v", ret)
+	}
+
+	return geodist.Coord{Lat: ret.Lat, Lon: ret.Lon}, nil
+}
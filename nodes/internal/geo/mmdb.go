@@ -0,0 +1,40 @@
+package geo
+
+import (
+	"net"
+	"net/netip"
+
+	"github.com/jftuga/geodist"
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/pkg/errors"
+)
+
+type mmdb struct {
+	r *maxminddb.Reader
+}
+
+// MMDB lookup by MaxMind database, such as GeoLite2-City.mmdb
+func MMDB(path string) (Provider, error) {
+	r, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &mmdb{r: r}, nil
+}
+
+func (m *mmdb) Coord(addr netip.Addr) (geodist.Coord, error) {
+	var rec struct {
+		Location struct {
+			Latitude  *float64 `maxminddb:"latitude"`
+			Longitude *float64 `maxminddb:"longitude"`
+		} `maxminddb:"location"`
+	}
+
+	_, ok, err := m.r.LookupNetwork(net.IP(addr.Unmap().AsSlice()), &rec)
+	if err != nil {
+		return geodist.Coord{}, errors.WithStack(err)
+	} else if !ok || rec.Location.Latitude == nil || rec.Location.Longitude == nil {
+		return geodist.Coord{}, errors.WithMessage(ErrNotFound, addr.String())
+	}
+	return geodist.Coord{Lat: *rec.Location.Latitude, Lon: *rec.Location.Longitude}, nil
+}
@@ -0,0 +1,97 @@
+package mac
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"hash"
+	"sync"
+	"sync/atomic"
+
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/stats"
+	"github.com/lysShub/netkit/packet"
+)
+
+// Size mac trailer size, loop-id(4) and truncated mac(8)
+const Size = 4 + tagSize
+
+const tagSize = 8
+
+// MAC packet integrity by truncated HMAC-SHA256 trailer, keyed per session.
+// trailer carry send loop-id, it's authenticated with direction, and replay
+// packet is rejected by stats.Window. it's safe for concurrent use.
+type MAC struct {
+	initiator bool
+	pool      sync.Pool
+	send      atomic.Uint32
+
+	mu     sync.Mutex
+	window *stats.Window
+}
+
+// New create mac by session key, initiator indicate client side, packet
+// signed by one side only can be verified by the other side
+func New(key []byte, initiator bool) *MAC {
+	key = append([]byte{}, key...)
+	return &MAC{
+		initiator: initiator,
+		pool: sync.Pool{
+			New: func() any { return hmac.New(sha256.New, key) },
+		},
+		window: stats.NewWindow(),
+	}
+}
+
+// Sign append mac trailer of pkt
+func (m *MAC) Sign(pkt *packet.Packet) {
+	id := m.send.Add(1) - 1
+
+	var sum [sha256.Size]byte
+	m.sum(pkt.Bytes(), id, m.initiator, sum[:0])
+	pkt.Append(binary.BigEndian.AppendUint32(make([]byte, 0, Size), id)...)
+	pkt.Append(sum[:tagSize]...)
+}
+
+// Verify verify and remove mac trailer of pkt, pkt not changed if invalid
+// or replay
+func (m *MAC) Verify(pkt *packet.Packet) bool {
+	n := pkt.Data() - Size
+	if n < 0 {
+		return false
+	}
+	b := pkt.Bytes()
+	id := binary.BigEndian.Uint32(b[n:])
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// update window after authenticated
+	if _, ok := m.window.Valid(id); !ok {
+		return false
+	}
+	var sum [sha256.Size]byte
+	m.sum(b[:n], id, !m.initiator, sum[:0])
+	if !hmac.Equal(sum[:tagSize], b[n+4:]) {
+		return false
+	}
+	m.window.Update(id)
+
+	pkt.SetData(n)
+	return true
+}
+
+func (m *MAC) sum(b []byte, id uint32, initiator bool, to []byte) []byte {
+	h := m.pool.Get().(hash.Hash)
+	defer m.pool.Put(h)
+
+	var prefix [5]byte
+	if initiator {
+		prefix[0] = 1
+	}
+	binary.BigEndian.PutUint32(prefix[1:], id)
+
+	h.Reset()
+	h.Write(prefix[:])
+	h.Write(b)
+	return h.Sum(to)
+}
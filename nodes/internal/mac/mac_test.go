@@ -0,0 +1,120 @@
+package mac_test
+
+import (
+	"crypto/rand"
+	"fmt"
+	"testing"
+
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/mac"
+	"github.com/lysShub/netkit/packet"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_MAC(t *testing.T) {
+	var key [32]byte
+	rand.Read(key[:])
+	var m, g = mac.New(key[:], true), mac.New(key[:], false)
+
+	t.Run("sign verify", func(t *testing.T) {
+		var b = make([]byte, 64)
+		rand.Read(b)
+		pkt := packet.Make(16, 0, 16).Append(b...)
+
+		m.Sign(pkt)
+		require.Equal(t, len(b)+mac.Size, pkt.Data())
+
+		require.True(t, g.Verify(pkt))
+		require.Equal(t, b, pkt.Bytes())
+
+		g.Sign(pkt)
+		require.True(t, m.Verify(pkt))
+		require.Equal(t, b, pkt.Bytes())
+	})
+
+	t.Run("replay", func(t *testing.T) {
+		pkt := packet.Make(16, 0, 16).Append(make([]byte, 32)...)
+		m.Sign(pkt)
+		signed := append([]byte{}, pkt.Bytes()...)
+
+		require.True(t, g.Verify(pkt))
+		require.False(t, g.Verify(packet.Make(16, 0, 16).Append(signed...)))
+	})
+
+	t.Run("reflect", func(t *testing.T) {
+		pkt := packet.Make(16, 0, 16).Append(make([]byte, 32)...)
+		m.Sign(pkt)
+		require.False(t, m.Verify(pkt))
+	})
+
+	t.Run("disorder", func(t *testing.T) {
+		var signed [][]byte
+		for range 4 {
+			pkt := packet.Make(16, 0, 16).Append(make([]byte, 32)...)
+			m.Sign(pkt)
+			signed = append(signed, pkt.Bytes())
+		}
+		for _, i := range []int{1, 0, 3, 2} {
+			require.True(t, g.Verify(packet.Make(16, 0, 16).Append(signed[i]...)), i)
+		}
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		var b = make([]byte, 64)
+		rand.Read(b)
+		pkt := packet.Make(16, 0, 16).Append(b...)
+
+		m.Sign(pkt)
+		pkt.Bytes()[3] ^= 0x01
+		require.False(t, g.Verify(pkt))
+		require.Equal(t, len(b)+mac.Size, pkt.Data())
+	})
+
+	t.Run("other key", func(t *testing.T) {
+		var key [32]byte
+		rand.Read(key[:])
+		var other = mac.New(key[:], false)
+
+		pkt := packet.Make(16, 0, 16).Append(make([]byte, 32)...)
+		m.Sign(pkt)
+		require.False(t, other.Verify(pkt))
+	})
+
+	t.Run("too small", func(t *testing.T) {
+		pkt := packet.Make(16, 0, 16).Append(make([]byte, mac.Size-1)...)
+		require.False(t, g.Verify(pkt))
+	})
+}
+
+func BenchmarkSign(b *testing.B) {
+	var m = mac.New(make([]byte, 32), true)
+
+	for _, size := range []int{64, 512, 1400} {
+		b.Run(fmt.Sprintf("%d", size), func(b *testing.B) {
+			var pkt = packet.Make(64, size, mac.Size)
+			b.SetBytes(int64(size))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				m.Sign(pkt.Sets(64, size))
+			}
+		})
+	}
+}
+
+// BenchmarkVerify sign and verify, signed packet can't be verified twice
+func BenchmarkVerify(b *testing.B) {
+	var m, g = mac.New(make([]byte, 32), true), mac.New(make([]byte, 32), false)
+
+	for _, size := range []int{64, 512, 1400} {
+		b.Run(fmt.Sprintf("%d", size), func(b *testing.B) {
+			var pkt = packet.Make(64, size, mac.Size)
+			b.SetBytes(int64(size))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				m.Sign(pkt.Sets(64, size))
+				if !g.Verify(pkt) {
+					b.Fatal("invalid mac")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Metrics registered counters and gauges, expose in prometheus text format
+type Metrics struct {
+	mu         sync.RWMutex
+	collectors []collector
+}
+
+type collector struct {
+	name, help, typ string
+	collect         func(add func(value float64, labels ...string))
+	labels          []string
+}
+
+func New() *Metrics { return &Metrics{} }
+
+func (m *Metrics) register(c collector) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range m.collectors {
+		if e.name == c.name {
+			panic(fmt.Sprintf("metric %s registered", c.name))
+		}
+	}
+	m.collectors = append(m.collectors, c)
+}
+
+// Counter register counter without label
+func (m *Metrics) Counter(name, help string) *Counter {
+	var c = &Counter{}
+	m.register(collector{
+		name: name, help: help, typ: "counter",
+		collect: func(add func(float64, ...string)) { add(float64(c.Load())) },
+	})
+	return c
+}
+
+// CounterVec register counters with labels, counter of label values is
+// created when first used
+func (m *Metrics) CounterVec(name, help string, labels ...string) *CounterVec {
+	var c = &CounterVec{n: len(labels), cs: map[string]*series{}}
+	m.register(collector{
+		name: name, help: help, typ: "counter", labels: labels,
+		collect: c.collect,
+	})
+	return c
+}
+
+// CounterFunc register counter read by fn when scraped, fn should be
+// monotonic increasing
+func (m *Metrics) CounterFunc(name, help string, fn func() uint64) {
+	m.register(collector{
+		name: name, help: help, typ: "counter",
+		collect: func(add func(float64, ...string)) { add(float64(fn())) },
+	})
+}
+
+// Gauge register gauge read by fn when scraped
+func (m *Metrics) Gauge(name, help string, fn func() float64) {
+	m.register(collector{
+		name: name, help: help, typ: "gauge",
+		collect: func(add func(float64, ...string)) { add(fn()) },
+	})
+}
+
+// GaugeVec register gauges with labels read by fn when scraped, fn call add
+// for each series, the count of label values must equal to labels.
+func (m *Metrics) GaugeVec(name, help string, labels []string, fn func(add func(value float64, labels ...string))) {
+	m.register(collector{
+		name: name, help: help, typ: "gauge", labels: labels,
+		collect: fn,
+	})
+}
+
+// WriteTo write all metrics in prometheus text format
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var b = &countWriter{w: bufio.NewWriter(w)}
+	for _, c := range m.collectors {
+		fmt.Fprintf(b, "# HELP %s %s\n", c.name, strings.ReplaceAll(c.help, "\n", " "))
+		fmt.Fprintf(b, "# TYPE %s %s\n", c.name, c.typ)
+
+		c.collect(func(value float64, labels ...string) {
+			if len(labels) != len(c.labels) {
+				panic(fmt.Sprintf("metric %s require %d labels, got %d", c.name, len(c.labels), len(labels)))
+			}
+
+			b.WriteString(c.name)
+			if len(labels) > 0 {
+				b.WriteByte('{')
+				for i, e := range c.labels {
+					if i > 0 {
+						b.WriteByte(',')
+					}
+					b.WriteString(e)
+					b.WriteString(`="`)
+					b.WriteString(escape(labels[i]))
+					b.WriteByte('"')
+				}
+				b.WriteByte('}')
+			}
+			b.WriteByte(' ')
+			b.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+			b.WriteByte('\n')
+		})
+	}
+	if err := b.w.Flush(); err != nil {
+		return b.n, err
+	}
+	return b.n, nil
+}
+
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	m.WriteTo(w)
+}
+
+var escaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escape(s string) string { return escaper.Replace(s) }
+
+type countWriter struct {
+	w *bufio.Writer
+	n int64
+}
+
+func (c *countWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
+func (c *countWriter) WriteString(s string) {
+	n, _ := c.w.WriteString(s)
+	c.n += int64(n)
+}
+func (c *countWriter) WriteByte(b byte) error {
+	c.n++
+	return c.w.WriteByte(b)
+}
+
+type Counter struct{ atomic.Uint64 }
+
+func (c *Counter) Inc() { c.Add(1) }
+
+type CounterVec struct {
+	n int
+
+	mu sync.RWMutex
+	cs map[string]*series
+}
+
+type series struct {
+	labels []string
+	c      *Counter
+}
+
+// With get counter of label values, hot path should hold the returned counter
+func (c *CounterVec) With(labels ...string) *Counter {
+	if len(labels) != c.n {
+		panic(fmt.Sprintf("require %d labels, got %d", c.n, len(labels)))
+	}
+	key := strings.Join(labels, "\x00")
+
+	c.mu.RLock()
+	s := c.cs[key]
+	c.mu.RUnlock()
+	if s == nil {
+		c.mu.Lock()
+		if s = c.cs[key]; s == nil {
+			s = &series{labels: slices.Clone(labels), c: &Counter{}}
+			c.cs[key] = s
+		}
+		c.mu.Unlock()
+	}
+	return s.c
+}
+
+func (c *CounterVec) collect(add func(float64, ...string)) {
+	c.mu.RLock()
+	var ss = make([]*series, 0, len(c.cs))
+	for _, e := range c.cs {
+		ss = append(ss, e)
+	}
+	c.mu.RUnlock()
+
+	slices.SortFunc(ss, func(a, b *series) int { return slices.Compare(a.labels, b.labels) })
+	for _, e := range ss {
+		add(float64(e.c.Load()), e.labels...)
+	}
+}
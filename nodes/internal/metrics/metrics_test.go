@@ -0,0 +1,88 @@
+package metrics_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/lysShub/anton-planet-accelerator/bvvd"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/metrics"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Metrics(t *testing.T) {
+	var m = metrics.New()
+
+	c := m.Counter("test_counter_total", "counter")
+	c.Add(2)
+	v := m.CounterVec("test_vec_total", "counter vec", "a", "b")
+	v.With("y", "1").Inc()
+	v.With("x", `"\`).Add(3)
+	m.Gauge("test_gauge", "gauge", func() float64 { return 0.25 })
+	m.GaugeVec("test_gauge_vec", "gauge\nvec", []string{"n"}, func(add func(float64, ...string)) {
+		add(1, "a")
+		add(2, "b")
+	})
+
+	var b bytes.Buffer
+	n, err := m.WriteTo(&b)
+	require.NoError(t, err)
+	require.Equal(t, int64(b.Len()), n)
+	require.Equal(t, `# HELP test_counter_total counter
+# TYPE test_counter_total counter
+test_counter_total 2
+# HELP test_vec_total counter vec
+# TYPE test_vec_total counter
+test_vec_total{a="x",b="\"\\"} 3
+test_vec_total{a="y",b="1"} 1
+# HELP test_gauge gauge
+# TYPE test_gauge gauge
+test_gauge 0.25
+# HELP test_gauge_vec gauge vec
+# TYPE test_gauge_vec gauge
+test_gauge_vec{n="a"} 1
+test_gauge_vec{n="b"} 2
+`, b.String())
+
+	require.Panics(t, func() { m.Gauge("test_gauge", "", nil) })
+	require.Panics(t, func() { v.With("x") })
+}
+
+func Test_Traffic(t *testing.T) {
+	var m = metrics.New()
+	tr := metrics.NewTraffic(m, "test")
+
+	tr.Uplink(bvvd.Data, 100)
+	tr.Uplink(bvvd.Data, 20)
+	tr.Downlink(bvvd.PingForward, 64)
+
+	var b bytes.Buffer
+	_, err := m.WriteTo(&b)
+	require.NoError(t, err)
+	require.Contains(t, b.String(), `test_bytes_total{direction="uplink",kind="Data"} 120`+"\n")
+	require.Contains(t, b.String(), `test_packets_total{direction="uplink",kind="Data"} 2`+"\n")
+	require.Contains(t, b.String(), `test_bytes_total{direction="downlink",kind="PingForward"} 64`+"\n")
+	require.Contains(t, b.String(), `test_packets_total{direction="downlink",kind="Data"} 0`+"\n")
+}
+
+func Test_Server(t *testing.T) {
+	var m = metrics.New()
+	m.Counter("test_total", "test").Inc()
+
+	s, err := metrics.Listen("127.0.0.1:0", m)
+	require.NoError(t, err)
+	var served = make(chan error, 1)
+	go func() { served <- s.Serve() }()
+
+	resp, err := http.Get("http://" + s.Addr().String() + "/metrics")
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), "test_total 1\n")
+
+	require.NoError(t, s.Close())
+	require.NoError(t, <-served)
+}
@@ -0,0 +1,51 @@
+package metrics
+
+import (
+	"net"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Server http listener of metrics, path is /metrics
+type Server struct {
+	l net.Listener
+	s *http.Server
+}
+
+func Listen(addr string, m *Metrics) (*Server, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	var mux = http.NewServeMux()
+	mux.Handle("/metrics", m)
+	return &Server{
+		l: l,
+		s: &http.Server{Handler: mux, ReadHeaderTimeout: time.Second * 5},
+	}, nil
+}
+
+func (s *Server) Addr() netip.AddrPort {
+	return s.l.Addr().(*net.TCPAddr).AddrPort()
+}
+
+// Serve serve until closed, return nil if closed
+func (s *Server) Serve() error {
+	if err := s.s.Serve(s.l); !errors.Is(err, http.ErrServerClosed) {
+		return errors.WithStack(err)
+	}
+	return nil
+}
+
+func (s *Server) Close() error {
+	err := s.s.Close()
+	// listener maybe closed by http server
+	if e := s.l.Close(); err == nil && !errors.Is(e, net.ErrClosed) {
+		err = e
+	}
+	return errors.WithStack(err)
+}
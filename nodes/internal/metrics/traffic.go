@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"github.com/lysShub/anton-planet-accelerator/bvvd"
+)
+
+// Traffic received bytes and packets counters per bvvd.Kind and direction
+type Traffic struct {
+	bytes, packets [2][16]*Counter
+}
+
+const (
+	uplink = iota
+	downlink
+)
+
+// NewTraffic register prefix_bytes_total and prefix_packets_total with
+// labels direction and kind
+func NewTraffic(m *Metrics, prefix string) *Traffic {
+	var (
+		t       = &Traffic{}
+		bytes   = m.CounterVec(prefix+"_bytes_total", "received bytes by direction and kind", "direction", "kind")
+		packets = m.CounterVec(prefix+"_packets_total", "received packets by direction and kind", "direction", "kind")
+	)
+	for d, dir := range []string{"uplink", "downlink"} {
+		for k := range t.bytes[d] {
+			kind := bvvd.Kind(k).String()
+			t.bytes[d][k] = bytes.With(dir, kind)
+			t.packets[d][k] = packets.With(dir, kind)
+		}
+	}
+	return t
+}
+
+func (t *Traffic) Uplink(kind bvvd.Kind, n int)   { t.add(uplink, kind, n) }
+func (t *Traffic) Downlink(kind bvvd.Kind, n int) { t.add(downlink, kind, n) }
+
+func (t *Traffic) add(dir int, kind bvvd.Kind, n int) {
+	t.bytes[dir][kind&0xf].Add(uint64(n))
+	t.packets[dir][kind&0xf].Inc()
+}
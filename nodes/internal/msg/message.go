@@ -8,7 +8,10 @@ import (
 	"github.com/pkg/errors"
 )
 
-const MinSize = bvvd.Size + 4
+// MinSize minimum message size, the actual size depend on bvvd header length
+const MinSize = bvvd.Size + IDSize
+
+const IDSize = 4
 
 type Message packet.Packet
 
@@ -20,23 +23,32 @@ func (m *Message) Kind() bvvd.Kind {
 	return bvvd.Bvvd((*packet.Packet)(m).Bytes()).Kind()
 }
 
+// Size message size without payload
+func (m *Message) Size() int {
+	return m.Bvvd().Len() + IDSize
+}
+
 func (m *Message) MsgID() uint32 {
-	return binary.BigEndian.Uint32((*packet.Packet)(m).Bytes()[bvvd.Size:])
+	return binary.BigEndian.Uint32((*packet.Packet)(m).Bytes()[m.Bvvd().Len():])
 }
 
 func (m *Message) SetMsgID(id uint32) {
-	binary.BigEndian.PutUint32((*packet.Packet)(m).Bytes()[bvvd.Size:], id)
+	binary.BigEndian.PutUint32((*packet.Packet)(m).Bytes()[m.Bvvd().Len():], id)
 }
 
 func (m *Message) Payload(to Payload) error {
-	(*packet.Packet)(m).DetachN(MinSize)
-	defer (*packet.Packet)(m).AttachN(MinSize)
-	return to.Decode((*packet.Packet)(m))
+	pkt := (*packet.Packet)(m)
+	head, data := pkt.Head(), pkt.Data()
+	defer pkt.Sets(head, data)
+
+	pkt.DetachN(m.Size())
+	return to.Decode(pkt)
 }
 
 func (m *Message) SetPayload(from Payload) error {
-	(*packet.Packet)(m).DetachN(MinSize)
-	defer (*packet.Packet)(m).AttachN(MinSize)
+	n := m.Size()
+	(*packet.Packet)(m).DetachN(n)
+	defer (*packet.Packet)(m).AttachN(n)
 
 	return from.Encode((*packet.Packet)(m))
 }
@@ -74,10 +86,10 @@ func (m *Fields) Decode(from *packet.Packet) error {
 		return err
 	}
 
-	if from.Data() < 4 {
+	if from.Data() < IDSize {
 		return errors.Errorf("too small %d", from.Data())
 	}
-	m.MsgID = binary.BigEndian.Uint32(from.Detach(4))
+	m.MsgID = binary.BigEndian.Uint32(from.Detach(IDSize))
 	if m.MsgID == 0 {
 		return errors.New("invalid message id")
 	}
@@ -24,3 +24,32 @@ func Test_Message(t *testing.T) {
 
 	require.Equal(t, msg, msg2)
 }
+
+func Test_Session(t *testing.T) {
+	var pkt = packet.Make()
+	var hello = Hello{Token: "hello", Session: rand.Uint64(), Version: bvvd.Version, Sealed: true, Time: rand.Int63()}
+	rand.Read(hello.Public[:])
+	var msg = Fields{MsgID: rand.Uint32(), Payload: &hello}
+	msg.Kind = bvvd.Session
+	require.NoError(t, msg.Encode(pkt))
+
+	var hello2 Hello
+	require.NoError(t, (*Message)(pkt).Payload(&hello2))
+	require.Equal(t, hello, hello2)
+
+	hello.Padding = 32
+	pkt2 := packet.Make()
+	require.NoError(t, msg.Encode(pkt2))
+	require.Equal(t, pkt.Data()+32, pkt2.Data())
+
+	var s = Session{ID: rand.Uint64(), Flags: FlagMAC | FlagSeal, Version: bvvd.Version}
+	rand.Read(s.Public[:])
+	rand.Read(s.Confirm[:])
+	pkt.SetData((*Message)(pkt).Size())
+	require.NoError(t, (*Message)(pkt).SetPayload(&s))
+
+	var msg2 = Fields{Payload: &Session{}}
+	require.NoError(t, msg2.Decode(pkt))
+	require.Equal(t, msg.MsgID, msg2.MsgID)
+	require.Equal(t, &s, msg2.Payload)
+}
@@ -0,0 +1,176 @@
+package msg
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+
+	"github.com/lysShub/netkit/packet"
+	"github.com/pkg/errors"
+)
+
+// Token client session handshake request payload, empty token only
+// negotiate version with gateway disabled authentication
+type Token string
+
+func (t Token) Encode(to *packet.Packet) error {
+	if len(t) > 0xff {
+		return errors.Errorf("invalid token length %d", len(t))
+	}
+	to.Append(byte(len(t)))
+	to.Append([]byte(t)...)
+	return nil
+}
+
+func (t *Token) Decode(from *packet.Packet) error {
+	if from.Data() < 1 {
+		return errors.New("too small")
+	}
+	n := int(from.Detach(1)[0])
+	if from.Data() < n {
+		return errors.Errorf("invalid token length %d", n)
+	}
+	*t = Token(from.Detach(n))
+	return nil
+}
+
+// Hello client session handshake request payload, padded to not smaller
+// than Session, gateway not reply larger than request. the bvvd header is
+// plaintext, random padding hide the fixed size.
+type Hello struct {
+	Session uint64   // current session id to renew, zero if not established
+	Public  [32]byte // x25519 ephemeral public key, zero if not support
+	Version uint8    // max bvvd header version supported by client
+	Sealed  bool     // Token is sealed by gateway static key
+	Time    int64    // unix seconds, reject stale sealed hello
+
+	Token   Token
+	Padding int // random padding size, not decoded
+}
+
+// helloSize encoded size of Hello without Token and padding
+const helloSize = 8 + 32 + 1 + 1 + 8
+
+// AD authenticated data of sealed token, include all fields before Token
+func (h *Hello) AD() []byte {
+	b := binary.BigEndian.AppendUint64(make([]byte, 0, helloSize), h.Session)
+	b = append(b, h.Public[:]...)
+	b = append(b, h.Version)
+	if h.Sealed {
+		b = append(b, 1)
+	} else {
+		b = append(b, 0)
+	}
+	return binary.BigEndian.AppendUint64(b, uint64(h.Time))
+}
+
+func (h *Hello) Encode(to *packet.Packet) error {
+	n := to.Data()
+	to.Append(h.AD()...)
+	if err := h.Token.Encode(to); err != nil {
+		return err
+	}
+	return padding(to, max(SessionSize-(to.Data()-n), 0)+h.Padding)
+}
+
+func (h *Hello) Decode(from *packet.Packet) error {
+	if from.Data() < helloSize {
+		return errors.Errorf("too small %d", from.Data())
+	}
+	h.Session = binary.BigEndian.Uint64(from.Detach(8))
+	copy(h.Public[:], from.Detach(len(h.Public)))
+	h.Version = from.Detach(1)[0]
+	h.Sealed = from.Detach(1)[0] != 0
+	h.Time = int64(binary.BigEndian.Uint64(from.Detach(8)))
+	return h.Token.Decode(from)
+}
+
+// Session client session handshake response payload, zero ID means rejected.
+// session keys are derived from x25519 key agreement, never transmitted.
+type Session struct {
+	ID     uint64
+	Flags  Flags    // negotiated by gateway
+	Public [32]byte // gateway x25519 ephemeral public key, valid if FlagMAC or FlagSeal
+
+	// Version max bvvd header version supported by gateway, extension
+	// area is only sent to gateway support it
+	Version uint8
+
+	// Confirm prove gateway hold the static key, zero if hello not sealed
+	Confirm [16]byte
+
+	Padding int // random padding size, not decoded
+}
+
+// SessionSize encoded size of Session without padding
+const SessionSize = 8 + 1 + 32 + 1 + 16
+
+type Flags uint8
+
+const (
+	// FlagMAC packets between client and gateway carry mac trailer
+	FlagMAC Flags = 1 << iota
+
+	// FlagSeal packets between client and gateway are sealed by tunnel
+	FlagSeal
+)
+
+func (f Flags) Has(flag Flags) bool { return f&flag == flag }
+
+func (s *Session) Rejected() bool { return s.ID == 0 }
+
+// Salt salt of session keys derivation, bind keys to the session, both
+// x25519 ephemeral public keys and the token, client is public key of Hello
+func (s *Session) Salt(client [32]byte, token Token) []byte {
+	b := binary.BigEndian.AppendUint64(make([]byte, 0, 8+64+len(token)), s.ID)
+	b = append(b, client[:]...)
+	b = append(b, s.Public[:]...)
+	return append(b, token...)
+}
+
+func (s *Session) Encode(to *packet.Packet) error {
+	to.Append(binary.BigEndian.AppendUint64(make([]byte, 0, 8), s.ID)...)
+	to.Append(byte(s.Flags))
+	to.Append(s.Public[:]...)
+	to.Append(s.Version)
+	to.Append(s.Confirm[:]...)
+	return padding(to, s.Padding)
+}
+
+func (s *Session) Decode(from *packet.Packet) error {
+	if from.Data() < SessionSize {
+		return errors.Errorf("too small %d", from.Data())
+	}
+	s.ID = binary.BigEndian.Uint64(from.Detach(8))
+	s.Flags = Flags(from.Detach(1)[0])
+	copy(s.Public[:], from.Detach(32))
+	s.Version = from.Detach(1)[0]
+	copy(s.Confirm[:], from.Detach(16))
+	return nil
+}
+
+// padding append n random bytes
+func padding(to *packet.Packet, n int) error {
+	if n <= 0 {
+		return nil
+	}
+	to.AppendN(n)
+	_, err := rand.Read(to.Bytes()[to.Data()-n:])
+	return errors.WithStack(err)
+}
+
+// Version bvvd header version supported by peer, payload of health probe
+// gateway-->forward, forward reply its version, legacy forward echo zero
+type Version uint8
+
+func (v Version) Encode(to *packet.Packet) error {
+	to.Append(byte(v))
+	return nil
+}
+
+func (v *Version) Decode(from *packet.Packet) error {
+	if from.Data() < 1 {
+		return errors.New("too small")
+	}
+	*v = Version(from.Detach(1)[0])
+	return nil
+}
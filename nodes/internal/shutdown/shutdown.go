@@ -0,0 +1,47 @@
+// Package shutdown test helper of node graceful shutdown
+package shutdown
+
+import (
+	"context"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type Node interface {
+	Serve(ctx context.Context) error
+}
+
+// Require create node by new, serve it then cancel, require Serve return nil
+// in time, all goroutines exited and timers stopped. timers not hold goroutine,
+// runtime.NumGoroutine can't detect them, so stopped report whether timers
+// of node stopped.
+func Require[T Node](t *testing.T, new func() (T, error), stopped func(T) bool) {
+	base := runtime.NumGoroutine()
+
+	p, err := new()
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var served = make(chan error, 1)
+	go func() { served <- p.Serve(ctx) }()
+
+	time.Sleep(time.Millisecond * 100)
+	require.False(t, stopped(p), "timers stopped before cancel")
+	cancel()
+
+	select {
+	case err := <-served:
+		require.NoError(t, err)
+	case <-time.After(time.Second * 5):
+		t.Fatal("serve not return after cancel")
+	}
+	require.True(t, stopped(p), "timer leak")
+
+	// require.Eventually run condition in new goroutine
+	for start := time.Now(); runtime.NumGoroutine() > base; time.Sleep(time.Millisecond * 50) {
+		require.Less(t, time.Since(start), time.Second*3, "goroutine leak")
+	}
+}
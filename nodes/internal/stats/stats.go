@@ -98,9 +98,11 @@ func (i *LoopIds) MaxID() int { return i.maxId }
 func (i *LoopIds) Reset() { i.idx, i.last = 0, 0 }
 
 type PLStats struct {
-	mu sync.RWMutex
-	l  *LoopIds
-	s  *stats
+	mu     sync.RWMutex
+	l      *LoopIds
+	s      *stats
+	timer  *time.Timer
+	closed bool
 }
 
 func NewPLStats(maxId int) *PLStats {
@@ -108,7 +110,9 @@ func NewPLStats(maxId int) *PLStats {
 		l: NewLoopIds(maxId),
 		s: newStats(),
 	}
-	time.AfterFunc(reset, p.reset)
+	p.mu.Lock()
+	p.timer = time.AfterFunc(reset, p.reset)
+	p.mu.Unlock()
 	return p
 }
 
@@ -117,10 +121,23 @@ const reset = time.Hour * 4
 func (p *PLStats) reset() {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
 
 	p.l.Reset()
 	p.s.init()
-	time.AfterFunc(reset, p.reset)
+	p.timer.Reset(reset)
+}
+
+// Close stop the reset timer, the PLStats should not be referenced anymore
+func (p *PLStats) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	p.timer.Stop()
+	return nil
 }
 
 func (p *PLStats) ID(id int) {
@@ -137,14 +154,26 @@ func (p *PLStats) ID(id int) {
 	p.s.Index(i)
 }
 
+// PL pack loss of current window, the window is reset if it has limit
+// samples, so should only be called by the pack loss reporter
 func (p *PLStats) PL(limit int) PL {
 	p.mu.Lock()
 	defer p.mu.Unlock()
 	return p.s.PL(limit)
 }
 
+// Peek pack loss without reset the window, used by observer such as metrics.
+// return current window if it has limit samples, otherwise the latest window
+func (p *PLStats) Peek(limit int) PL {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.s.peek(limit)
+}
+
 type PLStats2 struct {
-	mu sync.RWMutex
+	mu     sync.RWMutex
+	timer  *time.Timer
+	closed bool
 
 	l    *LoopIds
 	s    *stats
@@ -162,20 +191,35 @@ func NewPLStats2(maxId int) *PLStats2 {
 	for i := range p.dups {
 		p.dups[i] = -1
 	}
-	time.AfterFunc(reset, p.reset)
+	p.mu.Lock()
+	p.timer = time.AfterFunc(reset, p.reset)
+	p.mu.Unlock()
 	return p
 }
 
 func (p *PLStats2) reset() {
 	p.mu.Lock()
 	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
 
 	p.l.Reset()
 	p.s.init()
 	for i := range p.dups {
 		p.dups[i] = -1
 	}
-	time.AfterFunc(reset, p.reset)
+	p.timer.Reset(reset)
+}
+
+// Close stop the reset timer, the PLStats2 should not be referenced anymore
+func (p *PLStats2) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.closed = true
+	p.timer.Stop()
+	return nil
 }
 
 func (p *PLStats2) ID(id int) (recved bool) {
@@ -207,6 +251,7 @@ func (p *PLStats2) PL(limit int) PL {
 type stats struct {
 	maxId, minId int
 	count        int
+	last         PL // pack loss of the latest window
 }
 
 func newStats() *stats {
@@ -233,6 +278,18 @@ func (p *stats) PL(limit int) PL {
 	}
 	defer p.init()
 
+	p.last = p.pl()
+	return p.last
+}
+
+func (p *stats) peek(limit int) PL {
+	if p.count >= 2 && (p.count >= limit || p.last == 0) {
+		return p.pl()
+	}
+	return p.last
+}
+
+func (p *stats) pl() PL {
 	n := p.maxId - p.minId + 1
 	if n < p.count {
 		return 0 // repeat id
@@ -297,6 +354,7 @@ type Speed struct {
 	period time.Duration
 
 	sync.RWMutex
+	timer  *time.Timer
 	closed bool
 	speed  float64
 
@@ -314,20 +372,21 @@ func NewSpeed(period time.Duration) *Speed {
 
 		start: time.Now(),
 	}
-	time.AfterFunc(period, s.update)
+	s.Lock()
+	s.timer = time.AfterFunc(period, s.update)
+	s.Unlock()
 	return s
 }
 
 func (s *Speed) update() {
 	s.Lock()
+	defer s.Unlock()
 	if s.closed {
 		return
 	}
 	s.speed = float64(s.count.Swap(0)) / time.Since(s.start).Seconds()
 	s.start = time.Now()
-	s.Unlock()
-
-	time.AfterFunc(s.period, s.update)
+	s.timer.Reset(s.period)
 }
 
 func (s *Speed) Add(n int) {
@@ -341,9 +400,17 @@ func (s *Speed) Speed() float64 {
 	return s.speed
 }
 
+// Closed report whether the update timer stopped
+func (s *Speed) Closed() bool {
+	s.RLock()
+	defer s.RUnlock()
+	return s.closed
+}
+
 func (s *Speed) Close() error {
 	s.Lock()
 	s.closed = true
+	s.timer.Stop()
 	s.Unlock()
 	return nil
 }
@@ -372,3 +439,5 @@ func (l *LinkSpeed) Close() error {
 	l.down.Close()
 	return nil
 }
+
+func (l *LinkSpeed) Closed() bool { return l.up.Closed() && l.down.Closed() }
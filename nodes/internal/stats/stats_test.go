@@ -192,6 +192,26 @@ func Test_PLStats(t *testing.T) {
 		}
 		require.InDelta(t, 0.33, pl.PL(0), 0.01)
 	})
+
+	t.Run("peek", func(t *testing.T) {
+		var pl = NewPLStats(0xff)
+
+		for _, e := range []int{1, 2, 4, 5} {
+			pl.ID(e)
+		}
+		require.InDelta(t, 0.2, float64(pl.Peek(8)), 0.01) // window not complete
+		require.InDelta(t, 0.2, float64(pl.Peek(8)), 0.01)
+
+		for _, e := range []int{6, 8, 9, 10} {
+			pl.ID(e)
+		}
+		require.InDelta(t, 0.2, float64(pl.Peek(8)), 0.01)
+		require.InDelta(t, 0.2, float64(pl.PL(8)), 0.01)
+
+		pl.ID(11)
+		pl.ID(12)
+		require.InDelta(t, 0.2, float64(pl.Peek(8)), 0.01) // latest window
+	})
 }
 
 func Test_PLStats2(t *testing.T) {
@@ -200,6 +220,7 @@ func Test_PLStats2(t *testing.T) {
 
 	t.Run("base", func(t *testing.T) {
 		dup := NewPLStats2(0xfff)
+		defer dup.Close()
 		for i := range 0xfff {
 			require.False(t, dup.ID(i))
 
@@ -210,6 +231,7 @@ func Test_PLStats2(t *testing.T) {
 
 	t.Run("base-loopback", func(t *testing.T) {
 		dup := NewPLStats2(0xff)
+		defer dup.Close()
 		for j := range 0xfff {
 			id := int(uint8(j))
 
@@ -222,6 +244,7 @@ func Test_PLStats2(t *testing.T) {
 
 	t.Run("disorder", func(t *testing.T) {
 		dup := NewPLStats2(0xff)
+		defer dup.Close()
 		for e := range disorder(0xfff) {
 			id := e % 0xff
 
@@ -286,4 +309,12 @@ func Test_Speed(t *testing.T) {
 		require.InDelta(t, 1024.0, s.Speed(), 64)
 	})
 
+	t.Run("close", func(t *testing.T) {
+		s := NewSpeed(time.Millisecond * 100)
+		require.NoError(t, s.Close())
+
+		s.Add(1024)
+		time.Sleep(time.Millisecond * 200)
+		require.Zero(t, s.Speed())
+	})
 }
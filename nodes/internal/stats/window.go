@@ -0,0 +1,67 @@
+package stats
+
+import "math"
+
+// Window sliding window of uint32 loop-id, refer RFC 6479, used to reject
+// replay or duplicate packet. id is expanded by LoopIds, id older than the
+// window is regarded as received. it's not concurrent safe
+type Window struct {
+	ids  *LoopIds
+	top  uint64
+	bits [WindowSize / 64]uint64
+}
+
+// WindowSize max disorder of id
+const WindowSize = 1024
+
+func NewWindow() *Window {
+	return &Window{ids: NewLoopIds(math.MaxUint32)}
+}
+
+// Valid report whether id not received, return expanded index of id. the
+// window is not changed, so id can be checked before authenticated
+func (w *Window) Valid(id uint32) (index uint64, ok bool) {
+	ids := *w.ids
+	i := ids.Expand(int(id))
+	return uint64(i), i >= 0 && w.valid(uint64(i))
+}
+
+// Update record id as received, should be called after Valid
+func (w *Window) Update(id uint32) {
+	if i := w.ids.Expand(int(id)); i >= 0 {
+		w.update(uint64(i))
+	}
+}
+
+// Duplicate record id, return true if it already received, loose id is not
+// regarded as duplicate
+func (w *Window) Duplicate(id uint32) bool {
+	i := w.ids.Expand(int(id))
+	if i < 0 {
+		return false
+	} else if !w.valid(uint64(i)) {
+		return true
+	}
+	w.update(uint64(i))
+	return false
+}
+
+func (w *Window) valid(idx uint64) bool {
+	if idx > w.top {
+		return true
+	} else if w.top-idx >= WindowSize-64 {
+		return false
+	}
+	return w.bits[(idx/64)%uint64(len(w.bits))]&(1<<(idx%64)) == 0
+}
+
+func (w *Window) update(idx uint64) {
+	n := uint64(len(w.bits))
+	if idx > w.top {
+		for i := w.top/64 + 1; i <= idx/64 && i-w.top/64 <= n; i++ {
+			w.bits[i%n] = 0
+		}
+		w.top = idx
+	}
+	w.bits[(idx/64)%n] |= 1 << (idx % 64)
+}
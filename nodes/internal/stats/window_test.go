@@ -0,0 +1,82 @@
+package stats
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Window(t *testing.T) {
+	t.Run("valid not change window", func(t *testing.T) {
+		w := NewWindow()
+		for i := range uint32(0xfff) {
+			idx, ok := w.Valid(i)
+			require.True(t, ok)
+			require.Equal(t, uint64(i), idx)
+			_, ok = w.Valid(i)
+			require.True(t, ok)
+
+			w.Update(i)
+			_, ok = w.Valid(i)
+			require.False(t, ok)
+		}
+	})
+
+	t.Run("too old", func(t *testing.T) {
+		w := NewWindow()
+		w.Update(1)
+		w.Update(WindowSize * 2)
+		_, ok := w.Valid(2)
+		require.False(t, ok)
+	})
+
+	t.Run("loopback", func(t *testing.T) {
+		w := NewWindow()
+		id := uint32(math.MaxUint32 - 8)
+		for range 16 {
+			idx, ok := w.Valid(id)
+			require.True(t, ok)
+			w.Update(id)
+			_, ok = w.Valid(id)
+			require.False(t, ok)
+			require.Less(t, uint64(math.MaxUint32-16), idx)
+			id++
+		}
+	})
+
+	t.Run("duplicate", func(t *testing.T) {
+		w := NewWindow()
+		for i := range uint32(0xfff) {
+			require.False(t, w.Duplicate(i))
+			require.True(t, w.Duplicate(i))
+		}
+	})
+
+	t.Run("duplicate disorder", func(t *testing.T) {
+		w := NewWindow()
+		for _, id := range []uint32{5, 3, 4, 1, 2, 100, 6, 99} {
+			require.False(t, w.Duplicate(id), id)
+		}
+		for _, id := range []uint32{1, 2, 3, 4, 5, 6, 99, 100} {
+			require.True(t, w.Duplicate(id), id)
+		}
+	})
+
+	t.Run("duplicate too old", func(t *testing.T) {
+		w := NewWindow()
+		require.False(t, w.Duplicate(1))
+		require.False(t, w.Duplicate(WindowSize*2))
+		require.True(t, w.Duplicate(2))
+	})
+
+	t.Run("duplicate loopback", func(t *testing.T) {
+		w := NewWindow()
+		id := uint32(math.MaxUint32 - 8)
+		for range 16 {
+			require.False(t, w.Duplicate(id))
+			require.True(t, w.Duplicate(id))
+			id++
+		}
+	})
+}
@@ -0,0 +1,156 @@
+package stun
+
+import (
+	"encoding/binary"
+	"net/netip"
+
+	"github.com/pkg/errors"
+)
+
+// refer RFC 5389
+
+const (
+	headerSize  = 20
+	magicCookie = 0x2112A442
+
+	bindingRequest  uint16 = 0x0001
+	bindingResponse uint16 = 0x0101
+	bindingError    uint16 = 0x0111
+
+	attrMappedAddress    uint16 = 0x0001
+	attrErrorCode        uint16 = 0x0009
+	attrXorMappedAddress uint16 = 0x0020
+)
+
+type TxID [12]byte
+
+// Is check b is a stun message, the first two bits is zero and has magic
+// cookie, it's used to distinguish from other protocol on same port
+func Is(b []byte) bool {
+	return len(b) >= headerSize && b[0]&0b11000000 == 0 &&
+		binary.BigEndian.Uint32(b[4:8]) == magicCookie
+}
+
+type message struct {
+	typ   uint16
+	id    TxID
+	attrs []attr
+}
+
+type attr struct {
+	typ   uint16
+	value []byte
+}
+
+func (m *message) encode() []byte {
+	var b = make([]byte, headerSize, 64)
+	binary.BigEndian.PutUint16(b[0:], m.typ)
+	binary.BigEndian.PutUint32(b[4:], magicCookie)
+	copy(b[8:], m.id[:])
+	for _, a := range m.attrs {
+		b = binary.BigEndian.AppendUint16(b, a.typ)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(a.value)))
+		b = append(b, a.value...)
+		for len(b)%4 != 0 {
+			b = append(b, 0)
+		}
+	}
+	binary.BigEndian.PutUint16(b[2:], uint16(len(b)-headerSize))
+	return b
+}
+
+func (m *message) decode(b []byte) error {
+	if !Is(b) {
+		return errors.New("not stun message")
+	}
+	n := int(binary.BigEndian.Uint16(b[2:]))
+	if n%4 != 0 || headerSize+n > len(b) {
+		return errors.Errorf("invalid stun message length %d", n)
+	}
+
+	m.typ = binary.BigEndian.Uint16(b[0:])
+	copy(m.id[:], b[8:headerSize])
+	m.attrs = m.attrs[:0]
+	for b = b[headerSize : headerSize+n]; len(b) > 0; {
+		if len(b) < 4 {
+			return errors.New("invalid stun attribute")
+		}
+		typ, size := binary.BigEndian.Uint16(b[0:]), int(binary.BigEndian.Uint16(b[2:]))
+		if 4+size > len(b) {
+			return errors.Errorf("invalid stun attribute 0x%04x length %d", typ, size)
+		}
+		m.attrs = append(m.attrs, attr{typ: typ, value: b[4 : 4+size]})
+		b = b[min(len(b), 4+(size+3)/4*4):]
+	}
+	return nil
+}
+
+func (m *message) get(typ uint16) ([]byte, bool) {
+	for _, a := range m.attrs {
+		if a.typ == typ {
+			return a.value, true
+		}
+	}
+	return nil, false
+}
+
+// mapped get mapped address, prefer XOR-MAPPED-ADDRESS
+func (m *message) mapped() (netip.AddrPort, error) {
+	if v, has := m.get(attrXorMappedAddress); has {
+		return decodeAddr(v, true, m.id)
+	} else if v, has := m.get(attrMappedAddress); has {
+		return decodeAddr(v, false, m.id)
+	}
+	return netip.AddrPort{}, errors.New("not mapped address attribute")
+}
+
+// encodeAddr encode (XOR-)MAPPED-ADDRESS attribute value
+func encodeAddr(addr netip.AddrPort, xor bool, id TxID) []byte {
+	var b = []byte{0, 0x01, 0, 0}
+	ip := addr.Addr().Unmap().AsSlice()
+	if len(ip) == 16 {
+		b[1] = 0x02
+	}
+	port := addr.Port()
+	if xor {
+		port ^= magicCookie >> 16
+		xorAddr(ip, id)
+	}
+	binary.BigEndian.PutUint16(b[2:], port)
+	return append(b, ip...)
+}
+
+func decodeAddr(b []byte, xor bool, id TxID) (netip.AddrPort, error) {
+	if len(b) < 4 {
+		return netip.AddrPort{}, errors.New("invalid mapped address")
+	}
+	var size int
+	switch b[1] {
+	case 0x01:
+		size = 4
+	case 0x02:
+		size = 16
+	default:
+		return netip.AddrPort{}, errors.Errorf("invalid address family %d", b[1])
+	}
+	if len(b) < 4+size {
+		return netip.AddrPort{}, errors.New("invalid mapped address")
+	}
+
+	port := binary.BigEndian.Uint16(b[2:])
+	ip := append([]byte{}, b[4:4+size]...)
+	if xor {
+		port ^= magicCookie >> 16
+		xorAddr(ip, id)
+	}
+	addr, _ := netip.AddrFromSlice(ip)
+	return netip.AddrPortFrom(addr, port), nil
+}
+
+func xorAddr(ip []byte, id TxID) {
+	var key = binary.BigEndian.AppendUint32(make([]byte, 0, 16), magicCookie)
+	key = append(key, id[:]...)
+	for i := range ip {
+		ip[i] ^= key[i]
+	}
+}
@@ -0,0 +1,170 @@
+package stun
+
+import (
+	"crypto/rand"
+	"net"
+	"net/netip"
+	"time"
+
+	"github.com/lysShub/anton-planet-accelerator/conn"
+	"github.com/lysShub/netkit/errorx"
+	"github.com/lysShub/netkit/packet"
+	"github.com/pkg/errors"
+)
+
+// NAT mapping behavior, refer RFC 4787
+type NAT uint8
+
+const (
+	Unknown     NAT = iota
+	None            // not behind nat, mapped address is local address
+	Independent     // endpoint-independent mapping, such as full/restricted cone
+	Dependent       // address(and port)-dependent mapping, such as symmetric
+)
+
+func (n NAT) String() string {
+	switch n {
+	case None:
+		return "none"
+	case Independent:
+		return "endpoint-independent"
+	case Dependent:
+		return "endpoint-dependent"
+	default:
+		return "unknown"
+	}
+}
+
+type deadline interface {
+	SetReadDeadline(t time.Time) error
+}
+
+// Binding send binding request to server, return mapped address of conn. conn
+// must not be read by others during binding, and support read deadline.
+func Binding(c conn.Conn, server netip.AddrPort, timeout time.Duration) (netip.AddrPort, error) {
+	d, ok := c.(deadline)
+	if !ok {
+		return netip.AddrPort{}, errors.Errorf("%T not support read deadline", c)
+	}
+	defer d.SetReadDeadline(time.Time{})
+
+	var req = message{typ: bindingRequest}
+	if _, err := rand.Read(req.id[:]); err != nil {
+		return netip.AddrPort{}, errors.WithStack(err)
+	}
+	b := req.encode()
+
+	var (
+		pkt   = packet.Make(0, 1500)
+		end   = time.Now().Add(timeout)
+		rto   = time.Millisecond * 500 // retransmission timeout
+		resp  message
+		start = time.Now()
+	)
+	for time.Now().Before(end) {
+		if err := c.WriteToAddrPort(pkt.Sets(0, 0).Append(b...), server); err != nil {
+			return netip.AddrPort{}, errors.WithStack(err)
+		}
+
+		dl := time.Now().Add(rto)
+		if dl.After(end) {
+			dl = end
+		}
+		if err := d.SetReadDeadline(dl); err != nil {
+			return netip.AddrPort{}, errors.WithStack(err)
+		}
+		rto *= 2
+
+		for {
+			raddr, err := c.ReadFromAddrPort(pkt.Sets(0, 1500))
+			if err != nil {
+				if errors.Is(err, net.ErrClosed) {
+					return netip.AddrPort{}, err
+				} else if isTimeout(err) {
+					break // retransmit
+				}
+				return netip.AddrPort{}, errors.WithStack(err)
+			}
+			if raddr != server || resp.decode(pkt.Bytes()) != nil || resp.id != req.id {
+				continue
+			}
+
+			switch resp.typ {
+			case bindingResponse:
+				return resp.mapped()
+			case bindingError:
+				code, _ := resp.get(attrErrorCode)
+				return netip.AddrPort{}, errors.Errorf("stun server %s error %x", server, code)
+			default:
+				continue
+			}
+		}
+	}
+	return netip.AddrPort{}, errorx.WrapTemp(errors.Errorf("stun server %s timeout %s", server, time.Since(start)))
+}
+
+func isTimeout(err error) bool {
+	var e net.Error
+	return errors.As(err, &e) && e.Timeout()
+}
+
+// Discover discover mapped address and nat mapping behavior by binding with
+// servers, detect mapping behavior require at least two servers.
+func Discover(c conn.Conn, servers []netip.AddrPort, timeout time.Duration) (mapped netip.AddrPort, nat NAT, err error) {
+	if len(servers) == 0 {
+		return netip.AddrPort{}, Unknown, errors.New("require stun servers")
+	}
+
+	var maps []netip.AddrPort
+	for _, server := range servers {
+		addr, err := Binding(c, server, timeout)
+		if err != nil {
+			if errorx.Temporary(err) {
+				continue
+			}
+			return netip.AddrPort{}, Unknown, err
+		}
+		maps = append(maps, addr)
+		if len(maps) >= 2 {
+			break
+		}
+	}
+	if len(maps) == 0 {
+		return netip.AddrPort{}, Unknown, errors.Errorf("all stun servers %v timeout", servers)
+	}
+
+	mapped = maps[0]
+	switch {
+	case local(c.LocalAddr(), mapped):
+		nat = None
+	case len(maps) < 2:
+		nat = Unknown
+	case maps[0] == maps[1]:
+		nat = Independent
+	default:
+		nat = Dependent
+	}
+	return mapped, nat, nil
+}
+
+// local check mapped address is local address
+func local(laddr, mapped netip.AddrPort) bool {
+	if laddr.Port() != mapped.Port() {
+		return false
+	} else if !laddr.Addr().IsUnspecified() {
+		return laddr.Addr().Unmap() == mapped.Addr().Unmap()
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, e := range addrs {
+		if ip, ok := e.(*net.IPNet); ok {
+			if addr, ok := netip.AddrFromSlice(ip.IP); ok && addr.Unmap() == mapped.Addr().Unmap() {
+				return true
+			}
+		}
+	}
+	return false
+}
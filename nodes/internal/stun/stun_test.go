@@ -0,0 +1,173 @@
+package stun
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/lysShub/anton-planet-accelerator/conn/udp"
+	"github.com/lysShub/netkit/errorx"
+	"github.com/stretchr/testify/require"
+)
+
+// server in-process stun server, map return the responded mapped address
+func server(t *testing.T, mapping func(src netip.AddrPort) netip.AddrPort) netip.AddrPort {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+
+	go func() {
+		var b = make([]byte, 1500)
+		for {
+			n, src, err := conn.ReadFromUDPAddrPort(b)
+			if err != nil {
+				return
+			}
+			var req message
+			if req.decode(b[:n]) != nil || req.typ != bindingRequest {
+				continue
+			}
+
+			var resp = message{typ: bindingResponse, id: req.id, attrs: []attr{
+				{typ: 0x8022, value: []byte("test")}, // SOFTWARE
+				{typ: attrXorMappedAddress, value: encodeAddr(mapping(src), true, req.id)},
+			}}
+			conn.WriteToUDPAddrPort(resp.encode(), src)
+		}
+	}()
+	return netip.MustParseAddrPort(conn.LocalAddr().String())
+}
+
+func Test_Message(t *testing.T) {
+	var id = TxID{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
+
+	for _, addr := range []string{"1.2.3.4:5678", "[2001:db8::1]:19986"} {
+		var m = message{typ: bindingResponse, id: id, attrs: []attr{
+			{typ: attrXorMappedAddress, value: encodeAddr(netip.MustParseAddrPort(addr), true, id)},
+		}}
+		b := m.encode()
+		require.True(t, Is(b))
+		require.Zero(t, len(b)%4)
+
+		var m2 message
+		require.NoError(t, m2.decode(b))
+		require.Equal(t, m.typ, m2.typ)
+		require.Equal(t, m.id, m2.id)
+
+		mapped, err := m2.mapped()
+		require.NoError(t, err)
+		require.Equal(t, addr, mapped.String())
+	}
+
+	t.Run("mapped address", func(t *testing.T) {
+		var m = message{typ: bindingResponse, id: id, attrs: []attr{
+			{typ: attrMappedAddress, value: encodeAddr(netip.MustParseAddrPort("1.2.3.4:5678"), false, id)},
+		}}
+
+		var m2 message
+		require.NoError(t, m2.decode(m.encode()))
+		mapped, err := m2.mapped()
+		require.NoError(t, err)
+		require.Equal(t, "1.2.3.4:5678", mapped.String())
+	})
+
+	t.Run("not stun", func(t *testing.T) {
+		require.False(t, Is(make([]byte, headerSize)))
+		require.False(t, Is([]byte{0, 1, 0, 0, 0x21, 0x12, 0xa4}))
+
+		b := (&message{typ: bindingRequest}).encode()
+		b[0] |= 0b01000000
+		require.False(t, Is(b))
+	})
+
+	t.Run("invalid length", func(t *testing.T) {
+		b := (&message{typ: bindingRequest, attrs: []attr{{typ: 1, value: []byte{1, 2, 3, 4}}}}).encode()
+
+		var m message
+		require.Error(t, m.decode(b[:len(b)-4]))
+	})
+}
+
+func Test_Binding(t *testing.T) {
+	c, err := udp.Bind(netip.MustParseAddrPort("127.0.0.1:0"))
+	require.NoError(t, err)
+	defer c.Close()
+
+	t.Run("base", func(t *testing.T) {
+		saddr := server(t, func(src netip.AddrPort) netip.AddrPort { return src })
+
+		mapped, err := Binding(c, saddr, time.Second)
+		require.NoError(t, err)
+		require.Equal(t, c.LocalAddr(), mapped)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		require.NoError(t, err)
+		defer conn.Close()
+
+		_, err = Binding(c, netip.MustParseAddrPort(conn.LocalAddr().String()), time.Millisecond*200)
+		require.True(t, errorx.Temporary(err))
+	})
+}
+
+func Test_Discover(t *testing.T) {
+	c, err := udp.Bind(netip.MustParseAddrPort("127.0.0.1:0"))
+	require.NoError(t, err)
+	defer c.Close()
+
+	public := netip.MustParseAddrPort("8.8.8.8:19986")
+	independent := func(src netip.AddrPort) netip.AddrPort { return public }
+
+	t.Run("none", func(t *testing.T) {
+		echo := func(src netip.AddrPort) netip.AddrPort { return src }
+		s1, s2 := server(t, echo), server(t, echo)
+
+		mapped, nat, err := Discover(c, []netip.AddrPort{s1, s2}, time.Second)
+		require.NoError(t, err)
+		require.Equal(t, c.LocalAddr(), mapped)
+		require.Equal(t, None, nat)
+	})
+
+	t.Run("independent", func(t *testing.T) {
+		s1, s2 := server(t, independent), server(t, independent)
+
+		mapped, nat, err := Discover(c, []netip.AddrPort{s1, s2}, time.Second)
+		require.NoError(t, err)
+		require.Equal(t, public, mapped)
+		require.Equal(t, Independent, nat)
+	})
+
+	t.Run("dependent", func(t *testing.T) {
+		var port uint16 = 10000
+		dependent := func(src netip.AddrPort) netip.AddrPort {
+			port++
+			return netip.AddrPortFrom(public.Addr(), port)
+		}
+		s1, s2 := server(t, dependent), server(t, dependent)
+
+		mapped, nat, err := Discover(c, []netip.AddrPort{s1, s2}, time.Second)
+		require.NoError(t, err)
+		require.Equal(t, public.Addr(), mapped.Addr())
+		require.Equal(t, Dependent, nat)
+	})
+
+	t.Run("single server", func(t *testing.T) {
+		mapped, nat, err := Discover(c, []netip.AddrPort{server(t, independent)}, time.Second)
+		require.NoError(t, err)
+		require.Equal(t, public, mapped)
+		require.Equal(t, Unknown, nat)
+	})
+
+	t.Run("skip timeout server", func(t *testing.T) {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		require.NoError(t, err)
+		defer conn.Close()
+		dead := netip.MustParseAddrPort(conn.LocalAddr().String())
+
+		mapped, _, err := Discover(c, []netip.AddrPort{dead, server(t, independent)}, time.Millisecond*200)
+		require.NoError(t, err)
+		require.Equal(t, public, mapped)
+	})
+}
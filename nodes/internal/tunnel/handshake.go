@@ -0,0 +1,108 @@
+package tunnel
+
+import (
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+// session handshake refer Noise NK pattern, client know x25519 static public
+// key of gateway, hello token is sealed by agreement of client ephemeral key
+// and gateway static key (es), session keys are derived from es and agreement
+// of ephemeral keys (ee), gateway prove it hold the static key by confirm tag,
+// so man-in-the-middle can't read the token or replace the ephemeral keys.
+
+// KeyPair generate x25519 key pair for session handshake
+func KeyPair() (priv *ecdh.PrivateKey, pub [32]byte, err error) {
+	priv, err = ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, pub, errors.WithStack(err)
+	}
+	copy(pub[:], priv.PublicKey().Bytes())
+	return priv, pub, nil
+}
+
+// ECDH x25519 key agreement of priv and peer public key
+func ECDH(priv *ecdh.PrivateKey, peer [32]byte) ([]byte, error) {
+	pub, err := ecdh.X25519().NewPublicKey(peer[:])
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	secret, err := priv.ECDH(pub)
+	return secret, errors.WithStack(err)
+}
+
+// Keys session keys, expanded from the same secrets by different info, so
+// they are independent
+type Keys struct {
+	MAC     []byte   // mac trailer key
+	Tunnel  []byte   // tunnel key
+	Confirm [16]byte // gateway confirm tag, transmitted in session reply
+}
+
+// Derive derive session keys from secrets of x25519 key agreements, salt
+// bind keys to the session, never transmitted
+func Derive(salt []byte, secrets ...[]byte) (Keys, error) {
+	var ikm []byte
+	for _, e := range secrets {
+		ikm = append(ikm, e...)
+	}
+
+	var keys = Keys{MAC: make([]byte, sha256.Size), Tunnel: make([]byte, chacha20poly1305.KeySize)}
+	for _, e := range []struct {
+		info string
+		key  []byte
+	}{{"bvvd mac", keys.MAC}, {"bvvd tunnel", keys.Tunnel}, {"bvvd confirm", keys.Confirm[:]}} {
+		r := hkdf.New(sha256.New, ikm, salt, []byte(e.info))
+		if _, err := io.ReadFull(r, e.key); err != nil {
+			return Keys{}, errors.WithStack(err)
+		}
+	}
+	return keys, nil
+}
+
+// SealToken seal hello token by es, priv is client ephemeral key, static is
+// gateway static public key, ad is authenticated plaintext of hello
+func SealToken(priv *ecdh.PrivateKey, static [32]byte, token, ad []byte) ([]byte, error) {
+	aead, err := helloAEAD(priv, static, priv.PublicKey().Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nil, make([]byte, aead.NonceSize()), token, ad), nil
+}
+
+// OpenToken open hello token sealed by SealToken, static is gateway static
+// key, peer is client ephemeral public key
+func OpenToken(static *ecdh.PrivateKey, peer [32]byte, sealed, ad []byte) ([]byte, error) {
+	aead, err := helloAEAD(static, peer, peer[:])
+	if err != nil {
+		return nil, err
+	}
+	token, err := aead.Open(nil, make([]byte, aead.NonceSize()), sealed, ad)
+	if err != nil {
+		return nil, errors.New("invalid sealed token")
+	}
+	return token, nil
+}
+
+// helloAEAD aead of hello token, client ephemeral key is used once, so
+// zero nonce is safe
+func helloAEAD(priv *ecdh.PrivateKey, peer [32]byte, client []byte) (cipher.AEAD, error) {
+	es, err := ECDH(priv, peer)
+	if err != nil {
+		return nil, err
+	}
+	var key = make([]byte, chacha20poly1305.KeySize)
+	r := hkdf.New(sha256.New, es, client, []byte("bvvd hello"))
+	if _, err := io.ReadFull(r, key); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	aead, err := chacha20poly1305.New(key)
+	return aead, errors.WithStack(err)
+}
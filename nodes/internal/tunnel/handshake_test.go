@@ -0,0 +1,58 @@
+package tunnel_test
+
+import (
+	"testing"
+
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/tunnel"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Handshake(t *testing.T) {
+	static, spub, err := tunnel.KeyPair()
+	require.NoError(t, err)
+	cpriv, cpub, err := tunnel.KeyPair()
+	require.NoError(t, err)
+	gpriv, gpub, err := tunnel.KeyPair()
+	require.NoError(t, err)
+
+	t.Run("token", func(t *testing.T) {
+		ad := []byte("hello")
+		sealed, err := tunnel.SealToken(cpriv, spub, []byte("t0ken"), ad)
+		require.NoError(t, err)
+
+		token, err := tunnel.OpenToken(static, cpub, sealed, ad)
+		require.NoError(t, err)
+		require.Equal(t, "t0ken", string(token))
+
+		_, err = tunnel.OpenToken(static, cpub, sealed, []byte("other"))
+		require.Error(t, err)
+		_, err = tunnel.OpenToken(gpriv, cpub, sealed, ad)
+		require.Error(t, err)
+	})
+
+	t.Run("derive", func(t *testing.T) {
+		var salt = []byte("salt")
+
+		ee1, err := tunnel.ECDH(cpriv, gpub)
+		require.NoError(t, err)
+		es1, err := tunnel.ECDH(cpriv, spub)
+		require.NoError(t, err)
+		keys1, err := tunnel.Derive(salt, ee1, es1)
+		require.NoError(t, err)
+
+		ee2, err := tunnel.ECDH(gpriv, cpub)
+		require.NoError(t, err)
+		es2, err := tunnel.ECDH(static, cpub)
+		require.NoError(t, err)
+		keys2, err := tunnel.Derive(salt, ee2, es2)
+		require.NoError(t, err)
+
+		require.Equal(t, keys1, keys2)
+		require.NotEqual(t, keys1.MAC, keys1.Tunnel)
+
+		// without static key can't confirm
+		keys3, err := tunnel.Derive(salt, ee2)
+		require.NoError(t, err)
+		require.NotEqual(t, keys1.Confirm, keys3.Confirm)
+	})
+}
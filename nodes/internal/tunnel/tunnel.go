@@ -0,0 +1,238 @@
+package tunnel
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+
+	"github.com/lysShub/anton-planet-accelerator/bvvd"
+	"github.com/lysShub/anton-planet-accelerator/conn"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/stats"
+	"github.com/lysShub/netkit/packet"
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Conn encrypted tunnel, wrap conn.Conn, packets to/from peer that has key
+// will be sealed by ChaCha20-Poly1305, packet format:
+//
+//	flag(1) loop-id(4) ciphertext tag(16)
+//
+// flag is random byte with the proto bits of bvvd kind-proto all set, that
+// is invalid for plaintext bvvd header, so sealed packet can be distinguished.
+// loop-id is expanded by stats.Window to the nonce index, and replay packet
+// is rejected by the window.
+//
+// session handshake (bvvd.Session) is not sealed by tunnel, its bvvd header
+// is always plaintext, only hello token is sealed by gateway static key, and
+// fixed size is hidden by random padding.
+type Conn struct {
+	raw       conn.Conn
+	initiator bool
+
+	mu    sync.RWMutex
+	peers map[netip.AddrPort]*peer
+}
+
+var _ conn.Conn = (*Conn)(nil)
+
+// Overhead sealed packet size overhead
+const Overhead = prefixSize + chacha20poly1305.Overhead
+
+const (
+	prefixSize = 1 + 4
+	flagMask   = 0b00110000
+)
+
+// New wrap raw conn, initiator indicate client side, decide nonce direction
+func New(raw conn.Conn, initiator bool) *Conn {
+	return &Conn{
+		raw:       raw,
+		initiator: initiator,
+		peers:     map[netip.AddrPort]*peer{},
+	}
+}
+
+// Add set peer tunnel key, reset the replay window if key updated
+func (c *Conn) Add(addr netip.AddrPort, key []byte) error {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.peers[addr] = &peer{aead: aead, window: stats.NewWindow()}
+	return nil
+}
+
+// Del delete peer tunnel key, following packets will be plaintext
+func (c *Conn) Del(addr netip.AddrPort) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.peers, addr)
+}
+
+// Sealed peer has tunnel key
+func (c *Conn) Sealed(addr netip.AddrPort) bool {
+	return c.peer(addr) != nil
+}
+
+func (c *Conn) peer(addr netip.AddrPort) *peer {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.peers[addr]
+}
+
+func (c *Conn) ReadFromAddrPort(pkt *packet.Packet) (netip.AddrPort, error) {
+	head, data := pkt.Head(), pkt.Data()
+	for {
+		addr, err := c.raw.ReadFromAddrPort(pkt.Sets(head, data))
+		if err != nil {
+			return addr, err
+		} else if c.valid(pkt, addr) {
+			return addr, nil
+		}
+		// drop invalid or replay packet
+	}
+}
+
+// ReadBatch refer ReadFromAddrPort, invalid or replay packets are removed
+// from msgs, Packet of msgs should be prepared with same head and data
+func (c *Conn) ReadBatch(msgs []conn.Message) (int, error) {
+	if len(msgs) == 0 {
+		return 0, nil
+	}
+	head, data := msgs[0].Packet.Head(), msgs[0].Packet.Data()
+	for {
+		n, err := c.raw.ReadBatch(msgs)
+		if err != nil {
+			return 0, err
+		}
+
+		var k int
+		for i := range msgs[:n] {
+			if c.valid(msgs[i].Packet, msgs[i].Addr) {
+				msgs[i], msgs[k] = msgs[k], msgs[i]
+				k++
+			}
+		}
+		if k > 0 {
+			return k, nil
+		}
+		for _, e := range msgs[:n] {
+			e.Packet.Sets(head, data)
+		}
+	}
+}
+
+// valid open sealed packet, report whether the packet should be returned
+func (c *Conn) valid(pkt *packet.Packet, addr netip.AddrPort) bool {
+	if pkt.Data() == 0 {
+		return true
+	}
+
+	p := c.peer(addr)
+	if sealed(pkt.Bytes()) {
+		return p != nil && p.open(pkt, !c.initiator)
+	}
+	return p == nil || plaintext(pkt.Bytes())
+}
+
+func (c *Conn) WriteToAddrPort(pkt *packet.Packet, to netip.AddrPort) error {
+	if p := c.peer(to); p != nil && !plaintext(pkt.Bytes()) {
+		if err := p.seal(pkt, c.initiator); err != nil {
+			return err
+		}
+	}
+	return c.raw.WriteToAddrPort(pkt, to)
+}
+
+// WriteBatch refer WriteToAddrPort
+func (c *Conn) WriteBatch(msgs []conn.Message) (int, error) {
+	for _, e := range msgs {
+		if p := c.peer(e.Addr); p != nil && !plaintext(e.Packet.Bytes()) {
+			if err := p.seal(e.Packet, c.initiator); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return c.raw.WriteBatch(msgs)
+}
+
+func (c *Conn) LocalAddr() netip.AddrPort { return c.raw.LocalAddr() }
+func (c *Conn) Close() error              { return c.raw.Close() }
+
+func sealed(b []byte) bool {
+	return len(b) > 0 && b[0]&flagMask == flagMask
+}
+
+// plaintext packet always transmit as plaintext
+func plaintext(b []byte) bool {
+	return len(b) >= bvvd.Size && bvvd.Bvvd(b).Kind() == bvvd.Session
+}
+
+type peer struct {
+	aead cipher.AEAD
+	send atomic.Uint64
+
+	mu     sync.Mutex
+	window *stats.Window
+}
+
+func (p *peer) seal(pkt *packet.Packet, initiator bool) error {
+	idx := p.send.Add(1) - 1
+
+	var tag [chacha20poly1305.Overhead]byte
+	n := pkt.Data()
+	b := pkt.Append(tag[:]...).Bytes()
+	p.aead.Seal(b[:0], nonce(idx, initiator), b[:n], nil)
+
+	var prefix [prefixSize]byte
+	if _, err := rand.Read(prefix[:1]); err != nil {
+		return errors.WithStack(err)
+	}
+	prefix[0] |= flagMask
+	binary.BigEndian.PutUint32(prefix[1:], uint32(idx))
+	pkt.Attach(prefix[:]...)
+	return nil
+}
+
+func (p *peer) open(pkt *packet.Packet, initiator bool) bool {
+	b := pkt.Bytes()
+	if len(b) < Overhead {
+		return false
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	// update window after authenticated
+	id := binary.BigEndian.Uint32(b[1:prefixSize])
+	i, ok := p.window.Valid(id)
+	if !ok {
+		return false
+	}
+
+	_, err := p.aead.Open(b[prefixSize:prefixSize], nonce(i, initiator), b[prefixSize:], nil)
+	if err != nil {
+		return false
+	}
+	p.window.Update(id)
+
+	pkt.DetachN(prefixSize)
+	pkt.SetData(pkt.Data() - chacha20poly1305.Overhead)
+	return true
+}
+
+func nonce(idx uint64, initiator bool) []byte {
+	var b = make([]byte, chacha20poly1305.NonceSize)
+	if initiator {
+		b[0] = 1
+	}
+	binary.BigEndian.PutUint64(b[4:], idx)
+	return b
+}
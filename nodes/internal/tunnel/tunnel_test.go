@@ -0,0 +1,236 @@
+package tunnel_test
+
+import (
+	"crypto/rand"
+	"net/netip"
+	"testing"
+	"time"
+
+	"github.com/lysShub/anton-planet-accelerator/bvvd"
+	"github.com/lysShub/anton-planet-accelerator/conn"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/msg"
+	"github.com/lysShub/anton-planet-accelerator/nodes/internal/tunnel"
+	"github.com/lysShub/netkit/packet"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_Tunnel(t *testing.T) {
+	var (
+		caddr = netip.MustParseAddrPort("10.0.0.1:19986")
+		gaddr = netip.MustParseAddrPort("10.0.0.2:19986")
+		key   = make([]byte, 32)
+	)
+	rand.Read(key)
+
+	var data = func(t *testing.T) *packet.Packet {
+		var pkt = packet.Make(64, 0, 64)
+		require.NoError(t, (&bvvd.Fields{
+			Kind:    bvvd.PingGateway,
+			Forward: netip.AddrPortFrom(netip.IPv4Unspecified(), 0),
+		}).Encode(pkt))
+		var b = make([]byte, 32)
+		rand.Read(b)
+		return pkt.Append(b...)
+	}
+
+	t.Run("seal open", func(t *testing.T) {
+		craw, graw := pipe(caddr, gaddr)
+		c, g := tunnel.New(craw, true), tunnel.New(graw, false)
+		require.NoError(t, c.Add(gaddr, key))
+		require.NoError(t, g.Add(caddr, key))
+
+		for i := 0; i < 4; i++ {
+			pkt := data(t)
+			exp := append([]byte{}, pkt.Bytes()...)
+			require.NoError(t, c.WriteToAddrPort(pkt, gaddr))
+			require.NotEqual(t, exp, graw.last())
+
+			var rpkt = packet.Make(64, 1536)
+			addr, err := g.ReadFromAddrPort(rpkt)
+			require.NoError(t, err)
+			require.Equal(t, caddr, addr)
+			require.Equal(t, exp, rpkt.Bytes())
+
+			// reply
+			require.NoError(t, g.WriteToAddrPort(rpkt, caddr))
+			addr, err = c.ReadFromAddrPort(packet.Make(64, 1536))
+			require.NoError(t, err)
+			require.Equal(t, gaddr, addr)
+		}
+	})
+
+	t.Run("resend", func(t *testing.T) {
+		var gaddr2 = netip.MustParseAddrPort("10.0.0.3:19986")
+		var key2 = make([]byte, 32)
+		rand.Read(key2)
+
+		craw, graw := pipe(caddr, gaddr)
+		c := tunnel.New(craw, true)
+		require.NoError(t, c.Add(gaddr, key))
+		require.NoError(t, c.Add(gaddr2, key2))
+
+		// packet is sealed in place, copy it before every send
+		pkt := data(t)
+		exp := append([]byte{}, pkt.Bytes()...)
+		for _, gaddr := range []netip.AddrPort{gaddr, gaddr2} {
+			dup := packet.Make(64, 0, 64).Append(exp...)
+			require.NoError(t, c.WriteToAddrPort(dup, gaddr))
+			require.NotEqual(t, exp, dup.Bytes())
+		}
+
+		for _, key := range [][]byte{key, key2} {
+			g := tunnel.New(graw, false)
+			require.NoError(t, g.Add(caddr, key))
+
+			var rpkt = packet.Make(64, 1536)
+			addr, err := g.ReadFromAddrPort(rpkt)
+			require.NoError(t, err)
+			require.Equal(t, caddr, addr)
+			require.Equal(t, exp, rpkt.Bytes())
+		}
+	})
+
+	t.Run("replay", func(t *testing.T) {
+		craw, graw := pipe(caddr, gaddr)
+		c, g := tunnel.New(craw, true), tunnel.New(graw, false)
+		require.NoError(t, c.Add(gaddr, key))
+		require.NoError(t, g.Add(caddr, key))
+
+		require.NoError(t, c.WriteToAddrPort(data(t), gaddr))
+		sealed := graw.last()
+
+		_, err := g.ReadFromAddrPort(packet.Make(64, 1536))
+		require.NoError(t, err)
+
+		graw.push(sealed, caddr)
+		_, err = g.ReadFromAddrPort(packet.Make(64, 1536))
+		require.True(t, errors.Is(err, errTimeout), err)
+	})
+
+	t.Run("disorder", func(t *testing.T) {
+		craw, graw := pipe(caddr, gaddr)
+		c, g := tunnel.New(craw, true), tunnel.New(graw, false)
+		require.NoError(t, c.Add(gaddr, key))
+		require.NoError(t, g.Add(caddr, key))
+
+		var sealed [][]byte
+		for i := 0; i < 8; i++ {
+			require.NoError(t, c.WriteToAddrPort(data(t), gaddr))
+			sealed = append(sealed, graw.last())
+		}
+		graw.reset()
+		for _, i := range []int{1, 0, 3, 2, 7, 4, 6, 5} {
+			graw.push(sealed[i], caddr)
+			_, err := g.ReadFromAddrPort(packet.Make(64, 1536))
+			require.NoError(t, err)
+		}
+	})
+
+	t.Run("tampered", func(t *testing.T) {
+		craw, graw := pipe(caddr, gaddr)
+		c, g := tunnel.New(craw, true), tunnel.New(graw, false)
+		require.NoError(t, c.Add(gaddr, key))
+		require.NoError(t, g.Add(caddr, key))
+
+		require.NoError(t, c.WriteToAddrPort(data(t), gaddr))
+		sealed := graw.last()
+		graw.reset()
+		sealed[len(sealed)/2] ^= 0x01
+		graw.push(sealed, caddr)
+
+		_, err := g.ReadFromAddrPort(packet.Make(64, 1536))
+		require.True(t, errors.Is(err, errTimeout), err)
+	})
+
+	t.Run("plaintext", func(t *testing.T) {
+		craw, graw := pipe(caddr, gaddr)
+		c, g := tunnel.New(craw, true), tunnel.New(graw, false)
+		require.NoError(t, g.Add(caddr, key))
+
+		// client not has key
+		require.NoError(t, c.WriteToAddrPort(data(t), gaddr))
+		_, err := g.ReadFromAddrPort(packet.Make(64, 1536))
+		require.True(t, errors.Is(err, errTimeout), err)
+
+		// session handshake always plaintext
+		var pkt = packet.Make(64, 0, 64)
+		var hello = msg.Hello{Token: "token"}
+		var m = msg.Fields{MsgID: 1, Payload: &hello}
+		m.Kind = bvvd.Session
+		require.NoError(t, m.Encode(pkt))
+		require.NoError(t, c.WriteToAddrPort(pkt, gaddr))
+		_, err = g.ReadFromAddrPort(packet.Make(64, 1536))
+		require.NoError(t, err)
+
+		// peer without key
+		g.Del(caddr)
+		require.False(t, g.Sealed(caddr))
+		require.NoError(t, c.WriteToAddrPort(data(t), gaddr))
+		_, err = g.ReadFromAddrPort(packet.Make(64, 1536))
+		require.NoError(t, err)
+	})
+}
+
+var errTimeout = errors.New("timeout")
+
+// mockConn in memory datagram conn
+type mockConn struct {
+	laddr netip.AddrPort
+	peer  *mockConn
+	ch    chan mockPacket
+	sent  []byte
+}
+
+type mockPacket struct {
+	b    []byte
+	from netip.AddrPort
+}
+
+func pipe(a, b netip.AddrPort) (*mockConn, *mockConn) {
+	c1 := &mockConn{laddr: a, ch: make(chan mockPacket, 64)}
+	c2 := &mockConn{laddr: b, ch: make(chan mockPacket, 64)}
+	c1.peer, c2.peer = c2, c1
+	return c1, c2
+}
+
+func (c *mockConn) ReadFromAddrPort(pkt *packet.Packet) (netip.AddrPort, error) {
+	select {
+	case p := <-c.ch:
+		n := copy(pkt.Bytes(), p.b)
+		pkt.SetData(n)
+		return p.from, nil
+	case <-time.After(time.Millisecond * 100):
+		return netip.AddrPort{}, errTimeout
+	}
+}
+
+func (c *mockConn) WriteToAddrPort(pkt *packet.Packet, to netip.AddrPort) error {
+	b := append([]byte{}, pkt.Bytes()...)
+	c.peer.sent = b
+	c.peer.push(b, c.laddr)
+	return nil
+}
+
+// last get last packet sent to c
+func (c *mockConn) last() []byte { return append([]byte{}, c.sent...) }
+
+func (c *mockConn) push(b []byte, from netip.AddrPort) {
+	c.ch <- mockPacket{b: append([]byte{}, b...), from: from}
+}
+
+func (c *mockConn) reset() {
+	for len(c.ch) > 0 {
+		<-c.ch
+	}
+}
+
+func (c *mockConn) ReadBatch(msgs []conn.Message) (int, error) {
+	return conn.ReadBatchFallback(c, msgs)
+}
+func (c *mockConn) WriteBatch(msgs []conn.Message) (int, error) {
+	return conn.WriteBatchFallback(c, msgs)
+}
+
+func (c *mockConn) LocalAddr() netip.AddrPort { return c.laddr }
+func (c *mockConn) Close() error              { return nil }
@@ -1,10 +1,61 @@
 package nodes
 
-import "time"
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
 
 const (
-	GatewayNetwork = "udp4"
-	ForwardNetwork = "udp4"
-	PLScale        = 64
-	Keepalive      = time.Second * 30
+	PLScale   = 64
+	Keepalive = time.Second * 30
+	BatchSize = 32 // messages of batch read/write in hot loops
 )
+
+// transport of client-gateway and gateway-forward
+const (
+	UDP = "udp"
+	TCP = "tcp" // fake tcp, refer conn/tcp
+
+	// Auto client try udp first, fall back to tcp if gateways unreachable
+	Auto = "auto"
+)
+
+// GatewayNetwork conn.Bind network of gateway listen client-gateway
+// transport, empty is udp
+func GatewayNetwork(transport string) (string, error) {
+	switch transport {
+	case "", UDP:
+		return "udp", nil // dual-stack, client maybe ipv6
+	case TCP:
+		return "rtcp", nil // only ipv4
+	default:
+		return "", errors.Errorf("not support transport %q", transport)
+	}
+}
+
+// ClientNetwork conn.Bind network of client dial client-gateway transport,
+// empty is udp. only ipv4, client inject downlink packets with the bound
+// local address, it requires ipv4 address
+func ClientNetwork(transport string) (string, error) {
+	switch transport {
+	case "", UDP:
+		return "udp4", nil
+	case TCP:
+		return "rtcp", nil
+	default:
+		return "", errors.Errorf("not support transport %q", transport)
+	}
+}
+
+// ForwardNetwork conn.Bind network of gateway-forward transport, empty is udp
+func ForwardNetwork(transport string) (string, error) {
+	switch transport {
+	case "", UDP:
+		return "udp", nil // dual-stack, forward maybe ipv6
+	case TCP:
+		return "rtcp", nil // only ipv4
+	default:
+		return "", errors.Errorf("not support transport %q", transport)
+	}
+}